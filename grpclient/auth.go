@@ -0,0 +1,74 @@
+package grpclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenRefreshWindow is how long before its expiry a token is refreshed.
+var tokenRefreshWindow = 10 * time.Second
+
+// TokenSource returns a bearer token and the time it expires at.
+// A zero expiry means the token never expires.
+type TokenSource func(ctx context.Context) (token string, expiry time.Time, err error)
+
+// StaticToken returns a TokenSource of a token that never expires.
+func StaticToken(token string) TokenSource {
+	return func(context.Context) (string, time.Time, error) {
+		return token, time.Time{}, nil
+	}
+}
+
+// tokenCredentials implements "credentials.PerRPCCredentials", attaching the
+// token as "authorization: Bearer <token>" metadata to every request.
+type tokenCredentials struct {
+	source TokenSource
+
+	// allowInsecure permits sending the token over insecure connections
+	allowInsecure bool
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func newTokenCredentials(source TokenSource, allowInsecure bool) *tokenCredentials {
+	return &tokenCredentials{source: source, allowInsecure: allowInsecure}
+}
+
+func (tc *tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := tc.getToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+// RequireTransportSecurity returns true unless insecure connections are explicitly
+// allowed, so that tokens are never sent in plaintext by accident.
+func (tc *tokenCredentials) RequireTransportSecurity() bool {
+	return !tc.allowInsecure
+}
+
+// getToken returns the cached token, refreshing it when it is about to expire.
+func (tc *tokenCredentials) getToken(ctx context.Context) (string, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.token != "" && (tc.expiry.IsZero() || time.Now().Add(tokenRefreshWindow).Before(tc.expiry)) {
+		return tc.token, nil
+	}
+
+	token, expiry, err := tc.source(ctx)
+	if err != nil {
+		// keep using the previous token while it has not expired yet
+		if tc.token != "" && time.Now().Before(tc.expiry) {
+			return tc.token, nil
+		}
+		return "", err
+	}
+
+	tc.token, tc.expiry = token, expiry
+	return tc.token, nil
+}
@@ -0,0 +1,107 @@
+package grpclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTokenCredentialsRefresh(t *testing.T) {
+	defer func(d time.Duration) { tokenRefreshWindow = d }(tokenRefreshWindow)
+	tokenRefreshWindow = time.Minute
+
+	now := time.Now()
+	sourceErr := errors.New("token service down")
+
+	tests := []struct {
+		// the result of the token source
+		token  string
+		expiry time.Time
+		err    error
+
+		expected string
+		calls    int
+	}{
+		{"t0", now.Add(-time.Second), nil, "t0", 1},
+		// the expired token is not used when the source fails
+		{"", time.Time{}, sourceErr, "", 2},
+		{"t1", now.Add(time.Second), nil, "t1", 3},
+		// the token within the refresh window is used while the source fails
+		{"", time.Time{}, sourceErr, "t1", 4},
+		{"t2", now.Add(time.Hour), nil, "t2", 5},
+		{"t3", now.Add(time.Hour), nil, "t2", 5},
+	}
+
+	var i, calls int
+	tc := newTokenCredentials(func(context.Context) (string, time.Time, error) {
+		calls++
+		return tests[i].token, tests[i].expiry, tests[i].err
+	}, false)
+
+	for i = range tests {
+		tt := tests[i]
+		md, err := tc.GetRequestMetadata(context.Background())
+		if (err != nil) != (tt.expected == "") {
+			t.Fatalf("#%d: expected token %q, got %v", i, tt.expected, err)
+		}
+		if err == nil && md["authorization"] != "Bearer "+tt.expected {
+			t.Errorf("#%d: expected token %q, got %v", i, tt.expected, md)
+		}
+		if calls != tt.calls {
+			t.Errorf("#%d: expected %d calls of the token source, got %d", i, tt.calls, calls)
+		}
+	}
+
+	// a static token never expires
+	tc = newTokenCredentials(StaticToken("static"), false)
+	for j := 0; j < 2; j++ {
+		if md, err := tc.GetRequestMetadata(context.Background()); err != nil || md["authorization"] != "Bearer static" {
+			t.Errorf("#%d: expected the static token, got %v (%v)", j, md, err)
+		}
+	}
+}
+
+func TestTokenInsecure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server records the authorization metadata of every request
+	tokens := make(chan []string, 4)
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		tokens <- md.Get("authorization")
+		return handler(ctx, req)
+	}))
+	pb.RegisterWatchRPCServer(server, &testServer{addr: l.Addr().String()})
+	go server.Serve(l)
+	defer server.Stop()
+
+	cfg := &GrpcClientConfig{Endpoints: []string{l.Addr().String()}, TokenSource: StaticToken("secret")}
+
+	// the token is never sent in plaintext by accident
+	if c, err := NewGRPCClient(cfg); err == nil {
+		c.Close()
+		t.Fatal("expected dialing an insecure endpoint with a token to fail")
+	}
+
+	cfg.AllowInsecureToken = true
+	c := newTestClient(t, cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := pb.NewWatchRPCClient(c.Conn).GetAppServers(ctx, &pb.App{}); err != nil {
+		t.Fatal(err)
+	}
+	if md := <-tokens; len(md) != 1 || md[0] != "Bearer secret" {
+		t.Errorf("expected the token, got %v", md)
+	}
+}
@@ -1,6 +1,7 @@
 package balancer
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"sort"
@@ -13,10 +14,13 @@ import (
 	"github.com/xkeyideal/grpcwatch/grpclient/balancer/picker"
 
 	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
 	grpcconnectivity "google.golang.org/grpc/connectivity"
+	_ "google.golang.org/grpc/health" // register client side health checking
 	"google.golang.org/grpc/resolver"
 	_ "google.golang.org/grpc/resolver/dns"         // register DNS resolver
 	_ "google.golang.org/grpc/resolver/passthrough" // register passthrough resolver
+	"google.golang.org/grpc/status"
 )
 
 // Config defines balancer configurations.
@@ -24,10 +28,30 @@ type Config struct {
 	// Policy configures balancer policy.
 	Policy picker.Policy
 
-	// Picker implements gRPC picker.
+	// Factory builds the picker of the custom policy.
 	// Leave empty if "Policy" field is not custom.
-	// TODO: currently custom policy is not supported.
-	// Picker picker.Picker
+	Factory picker.Factory
+
+	// HashKey configures the request key of the "picker.RingHash" policy.
+	HashKey picker.HashKey
+
+	// OutlierDetection temporarily ejects endpoints whose requests keep failing.
+	// If nil, outlier detection is disabled.
+	OutlierDetection *OutlierDetection
+
+	// Zone is the availability zone of the client, used by the "picker.ZoneAware" policy.
+	Zone string
+
+	// MinZoneReady is the number of ready endpoints in Zone below which the
+	// "picker.ZoneAware" policy spills requests over to the other zones.
+	// If 0, it defaults to 1.
+	MinZoneReady int
+
+	// HealthCheck requests gRPC to watch "grpc.health.v1.Health" on every sub-connection,
+	// a sub-connection only becomes ready, and is handed to the picker, while its service
+	// is SERVING. It takes effect only if the service config of the client connection has
+	// "healthCheckConfig" (e.g. set by "grpc.WithDefaultServiceConfig").
+	HealthCheck bool
 
 	// Name defines an additional name for balancer.
 	// Useful for balancer testing to avoid register conflicts.
@@ -36,7 +60,8 @@ type Config struct {
 }
 
 // RegisterBuilder creates and registers a builder. Since this function calls balancer.Register, it
-// must be invoked at initialization time.
+// must be invoked at initialization time. Per-connection settings are passed by the "LBConfig"
+// of the service config instead of registering a builder for every connection.
 func RegisterBuilder(cfg Config) {
 	bb := &builder{cfg}
 	balancer.Register(bb)
@@ -48,22 +73,35 @@ type builder struct {
 
 // Build is called initially when creating "ccBalancerWrapper".
 // "grpc.Dial" is called to this client connection.
-// Then, resolved addresses will be handled via "HandleResolvedAddrs".
+// Then, resolved addresses will be handled via "UpdateClientConnState".
+// The connectivity of the endpoints is tracked by "Options.Status" if the client connection has one.
 func (b *builder) Build(cc balancer.ClientConn, opt balancer.BuildOptions) balancer.Balancer {
 	bb := &baseBalancer{
-		id:     strconv.FormatInt(time.Now().UnixNano(), 36),
-		policy: b.cfg.Policy,
-		name:   b.cfg.Name,
+		id:      strconv.FormatInt(time.Now().UnixNano(), 36),
+		policy:  b.cfg.Policy,
+		name:    b.cfg.Name,
+		hashKey: b.cfg.HashKey,
+		factory: b.cfg.Factory,
+
+		healthCheck: b.cfg.HealthCheck,
 
-		addrToSc: make(map[resolver.Address]balancer.SubConn),
+		zone:         b.cfg.Zone,
+		minZoneReady: b.cfg.MinZoneReady,
+
+		status: NewStatusTracker(),
+
+		addrToSc: make(map[string]balancer.SubConn),
 		scToAddr: make(map[balancer.SubConn]resolver.Address),
 		scToSt:   make(map[balancer.SubConn]grpcconnectivity.State),
 
 		currentConn:          nil,
 		connectivityRecorder: connectivity.New(),
+		zoneRecorder:         connectivity.New(),
 
 		// initialize picker always returns "ErrNoSubConnAvailable"
 		picker: picker.NewErr(balancer.ErrNoSubConnAvailable),
+
+		donec: make(chan struct{}),
 	}
 
 	// TODO: support multiple connections
@@ -71,6 +109,11 @@ func (b *builder) Build(cc balancer.ClientConn, opt balancer.BuildOptions) balan
 	bb.currentConn = cc
 	bb.mu.Unlock()
 
+	if b.cfg.OutlierDetection != nil {
+		bb.outlier = newOutlierDetector(b.cfg.OutlierDetection)
+		go bb.outlierLoop()
+	}
+
 	return bb
 }
 
@@ -80,10 +123,11 @@ func (b *builder) Name() string { return b.cfg.Name }
 // Balancer defines client balancer interface.
 type Balancer interface {
 	// Balancer is called on specified client connection. Client initiates gRPC
-	// connection with "grpc.Dial(addr, grpc.WithBalancerName)", and then those resolved
-	// addresses are passed to "grpc/balancer.Balancer.HandleResolvedAddrs".
+	// connection with "grpc.Dial(addr, grpc.WithDefaultServiceConfig)" selecting the balancer
+	// in "loadBalancingConfig", and then those resolved addresses are passed to
+	// "grpc/balancer.Balancer.UpdateClientConnState".
 	// For each resolved address, balancer calls "balancer.ClientConn.NewSubConn".
-	// "grpc/balancer.Balancer.HandleSubConnStateChange" is called when connectivity state
+	// "grpc/balancer.Balancer.UpdateSubConnState" is called when connectivity state
 	// changes, thus requires failover logic in this method.
 	balancer.Balancer
 
@@ -92,65 +136,198 @@ type Balancer interface {
 }
 
 type baseBalancer struct {
-	id     string
-	policy picker.Policy
-	name   string
+	id      string
+	policy  picker.Policy
+	name    string
+	hashKey picker.HashKey
+	factory picker.Factory
+
+	healthCheck bool
+
+	zone         string
+	minZoneReady int
 
 	mu sync.RWMutex
 
-	addrToSc map[resolver.Address]balancer.SubConn
+	addrToSc map[string]balancer.SubConn
 	scToAddr map[balancer.SubConn]resolver.Address
 	scToSt   map[balancer.SubConn]grpcconnectivity.State
 
 	currentConn          balancer.ClientConn
 	connectivityRecorder connectivity.Recorder
 
+	// zoneRecorder only records the sub-connections in zone
+	zoneRecorder connectivity.Recorder
+
+	// status records the connectivity of the endpoints of this balancer only
+	status *StatusTracker
+
 	picker picker.Picker
+
+	// connErr is the last connection error of any sub-connection
+	connErr error
+
+	// outlier is nil if outlier detection is disabled
+	outlier *outlierDetector
+
+	// lbConfig is the last applied config of the client connection
+	lbConfig *LBConfig
+
+	// donec closes when the balancer is closed
+	donec     chan struct{}
+	closeOnce sync.Once
 }
 
-// HandleResolvedAddrs implements "grpc/balancer.Balancer" interface.
+// UpdateClientConnState implements "grpc/balancer.Balancer" interface.
 // gRPC sends initial or updated resolved addresses from "Build".
-func (bb *baseBalancer) HandleResolvedAddrs(addrs []resolver.Address, err error) {
-	if err != nil {
-		return
-	}
+func (bb *baseBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	addrs := s.ResolverState.Addresses
 
 	//fmt.Printf("balancer resolved, picker: %s, addresses: %+v\n", bb.picker.String(), addrsToStrings(addrs))
 
 	bb.mu.Lock()
 	defer bb.mu.Unlock()
 
-	resolved := make(map[resolver.Address]struct{})
+	changed := false
+	if cfg, ok := s.BalancerConfig.(*LBConfig); ok && bb.applyConfig(cfg) {
+		changed = len(bb.addrToSc) > 0
+	}
+
+	// sub-connections are keyed by "Addr" only, attributes such as the weight
+	// may change without reconnecting
+	resolved := make(map[string]struct{})
 	for _, addr := range addrs {
-		resolved[addr] = struct{}{}
-		if _, ok := bb.addrToSc[addr]; !ok {
-			sc, err := bb.currentConn.NewSubConn([]resolver.Address{addr}, balancer.NewSubConnOptions{})
-			if err != nil {
-				continue
+		resolved[addr.Addr] = struct{}{}
+		if sc, ok := bb.addrToSc[addr.Addr]; ok {
+			if old := bb.scToAddr[sc]; !old.Equal(addr) {
+				// move the sub-connection in or out of the local zone
+				if st := bb.scToSt[sc]; bb.inZone(old) != bb.inZone(addr) {
+					if bb.inZone(old) {
+						bb.zoneRecorder.RecordTransition(st, grpcconnectivity.Shutdown)
+					} else {
+						bb.zoneRecorder.RecordTransition(grpcconnectivity.Shutdown, st)
+					}
+				}
+				bb.scToAddr[sc] = addr
+				changed = true
 			}
-			bb.addrToSc[addr] = sc
-			bb.scToAddr[sc] = addr
-			bb.scToSt[sc] = grpcconnectivity.Idle
-			sc.Connect() // 此方法是调用每个address，去建立连接, 最终会调用HandleSubConnStateChange
+			continue
+		}
+
+		sc, err := bb.currentConn.NewSubConn([]resolver.Address{addr}, balancer.NewSubConnOptions{HealthCheckEnabled: bb.healthCheck})
+		if err != nil {
+			continue
 		}
+		bb.addrToSc[addr.Addr] = sc
+		bb.scToAddr[sc] = addr
+		bb.scToSt[sc] = grpcconnectivity.Idle
+		bb.status.record(addr.Addr, grpcconnectivity.Idle, nil)
+		sc.Connect() // 此方法是调用每个address，去建立连接, 最终会调用UpdateSubConnState
 	}
 
 	for addr, sc := range bb.addrToSc {
 		if _, ok := resolved[addr]; !ok {
 			// was removed by resolver or failed to create subconn
-			bb.currentConn.RemoveSubConn(sc)
+			sc.Shutdown()
 			delete(bb.addrToSc, addr)
 
 			// Keep the state of this sc in bb.scToSt until sc's state becomes Shutdown.
-			// The entry will be deleted in HandleSubConnStateChange.
+			// The entry will be deleted in UpdateSubConnState.
 			// (DO NOT) delete(bb.scToAddr, sc)
 			// (DO NOT) delete(bb.scToSt, sc)
 		}
 	}
+
+	if len(addrs) == 0 {
+		// ask the resolver to resolve again
+		bb.resolverError(errors.New("produced zero addresses"))
+		return balancer.ErrBadResolverState
+	}
+
+	if changed && bb.connectivityRecorder.GetCurrentState() != grpcconnectivity.TransientFailure {
+		bb.updatePicker()
+		bb.updateState()
+	}
+	return nil
 }
 
-// HandleSubConnStateChange implements "grpc/balancer.Balancer" interface.
-func (bb *baseBalancer) HandleSubConnStateChange(sc balancer.SubConn, s grpcconnectivity.State) {
+// applyConfig applies the config of the client connection over the config of the builder,
+// it must be called with mu held. It returns whether the config changed.
+func (bb *baseBalancer) applyConfig(cfg *LBConfig) bool {
+	if bb.lbConfig != nil && *bb.lbConfig == *cfg {
+		return false
+	}
+	bb.lbConfig = cfg
+
+	if opts, ok := lookupOptions(cfg.OptionsID); ok {
+		if opts.Factory != nil {
+			bb.factory = opts.Factory
+		}
+		if opts.OutlierDetection != nil && bb.outlier == nil {
+			bb.outlier = newOutlierDetector(opts.OutlierDetection)
+			for sc, st := range bb.scToSt {
+				if st == grpcconnectivity.Ready {
+					bb.outlier.add(sc)
+				}
+			}
+			go bb.outlierLoop()
+		}
+		if opts.HashContextKey != nil {
+			bb.hashKey.ContextKey = opts.HashContextKey
+		}
+		if opts.Status != nil && opts.Status != bb.status {
+			bb.status = opts.Status
+			for sc, addr := range bb.scToAddr {
+				if cur, ok := bb.addrToSc[addr.Addr]; ok && cur == sc {
+					bb.status.record(addr.Addr, bb.scToSt[sc], nil)
+				}
+			}
+		}
+	}
+
+	if cfg.HashMetadataKey != "" {
+		bb.hashKey.MetadataKey = cfg.HashMetadataKey
+	}
+
+	if cfg.MinZoneReady > 0 {
+		bb.minZoneReady = cfg.MinZoneReady
+	}
+	if cfg.Zone != "" && cfg.Zone != bb.zone {
+		bb.zone = cfg.Zone
+
+		// recount the sub-connections in the new zone
+		bb.zoneRecorder = connectivity.New()
+		for sc, addr := range bb.scToAddr {
+			if bb.inZone(addr) {
+				bb.zoneRecorder.RecordTransition(grpcconnectivity.Shutdown, bb.scToSt[sc])
+			}
+		}
+	}
+	return true
+}
+
+// ResolverError implements "grpc/balancer.Balancer" interface.
+// The previously resolved addresses are kept in use if there are any.
+func (bb *baseBalancer) ResolverError(err error) {
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+
+	bb.resolverError(err)
+}
+
+// resolverError must be called with mu held.
+func (bb *baseBalancer) resolverError(err error) {
+	if len(bb.addrToSc) != 0 {
+		return
+	}
+	bb.picker = picker.NewErr(fmt.Errorf("resolver error: %v", err))
+	bb.currentConn.UpdateState(balancer.State{ConnectivityState: grpcconnectivity.TransientFailure, Picker: bb.picker})
+}
+
+// UpdateSubConnState implements "grpc/balancer.Balancer" interface.
+func (bb *baseBalancer) UpdateSubConnState(sc balancer.SubConn, state balancer.SubConnState) {
+	s := state.ConnectivityState
+
 	bb.mu.Lock()
 	defer bb.mu.Unlock()
 
@@ -158,22 +335,40 @@ func (bb *baseBalancer) HandleSubConnStateChange(sc balancer.SubConn, s grpcconn
 	if !ok {
 		return
 	}
+	addr := bb.scToAddr[sc]
+	if bb.inZone(addr) {
+		bb.zoneRecorder.RecordTransition(old, s)
+	}
+
+	// the address may have been resolved again with a new sc before the old one shut down
+	if cur, ok := bb.addrToSc[addr.Addr]; !ok || cur == sc {
+		bb.status.record(addr.Addr, s, state.ConnectionError)
+	}
 
 	bb.scToSt[sc] = s
 	switch s { // s的初始状态为connecting
 	case grpcconnectivity.Idle:
 		sc.Connect()
+	case grpcconnectivity.Ready:
+		if bb.outlier != nil {
+			bb.outlier.add(sc)
+		}
+	case grpcconnectivity.TransientFailure:
+		bb.connErr = state.ConnectionError
 	case grpcconnectivity.Shutdown:
-		// When an address was removed by resolver, b called RemoveSubConn but
+		// When an address was removed by resolver, b called Shutdown but
 		// kept the sc's state in scToSt. Remove state for this sc here.
 		delete(bb.scToAddr, sc)
 		delete(bb.scToSt, sc)
+		if bb.outlier != nil {
+			bb.outlier.remove(sc)
+		}
 	}
 
 	oldAggrState := bb.connectivityRecorder.GetCurrentState()
 	bb.connectivityRecorder.RecordTransition(old, s)
 
-	//fmt.Printf("HandleSubConnStateChange, state: %+v, oldstate: %+v\n", s, old)
+	//fmt.Printf("UpdateSubConnState, state: %+v, oldstate: %+v\n", s, old)
 
 	// Update balancer picker when one of the following happens:
 	//  - this sc became ready from not-ready
@@ -186,35 +381,88 @@ func (bb *baseBalancer) HandleSubConnStateChange(sc balancer.SubConn, s grpcconn
 	}
 
 	// 通知grpc balancer picker
-	bb.currentConn.UpdateBalancerState(bb.connectivityRecorder.GetCurrentState(), bb.picker)
+	bb.updateState()
+}
+
+// updateState hands the current picker to gRPC, it must be called with mu held.
+func (bb *baseBalancer) updateState() {
+	bb.currentConn.UpdateState(balancer.State{
+		ConnectivityState: bb.connectivityRecorder.GetCurrentState(),
+		Picker:            bb.picker,
+	})
 }
 
 func (bb *baseBalancer) updatePicker() {
+	// not a status error, so that gRPC keeps WaitForReady RPCs waiting until a sub-connection
+	// recovers, and fails the others with Unavailable
 	if bb.connectivityRecorder.GetCurrentState() == grpcconnectivity.TransientFailure {
-		bb.picker = picker.NewErr(balancer.ErrTransientFailure)
+		bb.picker = picker.NewErr(fmt.Errorf("all SubConns are in TransientFailure, last connection error: %v", bb.connErr))
+		return
+	}
+	if bb.policy == picker.Custom && bb.factory == nil {
+		bb.picker = picker.NewErr(status.Error(codes.FailedPrecondition, "custom picker policy requires a picker factory"))
 		return
 	}
 
 	// only pass ready subconns to picker
 	scToAddr := make(map[balancer.SubConn]resolver.Address)
-	for addr, sc := range bb.addrToSc {
+	for _, sc := range bb.addrToSc {
 		if st, ok := bb.scToSt[sc]; ok && st == grpcconnectivity.Ready {
-			scToAddr[sc] = addr
+			scToAddr[sc] = bb.scToAddr[sc]
 		}
 	}
 
+	// 剔除被outlier detection摘除的subconn, 但至少保留一个
+	if bb.outlier != nil {
+		healthy := make(map[balancer.SubConn]resolver.Address, len(scToAddr))
+		for sc, addr := range scToAddr {
+			if !bb.outlier.ejected(sc) {
+				healthy[sc] = addr
+			}
+		}
+		if len(healthy) > 0 {
+			scToAddr = healthy
+		}
+	}
+
+	prev := bb.picker
+	if op, ok := prev.(*outlierPicker); ok {
+		prev = op.Picker
+	}
+
 	// 新建balancer的picker
 	bb.picker = picker.New(picker.Config{
 		Policy:                   bb.policy,
 		SubConnToResolverAddress: scToAddr,
+		Prev:                     prev,
+		HashKey:                  bb.hashKey,
+		Factory:                  bb.factory,
+		Zone:                     bb.zone,
+		ZoneReady:                int(bb.zoneRecorder.NumReady()),
+		MinZoneReady:             bb.minZoneReady,
 	})
+
+	if bb.outlier != nil {
+		bb.picker = &outlierPicker{Picker: bb.picker, od: bb.outlier}
+	}
+}
+
+// inZone returns whether addr is in the zone of the client.
+func (bb *baseBalancer) inZone(addr resolver.Address) bool {
+	if bb.zone == "" {
+		return false
+	}
+	md, ok := picker.GetAddrMetadata(addr)
+	return ok && md.Zone == bb.zone
 }
 
 // Close implements "grpc/balancer.Balancer" interface.
-// Close is a nop because base balancer doesn't have internal state to clean up,
-// and it doesn't need to call RemoveSubConn for the SubConns.
+// Close stops the outlier detection loop. It doesn't need to call RemoveSubConn
+// for the SubConns.
 func (bb *baseBalancer) Close() {
-	// TODO
+	bb.closeOnce.Do(func() {
+		close(bb.donec)
+	})
 }
 
 func scToString(sc balancer.SubConn) string {
@@ -244,10 +492,10 @@ func epsToAddrs(eps ...string) (addrs []resolver.Address) {
 	for _, ep := range eps {
 		u, err := url.Parse(ep)
 		if err != nil {
-			addrs = append(addrs, resolver.Address{Addr: ep, Type: resolver.Backend})
+			addrs = append(addrs, resolver.Address{Addr: ep})
 			continue
 		}
-		addrs = append(addrs, resolver.Address{Addr: u.Host, Type: resolver.Backend})
+		addrs = append(addrs, resolver.Address{Addr: u.Host})
 	}
 	return addrs
 }
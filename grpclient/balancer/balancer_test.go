@@ -0,0 +1,118 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xkeyideal/grpcwatch/grpclient/balancer/picker"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	grpcconnectivity "google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+// testClientConn records the sub-connections and the last state of a balancer.
+type testClientConn struct {
+	balancer.ClientConn
+
+	subConns []*testSubConn
+	state    balancer.State
+}
+
+func (cc *testClientConn) NewSubConn([]resolver.Address, balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	sc := &testSubConn{id: len(cc.subConns)}
+	cc.subConns = append(cc.subConns, sc)
+	return sc, nil
+}
+
+func (cc *testClientConn) UpdateState(state balancer.State) { cc.state = state }
+
+func (*testSubConn) Connect() {}
+
+func (*testSubConn) Shutdown() {}
+
+// newTestBalancer builds a balancer of cfg and resolves it to addrs.
+func newTestBalancer(t *testing.T, cfg Config, addrs ...string) (*baseBalancer, *testClientConn) {
+	t.Helper()
+
+	cc := &testClientConn{}
+	bb := (&builder{cfg: cfg}).Build(cc, balancer.BuildOptions{}).(*baseBalancer)
+	t.Cleanup(bb.Close)
+
+	if err := bb.UpdateClientConnState(balancer.ClientConnState{ResolverState: resolver.State{Addresses: epsToAddrs(addrs...)}}); err != nil {
+		t.Fatal(err)
+	}
+	return bb, cc
+}
+
+func TestBalancerTransientFailure(t *testing.T) {
+	bb, cc := newTestBalancer(t, Config{Policy: picker.RoundrobinBalanced, Name: "test"}, "127.0.0.1:1", "127.0.0.1:2")
+
+	connErr := errors.New("connection refused")
+	for _, sc := range cc.subConns {
+		bb.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: grpcconnectivity.Connecting})
+		bb.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: grpcconnectivity.TransientFailure, ConnectionError: connErr})
+	}
+	if cc.state.ConnectivityState != grpcconnectivity.TransientFailure {
+		t.Fatalf("expected %v, got %v", grpcconnectivity.TransientFailure, cc.state.ConnectivityState)
+	}
+
+	// a status error would fail WaitForReady RPCs instead of blocking them
+	_, err := cc.state.Picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+	if err == nil {
+		t.Fatal("expected an error while all sub-connections are in transient failure")
+	}
+	if _, ok := status.FromError(err); ok {
+		t.Errorf("expected a non-status error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), connErr.Error()) {
+		t.Errorf("expected the last connection error in %q", err)
+	}
+
+	bb.UpdateSubConnState(cc.subConns[0], balancer.SubConnState{ConnectivityState: grpcconnectivity.Connecting})
+	bb.UpdateSubConnState(cc.subConns[0], balancer.SubConnState{ConnectivityState: grpcconnectivity.Ready})
+	res, err := cc.state.Picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+	if err != nil {
+		t.Fatalf("expected a pick after recovering, got %v", err)
+	}
+	if res.SubConn != cc.subConns[0] {
+		t.Errorf("expected the ready sub-connection, got %v", res.SubConn)
+	}
+}
+
+func TestBalancerOutlierFirstInterval(t *testing.T) {
+	od := &OutlierDetection{Interval: time.Hour}
+	bb, cc := newTestBalancer(t, Config{Policy: picker.RoundrobinBalanced, Name: "test", OutlierDetection: od}, "127.0.0.1:1", "127.0.0.1:2")
+
+	ready := make([]balancer.SubConn, 0, len(cc.subConns))
+	for _, sc := range cc.subConns {
+		bb.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: grpcconnectivity.Connecting})
+		bb.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: grpcconnectivity.Ready})
+		ready = append(ready, sc)
+	}
+
+	// the requests before the first evaluation are recorded as well
+	for i := 0; i < 40; i++ {
+		res, err := cc.state.Picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = nil
+		if res.SubConn == cc.subConns[0] {
+			err = status.Error(codes.Unavailable, "failed")
+		}
+		res.Done(balancer.DoneInfo{Err: err})
+	}
+
+	if !bb.outlier.evaluate(ready, time.Now()) {
+		t.Fatal("expected the first evaluation to eject the failing sub-connection")
+	}
+	if !bb.outlier.ejected(cc.subConns[0]) || bb.outlier.ejected(cc.subConns[1]) {
+		t.Errorf("expected only sub-connection 0 to be ejected")
+	}
+}
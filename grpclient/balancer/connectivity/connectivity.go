@@ -10,6 +10,7 @@ import (
 type Recorder interface {
 	GetCurrentState() connectivity.State
 	RecordTransition(oldState, newState connectivity.State)
+	NumReady() uint64
 }
 
 // New returns a new Recorder.
@@ -36,6 +37,13 @@ func (rc *recorder) GetCurrentState() (state connectivity.State) {
 	return rc.cur
 }
 
+// NumReady returns the number of SubConns in Ready state.
+func (rc *recorder) NumReady() uint64 {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return rc.numReady
+}
+
 // RecordTransition records state change happening in subConn and based on that
 // it evaluates what aggregated state should be.
 //
@@ -0,0 +1,87 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/xkeyideal/grpcwatch/grpclient/balancer/picker"
+
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// LBConfig is the per-connection configuration of the balancers registered by "RegisterBuilder".
+// It is parsed from the balancer's entry in the "loadBalancingConfig" of the service config,
+// e.g. {"loadBalancingConfig": [{"grpc-picker-zone-aware": {"zone": "zone-a"}}]}.
+// Non-zero fields override the "Config" the builder was registered with.
+type LBConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	// OptionsID selects the options registered by "RegisterOptions".
+	OptionsID string `json:"optionsId,omitempty"`
+
+	// Zone overrides "Config.Zone".
+	Zone string `json:"zone,omitempty"`
+
+	// MinZoneReady overrides "Config.MinZoneReady".
+	MinZoneReady int `json:"minZoneReady,omitempty"`
+
+	// HashMetadataKey overrides "Config.HashKey.MetadataKey".
+	HashMetadataKey string `json:"hashMetadataKey,omitempty"`
+}
+
+// Options holds the per-connection settings that cannot be carried by the service config.
+// They are registered with "RegisterOptions" and selected by "LBConfig.OptionsID".
+type Options struct {
+	// Factory overrides "Config.Factory".
+	Factory picker.Factory
+
+	// OutlierDetection overrides "Config.OutlierDetection".
+	OutlierDetection *OutlierDetection
+
+	// HashContextKey overrides "Config.HashKey.ContextKey".
+	HashContextKey interface{}
+
+	// Status records the connectivity of the endpoints of the client connection.
+	// If nil, the balancer records into a tracker of its own.
+	Status *StatusTracker
+}
+
+// options holds the registered options, keyed by id
+var options sync.Map
+
+// RegisterOptions registers opts and returns the id to set as "LBConfig.OptionsID".
+// The options should be unregistered once the client connection is closed.
+func RegisterOptions(opts Options) string {
+	id := genName()
+	options.Store(id, &opts)
+	return id
+}
+
+// UnregisterOptions forgets the options registered with id.
+func UnregisterOptions(id string) {
+	options.Delete(id)
+}
+
+func lookupOptions(id string) (*Options, bool) {
+	if id == "" {
+		return nil, false
+	}
+	v, ok := options.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return v.(*Options), true
+}
+
+// ParseConfig implements "grpc/balancer.ConfigParser" interface.
+func (b *builder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &LBConfig{}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, fmt.Errorf("balancer %s: invalid config %s: %v", b.cfg.Name, string(js), err)
+	}
+	if cfg.MinZoneReady < 0 {
+		return nil, fmt.Errorf("balancer %s: minZoneReady must not be negative", b.cfg.Name)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,262 @@
+package balancer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xkeyideal/grpcwatch/grpclient/balancer/picker"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	grpcconnectivity "google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// OutlierDetection configures passive ejection of endpoints whose requests keep failing.
+// Zero fields take the defaults documented below.
+type OutlierDetection struct {
+	// Interval is how often failure rates are evaluated and ejections expire.
+	// If 0, it defaults to 10 seconds.
+	Interval time.Duration
+
+	// FailureRateThreshold is the fraction of failed requests within an interval
+	// above which an endpoint is ejected. If 0, it defaults to 0.5.
+	FailureRateThreshold float64
+
+	// MinimumRequests is the number of requests an endpoint must have served within
+	// an interval for its failure rate to be evaluated. If 0, it defaults to 10.
+	MinimumRequests uint64
+
+	// BaseEjectionTime is how long an endpoint is ejected the first time, it doubles
+	// every time the endpoint is ejected again right after being restored.
+	// If 0, it defaults to 30 seconds.
+	BaseEjectionTime time.Duration
+
+	// MaxEjectionTime caps the ejection time. If 0, it defaults to 5 minutes.
+	MaxEjectionTime time.Duration
+
+	// MaxEjectionPercent is the maximum percentage of ready endpoints that may be
+	// ejected at the same time, at least one endpoint can always be ejected as long
+	// as another one is left. If 0, it defaults to 50.
+	MaxEjectionPercent int
+
+	// FailureCodes are the status codes counted as failures.
+	// If empty, it defaults to Unavailable, DeadlineExceeded, Internal and Unknown.
+	FailureCodes []codes.Code
+}
+
+func (od *OutlierDetection) withDefaults() OutlierDetection {
+	cfg := *od
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.FailureRateThreshold <= 0 {
+		cfg.FailureRateThreshold = 0.5
+	}
+	if cfg.MinimumRequests == 0 {
+		cfg.MinimumRequests = 10
+	}
+	if cfg.BaseEjectionTime <= 0 {
+		cfg.BaseEjectionTime = 30 * time.Second
+	}
+	if cfg.MaxEjectionTime <= 0 {
+		cfg.MaxEjectionTime = 5 * time.Minute
+	}
+	if cfg.MaxEjectionPercent <= 0 {
+		cfg.MaxEjectionPercent = 50
+	}
+	if len(cfg.FailureCodes) == 0 {
+		cfg.FailureCodes = []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown}
+	}
+	return cfg
+}
+
+type outlierStats struct {
+	// requests and failures within the current interval, updated atomically by doneFunc
+	requests uint64
+	failures uint64
+
+	// ejectedUntil is zero if the endpoint is not ejected
+	ejectedUntil time.Time
+
+	// ejections is the exponent of the next ejection time, it decays by one every
+	// interval the endpoint serves enough requests below the failure rate threshold
+	ejections uint
+}
+
+// outlierDetector records the outcome of every request picked by the balancer.
+type outlierDetector struct {
+	cfg OutlierDetection
+
+	failureCodes map[codes.Code]struct{}
+
+	mu    sync.RWMutex
+	stats map[balancer.SubConn]*outlierStats
+}
+
+func newOutlierDetector(cfg *OutlierDetection) *outlierDetector {
+	od := &outlierDetector{
+		cfg:          cfg.withDefaults(),
+		failureCodes: make(map[codes.Code]struct{}),
+		stats:        make(map[balancer.SubConn]*outlierStats),
+	}
+	for _, code := range od.cfg.FailureCodes {
+		od.failureCodes[code] = struct{}{}
+	}
+	return od
+}
+
+// add starts recording the requests to sc, it must be called before sc is given to a picker.
+func (od *outlierDetector) add(sc balancer.SubConn) {
+	od.mu.Lock()
+	if _, ok := od.stats[sc]; !ok {
+		od.stats[sc] = &outlierStats{}
+	}
+	od.mu.Unlock()
+}
+
+func (od *outlierDetector) record(sc balancer.SubConn, err error) {
+	od.mu.RLock()
+	st, ok := od.stats[sc]
+	od.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	atomic.AddUint64(&st.requests, 1)
+	if err == nil {
+		return
+	}
+	if _, ok := od.failureCodes[status.Code(err)]; ok {
+		atomic.AddUint64(&st.failures, 1)
+	}
+}
+
+func (od *outlierDetector) ejected(sc balancer.SubConn) bool {
+	od.mu.RLock()
+	defer od.mu.RUnlock()
+
+	st, ok := od.stats[sc]
+	return ok && !st.ejectedUntil.IsZero()
+}
+
+func (od *outlierDetector) remove(sc balancer.SubConn) {
+	od.mu.Lock()
+	delete(od.stats, sc)
+	od.mu.Unlock()
+}
+
+// evaluate restores the endpoints whose ejection expired and ejects the ready ones
+// whose failure rate exceeds the threshold, it returns whether anything changed.
+func (od *outlierDetector) evaluate(ready []balancer.SubConn, now time.Time) bool {
+	od.mu.Lock()
+	defer od.mu.Unlock()
+
+	changed := false
+	ejected := 0
+	for _, sc := range ready {
+		st, ok := od.stats[sc]
+		if !ok {
+			st = &outlierStats{}
+			od.stats[sc] = st
+		}
+
+		if !st.ejectedUntil.IsZero() {
+			if now.Before(st.ejectedUntil) {
+				ejected++
+				continue
+			}
+			st.ejectedUntil = time.Time{}
+			changed = true
+		}
+	}
+
+	maxEjected := len(ready) * od.cfg.MaxEjectionPercent / 100
+	if maxEjected == 0 && len(ready) > 1 {
+		maxEjected = 1
+	}
+	if maxEjected >= len(ready) {
+		maxEjected = len(ready) - 1
+	}
+
+	for _, sc := range ready {
+		st := od.stats[sc]
+		requests := atomic.SwapUint64(&st.requests, 0)
+		failures := atomic.SwapUint64(&st.failures, 0)
+
+		if !st.ejectedUntil.IsZero() || requests < od.cfg.MinimumRequests {
+			continue
+		}
+		if float64(failures)/float64(requests) <= od.cfg.FailureRateThreshold {
+			// the interval right after being restored has no requests, so an endpoint
+			// failing again is ejected for longer
+			if st.ejections > 0 {
+				st.ejections--
+			}
+			continue
+		}
+		if ejected >= maxEjected {
+			continue
+		}
+
+		ejectionTime := od.cfg.BaseEjectionTime << st.ejections
+		if ejectionTime > od.cfg.MaxEjectionTime || ejectionTime <= 0 {
+			ejectionTime = od.cfg.MaxEjectionTime
+		} else {
+			st.ejections++
+		}
+		st.ejectedUntil = now.Add(ejectionTime)
+		ejected++
+		changed = true
+	}
+	return changed
+}
+
+// outlierPicker reports the outcome of every picked request to the outlier detector.
+type outlierPicker struct {
+	picker.Picker
+	od *outlierDetector
+}
+
+func (op *outlierPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	res, err := op.Picker.Pick(info)
+	if err != nil {
+		return res, err
+	}
+
+	sc, done := res.SubConn, res.Done
+	res.Done = func(info balancer.DoneInfo) {
+		op.od.record(sc, info.Err)
+		if done != nil {
+			done(info)
+		}
+	}
+	return res, nil
+}
+
+// outlierLoop evaluates the outlier detector every interval until the balancer is closed.
+func (bb *baseBalancer) outlierLoop() {
+	ticker := time.NewTicker(bb.outlier.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			bb.mu.Lock()
+			ready := make([]balancer.SubConn, 0, len(bb.scToSt))
+			for sc, st := range bb.scToSt {
+				if st == grpcconnectivity.Ready {
+					ready = append(ready, sc)
+				}
+			}
+			if bb.outlier.evaluate(ready, now) && bb.connectivityRecorder.GetCurrentState() != grpcconnectivity.TransientFailure {
+				bb.updatePicker()
+				bb.updateState()
+			}
+			bb.mu.Unlock()
+		case <-bb.donec:
+			return
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package balancer
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testSubConn struct {
+	balancer.SubConn
+	id int
+}
+
+func newTestSubConns(n int) []balancer.SubConn {
+	scs := make([]balancer.SubConn, n)
+	for i := range scs {
+		scs[i] = &testSubConn{id: i}
+	}
+	return scs
+}
+
+// recordRequests records requests to sc, the first failures of them failing with code.
+func recordRequests(od *outlierDetector, sc balancer.SubConn, requests, failures int, code codes.Code) {
+	for i := 0; i < requests; i++ {
+		var err error
+		if i < failures {
+			err = status.Error(code, "failed")
+		}
+		od.record(sc, err)
+	}
+}
+
+func countEjected(od *outlierDetector, scs []balancer.SubConn) int {
+	n := 0
+	for _, sc := range scs {
+		if od.ejected(sc) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestOutlierDetectorEvaluate(t *testing.T) {
+	tests := []struct {
+		cfg OutlierDetection
+
+		// failures of 10 requests to every ready sub-connection
+		failures []int
+		code     codes.Code
+
+		ejected int
+	}{
+		// failure rate above the threshold
+		{OutlierDetection{}, []int{6, 0, 0}, codes.Unavailable, 1},
+		// failure rate equal to the threshold
+		{OutlierDetection{}, []int{5, 0, 0}, codes.Unavailable, 0},
+		// not a failure code
+		{OutlierDetection{}, []int{10, 0, 0}, codes.NotFound, 0},
+		{OutlierDetection{FailureCodes: []codes.Code{codes.NotFound}}, []int{10, 0, 0}, codes.NotFound, 1},
+		// too few requests to be evaluated
+		{OutlierDetection{MinimumRequests: 11}, []int{10, 0, 0}, codes.Unavailable, 0},
+		{OutlierDetection{FailureRateThreshold: 0.1}, []int{2, 1, 0}, codes.Unavailable, 1},
+		// at most MaxEjectionPercent are ejected
+		{OutlierDetection{}, []int{10, 10, 10, 10}, codes.Unavailable, 2},
+		{OutlierDetection{MaxEjectionPercent: 75}, []int{10, 10, 10, 10}, codes.Unavailable, 3},
+		// at least one is always ejectable while another one is left
+		{OutlierDetection{MaxEjectionPercent: 10}, []int{10, 10}, codes.Unavailable, 1},
+		// the last one is never ejected
+		{OutlierDetection{MaxEjectionPercent: 100}, []int{10, 10}, codes.Unavailable, 1},
+		{OutlierDetection{}, []int{10}, codes.Unavailable, 0},
+	}
+
+	for i, tt := range tests {
+		od := newOutlierDetector(&tt.cfg)
+		scs := newTestSubConns(len(tt.failures))
+
+		now := time.Now()
+		if od.evaluate(scs, now) {
+			t.Errorf("#%d: expected no change without requests", i)
+		}
+
+		for j, sc := range scs {
+			recordRequests(od, sc, 10, tt.failures[j], tt.code)
+		}
+
+		changed := od.evaluate(scs, now.Add(od.cfg.Interval))
+		if changed != (tt.ejected > 0) {
+			t.Errorf("#%d: expected changed %v, got %v", i, tt.ejected > 0, changed)
+		}
+		if n := countEjected(od, scs); n != tt.ejected {
+			t.Errorf("#%d: expected %d ejected, got %d", i, tt.ejected, n)
+		}
+	}
+}
+
+func TestOutlierDetectorEjectionTime(t *testing.T) {
+	od := newOutlierDetector(&OutlierDetection{
+		BaseEjectionTime: time.Second,
+		MaxEjectionTime:  3 * time.Second,
+	})
+	scs := newTestSubConns(2)
+	sc := scs[0]
+
+	now := time.Now()
+	od.evaluate(scs, now)
+
+	tests := []struct {
+		// ejection time expected when sc keeps failing
+		ejectionTime time.Duration
+	}{
+		{time.Second},
+		{2 * time.Second},
+		// capped at MaxEjectionTime
+		{3 * time.Second},
+		{3 * time.Second},
+	}
+
+	for i, tt := range tests {
+		recordRequests(od, sc, 10, 10, codes.Unavailable)
+		if !od.evaluate(scs, now) || !od.ejected(sc) {
+			t.Fatalf("#%d: expected sc to be ejected", i)
+		}
+
+		if od.evaluate(scs, now.Add(tt.ejectionTime-time.Millisecond)); !od.ejected(sc) {
+			t.Errorf("#%d: expected sc to be ejected before %v", i, tt.ejectionTime)
+		}
+		now = now.Add(tt.ejectionTime)
+		if !od.evaluate(scs, now) || od.ejected(sc) {
+			t.Errorf("#%d: expected sc to be restored after %v", i, tt.ejectionTime)
+		}
+	}
+
+	// the ejection time decays while sc keeps succeeding
+	for i := 0; i < 3; i++ {
+		recordRequests(od, sc, 10, 0, codes.Unavailable)
+		now = now.Add(od.cfg.Interval)
+		od.evaluate(scs, now)
+	}
+	recordRequests(od, sc, 10, 10, codes.Unavailable)
+	od.evaluate(scs, now)
+	if od.evaluate(scs, now.Add(time.Second)); od.ejected(sc) {
+		t.Errorf("expected the ejection time to decay to %v", time.Second)
+	}
+}
+
+func TestOutlierDetectorRemove(t *testing.T) {
+	od := newOutlierDetector(&OutlierDetection{})
+	scs := newTestSubConns(2)
+
+	now := time.Now()
+	od.evaluate(scs, now)
+	recordRequests(od, scs[0], 10, 10, codes.Unavailable)
+	od.evaluate(scs, now)
+	if !od.ejected(scs[0]) {
+		t.Fatal("expected sc to be ejected")
+	}
+
+	od.remove(scs[0])
+	if od.ejected(scs[0]) {
+		t.Error("expected removed sc not to be ejected")
+	}
+
+	// requests to unknown sub-connections are ignored
+	recordRequests(od, scs[0], 10, 10, codes.Unavailable)
+	if _, ok := od.stats[scs[0]]; ok {
+		t.Error("expected no stats for removed sc")
+	}
+}
@@ -0,0 +1,79 @@
+package picker
+
+import (
+	"math/rand"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+)
+
+// newLeastLoaded returns a new power-of-two-choices least loaded picker.
+func newLeastLoaded(cfg Config) Picker {
+	prev, _ := cfg.Prev.(*leastLoaded)
+
+	scs := make([]balancer.SubConn, 0, len(cfg.SubConnToResolverAddress))
+	inflight := make(map[balancer.SubConn]*int64, len(cfg.SubConnToResolverAddress))
+	for sc := range cfg.SubConnToResolverAddress {
+		scs = append(scs, sc)
+
+		// share the counter with the previous picker so that requests picked
+		// before the rebuild are still accounted for when they finish
+		if prev != nil {
+			if n, ok := prev.inflight[sc]; ok {
+				inflight[sc] = n
+				continue
+			}
+		}
+		inflight[sc] = new(int64)
+	}
+
+	return &leastLoaded{
+		p:        LeastLoaded,
+		scs:      scs,
+		scToAddr: cfg.SubConnToResolverAddress,
+		inflight: inflight,
+	}
+}
+
+type leastLoaded struct {
+	p        Policy
+	scs      []balancer.SubConn
+	scToAddr map[balancer.SubConn]resolver.Address
+
+	// inflight counts the requests picked but not yet done for each SubConn.
+	// The map is read-only after construction; counters are updated atomically.
+	inflight map[balancer.SubConn]*int64
+}
+
+func (ll *leastLoaded) String() string { return ll.p.String() }
+
+// Pick is called for every client request.
+func (ll *leastLoaded) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	n := len(ll.scs)
+	if n == 0 {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+
+	sc := ll.scs[0]
+	if n > 1 {
+		i := rand.Intn(n)
+		j := rand.Intn(n - 1)
+		if j >= i {
+			j++
+		}
+
+		sc = ll.scs[i]
+		if atomic.LoadInt64(ll.inflight[ll.scs[j]]) < atomic.LoadInt64(ll.inflight[sc]) {
+			sc = ll.scs[j]
+		}
+	}
+
+	counter := ll.inflight[sc]
+	atomic.AddInt64(counter, 1)
+
+	doneFunc := func(info balancer.DoneInfo) {
+		atomic.AddInt64(counter, -1)
+	}
+	return balancer.PickResult{SubConn: sc, Done: doneFunc}, nil
+}
@@ -0,0 +1,39 @@
+package picker
+
+import (
+	"google.golang.org/grpc/resolver"
+)
+
+// AddrMetadata is carried in "resolver.Address.Attributes" by resolvers that know
+// more about an endpoint than its address, e.g. the grpcwatch resolver.
+// It must stay comparable since attributes are compared with "==".
+type AddrMetadata struct {
+	// Weight is the relative share of requests the endpoint should receive.
+	// 0 is treated as 1.
+	Weight uint32
+
+	// Zone is the availability zone of the endpoint, see the "ZoneAware" policy.
+	Zone string
+}
+
+type addrMetadataKey struct{}
+
+// SetAddrMetadata returns a copy of addr carrying md in its attributes.
+func SetAddrMetadata(addr resolver.Address, md AddrMetadata) resolver.Address {
+	addr.Attributes = addr.Attributes.WithValue(addrMetadataKey{}, md)
+	return addr
+}
+
+// GetAddrMetadata returns the metadata set by "SetAddrMetadata", if any.
+func GetAddrMetadata(addr resolver.Address) (AddrMetadata, bool) {
+	md, ok := addr.Attributes.Value(addrMetadataKey{}).(AddrMetadata)
+	return md, ok
+}
+
+// weight returns the weight of the address, defaulting to 1.
+func weight(addr resolver.Address) int {
+	if md, ok := GetAddrMetadata(addr); ok && md.Weight > 0 {
+		return int(md.Weight)
+	}
+	return 1
+}
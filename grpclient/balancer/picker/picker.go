@@ -1,7 +1,6 @@
 package picker
 
 import (
-	"context"
 	"fmt"
 
 	"google.golang.org/grpc/balancer"
@@ -22,8 +21,37 @@ type Config struct {
 	// SubConnToResolverAddress maps each gRPC sub-connection to an address.
 	// Basically, it is a list of addresses that the Picker can pick from.
 	SubConnToResolverAddress map[balancer.SubConn]resolver.Address
+
+	// Prev is the picker being replaced, if any. Pickers that keep per-SubConn
+	// state (e.g. in-flight request counts) carry it over for the sub-connections
+	// that are still present, so that rebuilding the picker does not reset it.
+	Prev Picker
+
+	// HashKey configures the request key of the "RingHash" policy.
+	HashKey HashKey
+
+	// Factory builds the picker of the "Custom" policy.
+	Factory Factory
+
+	// Zone is the availability zone of the client, the "ZoneAware" policy prefers
+	// the sub-connections whose "AddrMetadata.Zone" equals it.
+	Zone string
+
+	// ZoneReady is the number of ready sub-connections in Zone, as reported by
+	// the connectivity recorder of the balancer.
+	ZoneReady int
+
+	// MinZoneReady is the number of ready sub-connections in Zone below which the
+	// "ZoneAware" policy spills requests over to the other zones.
+	// If 0, it defaults to 1, i.e. other zones are only used when Zone has none.
+	MinZoneReady int
 }
 
+// Factory builds a Picker from the ready sub-connections in
+// "Config.SubConnToResolverAddress". It is called every time the set of
+// ready sub-connections changes, so it should be cheap.
+type Factory func(cfg Config) Picker
+
 // Policy defines balancer picker policy.
 type Policy uint8
 
@@ -35,8 +63,26 @@ const (
 	// and implements failover in roundrobin fashion.
 	RoundrobinBalanced
 
-	// Custom defines custom balancer picker.
-	// TODO: custom picker is not supported yet.
+	// LeastLoaded picks the sub-connection with fewer in-flight requests out of
+	// two chosen at random ("power of two choices"), so that a slow endpoint
+	// stops receiving new requests while its outstanding ones pile up.
+	LeastLoaded
+
+	// WeightedRoundrobin balances loads over multiple endpoints in proportion
+	// to their weights (see "AddrMetadata") using smooth weighted roundrobin.
+	WeightedRoundrobin
+
+	// RingHash picks endpoints by consistent hashing of a request key (see
+	// "HashKey"), so that requests with the same key keep hitting the same
+	// endpoint while the set of endpoints changes.
+	RingHash
+
+	// ZoneAware balances loads in roundrobin fashion over the endpoints in the
+	// zone of the client (see "Config.Zone"), and fails over to the endpoints in
+	// other zones while too few endpoints are ready in it.
+	ZoneAware
+
+	// Custom defines custom balancer picker built by "Config.Factory".
 	Custom
 )
 
@@ -48,8 +94,20 @@ func (p Policy) String() string {
 	case RoundrobinBalanced:
 		return "picker-roundrobin-balanced"
 
+	case LeastLoaded:
+		return "picker-least-loaded"
+
+	case WeightedRoundrobin:
+		return "picker-weighted-roundrobin"
+
+	case RingHash:
+		return "picker-ring-hash"
+
+	case ZoneAware:
+		return "picker-zone-aware"
+
 	case Custom:
-		panic("'custom' picker policy is not supported yet")
+		return "picker-custom"
 
 	default:
 		panic(fmt.Errorf("invalid balancer picker policy (%d)", p))
@@ -65,8 +123,23 @@ func New(cfg Config) Picker {
 	case RoundrobinBalanced:
 		return newRoundrobinBalanced(cfg)
 
+	case LeastLoaded:
+		return newLeastLoaded(cfg)
+
+	case WeightedRoundrobin:
+		return newWeightedRoundrobin(cfg)
+
+	case RingHash:
+		return newRingHash(cfg)
+
+	case ZoneAware:
+		return newZoneAware(cfg)
+
 	case Custom:
-		panic("'custom' picker policy is not supported yet")
+		if cfg.Factory == nil {
+			panic("'custom' picker policy requires a picker factory")
+		}
+		return cfg.Factory(cfg)
 
 	default:
 		panic(fmt.Errorf("invalid balancer picker policy (%d)", cfg.Policy))
@@ -87,6 +160,6 @@ func (ep *errPicker) String() string {
 	return ep.p.String()
 }
 
-func (ep *errPicker) Pick(context.Context, balancer.PickOptions) (balancer.SubConn, func(balancer.DoneInfo), error) {
-	return nil, nil, ep.err
+func (ep *errPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	return balancer.PickResult{}, ep.err
 }
@@ -0,0 +1,264 @@
+package picker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+)
+
+type testSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+// newSubConns returns a sub-connection for every address, with the metadata of mds if any.
+func newSubConns(addrs []string, mds ...AddrMetadata) map[balancer.SubConn]resolver.Address {
+	scs := make(map[balancer.SubConn]resolver.Address, len(addrs))
+	for i, addr := range addrs {
+		a := resolver.Address{Addr: addr}
+		if i < len(mds) {
+			a = SetAddrMetadata(a, mds[i])
+		}
+		scs[&testSubConn{addr: addr}] = a
+	}
+	return scs
+}
+
+// pickN picks n times and counts the picks of every address.
+func pickN(t *testing.T, ctx context.Context, p Picker, n int) map[string]int {
+	t.Helper()
+
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		res, err := p.Pick(balancer.PickInfo{Ctx: ctx})
+		if err != nil {
+			t.Fatalf("pick %d: %v", i, err)
+		}
+		counts[res.SubConn.(*testSubConn).addr]++
+	}
+	return counts
+}
+
+func TestPickerNoSubConn(t *testing.T) {
+	for _, policy := range []Policy{RoundrobinBalanced, LeastLoaded, WeightedRoundrobin, RingHash, ZoneAware} {
+		p := New(Config{Policy: policy})
+		if _, err := p.Pick(balancer.PickInfo{Ctx: context.Background()}); err != balancer.ErrNoSubConnAvailable {
+			t.Errorf("%s: expected %v, got %v", policy, balancer.ErrNoSubConnAvailable, err)
+		}
+	}
+}
+
+func TestRoundrobinBalanced(t *testing.T) {
+	tests := []struct {
+		addrs []string
+		picks int
+	}{
+		{[]string{"a:1"}, 3},
+		{[]string{"a:1", "b:1"}, 10},
+		{[]string{"a:1", "b:1", "c:1"}, 9},
+	}
+
+	for i, tt := range tests {
+		p := New(Config{Policy: RoundrobinBalanced, SubConnToResolverAddress: newSubConns(tt.addrs)})
+		counts := pickN(t, context.Background(), p, tt.picks)
+		for _, addr := range tt.addrs {
+			if counts[addr] != tt.picks/len(tt.addrs) {
+				t.Errorf("#%d: expected %d picks of %s, got %v", i, tt.picks/len(tt.addrs), addr, counts)
+			}
+		}
+	}
+}
+
+func TestLeastLoaded(t *testing.T) {
+	tests := []struct {
+		// inflight is the number of requests left in flight on a:1 before picking
+		inflight int
+		expected string
+	}{
+		{1, "b:1"},
+		{5, "b:1"},
+	}
+
+	for i, tt := range tests {
+		scs := newSubConns([]string{"a:1", "b:1"})
+		p := New(Config{Policy: LeastLoaded, SubConnToResolverAddress: scs}).(*leastLoaded)
+		for sc := range scs {
+			if sc.(*testSubConn).addr == "a:1" {
+				*p.inflight[sc] = int64(tt.inflight)
+			}
+		}
+
+		// the counters are carried over to the rebuilt picker
+		p = New(Config{Policy: LeastLoaded, SubConnToResolverAddress: scs, Prev: p}).(*leastLoaded)
+
+		res, err := p.Pick(balancer.PickInfo{Ctx: context.Background()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr := res.SubConn.(*testSubConn).addr; addr != tt.expected {
+			t.Errorf("#%d: expected %s, got %s", i, tt.expected, addr)
+		}
+		if n := *p.inflight[res.SubConn]; n != 1 {
+			t.Errorf("#%d: expected 1 request in flight, got %d", i, n)
+		}
+		res.Done(balancer.DoneInfo{})
+		if n := *p.inflight[res.SubConn]; n != 0 {
+			t.Errorf("#%d: expected 0 requests in flight after done, got %d", i, n)
+		}
+	}
+}
+
+func TestWeightedRoundrobin(t *testing.T) {
+	tests := []struct {
+		weights  []uint32
+		expected []int
+	}{
+		{[]uint32{1, 1}, []int{5, 5}},
+		{[]uint32{5, 1, 1}, []int{50, 10, 10}},
+		// weight 0 is treated as 1
+		{[]uint32{0, 3}, []int{10, 30}},
+	}
+
+	for i, tt := range tests {
+		addrs := make([]string, len(tt.weights))
+		mds := make([]AddrMetadata, len(tt.weights))
+		picks := 0
+		for j, w := range tt.weights {
+			addrs[j] = fmt.Sprintf("10.0.0.%d:80", j)
+			mds[j] = AddrMetadata{Weight: w}
+			picks += tt.expected[j]
+		}
+
+		p := New(Config{Policy: WeightedRoundrobin, SubConnToResolverAddress: newSubConns(addrs, mds...)})
+		counts := pickN(t, context.Background(), p, picks)
+		for j, addr := range addrs {
+			if counts[addr] != tt.expected[j] {
+				t.Errorf("#%d: expected %d picks of %s, got %v", i, tt.expected[j], addr, counts)
+			}
+		}
+	}
+}
+
+type testCtxKey struct{}
+
+func TestRingHash(t *testing.T) {
+	tests := []struct {
+		hashKey HashKey
+		ctx     func(key string) context.Context
+	}{
+		{
+			HashKey{},
+			func(key string) context.Context { return WithHashKey(context.Background(), key) },
+		},
+		{
+			HashKey{MetadataKey: "uid"},
+			func(key string) context.Context {
+				return metadata.AppendToOutgoingContext(context.Background(), "uid", key)
+			},
+		},
+		{
+			HashKey{ContextKey: testCtxKey{}},
+			func(key string) context.Context { return context.WithValue(context.Background(), testCtxKey{}, key) },
+		},
+	}
+
+	addrs := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.4:80"}
+	for i, tt := range tests {
+		scs := newSubConns(addrs)
+		p := New(Config{Policy: RingHash, SubConnToResolverAddress: scs, HashKey: tt.hashKey})
+
+		owners := make(map[string]string)
+		used := make(map[string]struct{})
+		for k := 0; k < 100; k++ {
+			key := fmt.Sprintf("key-%d", k)
+			counts := pickN(t, tt.ctx(key), p, 3)
+			if len(counts) != 1 {
+				t.Fatalf("#%d: expected %s to always pick the same address, got %v", i, key, counts)
+			}
+			for addr := range counts {
+				owners[key] = addr
+				used[addr] = struct{}{}
+			}
+		}
+		if len(used) != len(addrs) {
+			t.Errorf("#%d: expected keys spread over %d addresses, got %d", i, len(addrs), len(used))
+		}
+
+		// removing an address only remaps the keys it owned
+		removed := addrs[0]
+		for sc, addr := range scs {
+			if addr.Addr == removed {
+				delete(scs, sc)
+			}
+		}
+		p = New(Config{Policy: RingHash, SubConnToResolverAddress: scs, HashKey: tt.hashKey})
+		for key, owner := range owners {
+			counts := pickN(t, tt.ctx(key), p, 1)
+			if _, ok := counts[owner]; !ok && owner != removed {
+				t.Errorf("#%d: expected %s to stay on %s, got %v", i, key, owner, counts)
+			}
+		}
+	}
+}
+
+func TestCustom(t *testing.T) {
+	built := 0
+	factory := func(cfg Config) Picker {
+		built++
+		return New(Config{Policy: RoundrobinBalanced, SubConnToResolverAddress: cfg.SubConnToResolverAddress})
+	}
+
+	p := New(Config{Policy: Custom, SubConnToResolverAddress: newSubConns([]string{"a:1"}), Factory: factory})
+	if built != 1 {
+		t.Fatalf("expected the factory to be called once, got %d", built)
+	}
+	if counts := pickN(t, context.Background(), p, 2); counts["a:1"] != 2 {
+		t.Errorf("expected 2 picks of a:1, got %v", counts)
+	}
+}
+
+func TestZoneAware(t *testing.T) {
+	addrs := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}
+	mds := []AddrMetadata{{Zone: "a"}, {Zone: "a"}, {Zone: "b"}}
+
+	tests := []struct {
+		zone         string
+		zoneReady    int
+		minZoneReady int
+		expected     []string
+	}{
+		// requests stay in the zone of the client
+		{"a", 2, 0, []string{"10.0.0.1:80", "10.0.0.2:80"}},
+		{"b", 1, 1, []string{"10.0.0.3:80"}},
+		// too few ready in the zone, spill over to the other zones
+		{"a", 1, 2, addrs},
+		{"b", 0, 0, addrs},
+		// no endpoint in the zone
+		{"c", 0, 0, addrs},
+		{"", 0, 0, addrs},
+	}
+
+	for i, tt := range tests {
+		p := New(Config{
+			Policy:                   ZoneAware,
+			SubConnToResolverAddress: newSubConns(addrs, mds...),
+			Zone:                     tt.zone,
+			ZoneReady:                tt.zoneReady,
+			MinZoneReady:             tt.minZoneReady,
+		})
+		counts := pickN(t, context.Background(), p, len(tt.expected)*2)
+		if len(counts) != len(tt.expected) {
+			t.Errorf("#%d: expected picks of %v, got %v", i, tt.expected, counts)
+			continue
+		}
+		for _, addr := range tt.expected {
+			if counts[addr] != 2 {
+				t.Errorf("#%d: expected 2 picks of %s, got %v", i, addr, counts)
+			}
+		}
+	}
+}
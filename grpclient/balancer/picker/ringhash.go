@@ -0,0 +1,136 @@
+package picker
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+)
+
+// ringReplicas is the number of points each address owns on the hash ring.
+// More points spread keys more evenly at the cost of a larger ring.
+var ringReplicas = 100
+
+// HashKey configures where the RingHash picker reads the key of a request from.
+// The context value takes precedence over the outgoing metadata. Requests
+// without a key are spread randomly.
+type HashKey struct {
+	// MetadataKey is the outgoing metadata key whose first value is hashed.
+	MetadataKey string
+
+	// ContextKey is the context key whose value is hashed, it must be a string
+	// or a "fmt.Stringer". If nil, the value set by "WithHashKey" is used.
+	ContextKey interface{}
+}
+
+type hashKeyCtxKey struct{}
+
+// WithHashKey returns a context carrying the key the RingHash picker hashes
+// when "HashKey.ContextKey" is not configured.
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKeyCtxKey{}, key)
+}
+
+// key returns the hash key of the request, or false if it has none.
+func (hk HashKey) key(ctx context.Context) (string, bool) {
+	ctxKey := hk.ContextKey
+	if ctxKey == nil {
+		ctxKey = hashKeyCtxKey{}
+	}
+	switch v := ctx.Value(ctxKey).(type) {
+	case string:
+		return v, true
+	case fmt.Stringer:
+		return v.String(), true
+	}
+
+	if hk.MetadataKey != "" {
+		if md, ok := metadata.FromOutgoingContext(ctx); ok {
+			if vs := md.Get(hk.MetadataKey); len(vs) > 0 {
+				return vs[0], true
+			}
+		}
+	}
+	return "", false
+}
+
+// newRingHash returns a new consistent hash picker.
+// Points are derived from the address only, so adding or removing an address
+// only remaps the keys owned by that address.
+func newRingHash(cfg Config) Picker {
+	scs := make([]balancer.SubConn, 0, len(cfg.SubConnToResolverAddress))
+	ring := make([]ringPoint, 0, len(cfg.SubConnToResolverAddress)*ringReplicas)
+	for sc, addr := range cfg.SubConnToResolverAddress {
+		scs = append(scs, sc)
+		for i := 0; i < ringReplicas; i++ {
+			ring = append(ring, ringPoint{
+				hash: hashString(fmt.Sprintf("%s#%d", addr.Addr, i)),
+				sc:   sc,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	return &ringHash{
+		p:        RingHash,
+		hashKey:  cfg.HashKey,
+		ring:     ring,
+		scs:      scs,
+		scToAddr: cfg.SubConnToResolverAddress,
+	}
+}
+
+type ringPoint struct {
+	hash uint64
+	sc   balancer.SubConn
+}
+
+type ringHash struct {
+	p        Policy
+	hashKey  HashKey
+	ring     []ringPoint
+	scs      []balancer.SubConn
+	scToAddr map[balancer.SubConn]resolver.Address
+}
+
+func (rh *ringHash) String() string { return rh.p.String() }
+
+// Pick is called for every client request.
+func (rh *ringHash) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if len(rh.scs) == 0 {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+
+	key, ok := rh.hashKey.key(info.Ctx)
+	if !ok {
+		return balancer.PickResult{SubConn: rh.scs[rand.Intn(len(rh.scs))]}, nil
+	}
+
+	// the first point clockwise from the hash of the key owns it
+	h := hashString(key)
+	i := sort.Search(len(rh.ring), func(i int) bool { return rh.ring[i].hash >= h })
+	if i == len(rh.ring) {
+		i = 0
+	}
+	return balancer.PickResult{SubConn: rh.ring[i].sc}, nil
+}
+
+// hashString returns the FNV-1a hash of s passed through the murmur3 finalizer,
+// FNV alone spreads short, similar strings such as "10.0.0.1:80#1" poorly.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -1,7 +1,6 @@
 package picker
 
 import (
-	"context"
 	"sync"
 
 	"google.golang.org/grpc/balancer"
@@ -32,12 +31,12 @@ type rrBalanced struct {
 func (rb *rrBalanced) String() string { return rb.p.String() }
 
 // Pick is called for every client request.
-func (rb *rrBalanced) Pick(ctx context.Context, opts balancer.PickOptions) (balancer.SubConn, func(balancer.DoneInfo), error) {
+func (rb *rrBalanced) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	rb.mu.RLock()
 	n := len(rb.scs)
 	rb.mu.RUnlock()
 	if n == 0 {
-		return nil, nil, balancer.ErrNoSubConnAvailable
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
 	}
 
 	rb.mu.Lock()
@@ -47,7 +46,7 @@ func (rb *rrBalanced) Pick(ctx context.Context, opts balancer.PickOptions) (bala
 	rb.next = (rb.next + 1) % len(rb.scs)
 	rb.mu.Unlock()
 
-	//fmt.Printf("balancer done2, address: %s, opts: %+v\n", picked, opts)
+	//fmt.Printf("balancer done2, address: %s, method: %s\n", picked, info.FullMethodName)
 
 	doneFunc := func(info balancer.DoneInfo) {
 		// TODO: error handling?
@@ -57,5 +56,5 @@ func (rb *rrBalanced) Pick(ctx context.Context, opts balancer.PickOptions) (bala
 			//fmt.Println("balancer failed", info.Err)
 		}
 	}
-	return sc, doneFunc, nil
+	return balancer.PickResult{SubConn: sc, Done: doneFunc}, nil
 }
@@ -0,0 +1,63 @@
+package picker
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+)
+
+// newWeightedRoundrobin returns a new smooth weighted roundrobin picker.
+func newWeightedRoundrobin(cfg Config) Picker {
+	scs := make([]*weightedSubConn, 0, len(cfg.SubConnToResolverAddress))
+	for sc, addr := range cfg.SubConnToResolverAddress {
+		scs = append(scs, &weightedSubConn{sc: sc, weight: weight(addr)})
+	}
+	return &wrrBalanced{
+		p:        WeightedRoundrobin,
+		scs:      scs,
+		scToAddr: cfg.SubConnToResolverAddress,
+	}
+}
+
+type weightedSubConn struct {
+	sc     balancer.SubConn
+	weight int
+
+	// current is the running weight of the smooth weighted roundrobin algorithm.
+	current int
+}
+
+type wrrBalanced struct {
+	p        Policy
+	mu       sync.Mutex
+	scs      []*weightedSubConn
+	scToAddr map[balancer.SubConn]resolver.Address
+}
+
+func (wb *wrrBalanced) String() string { return wb.p.String() }
+
+// Pick is called for every client request.
+// It implements the smooth weighted roundrobin of nginx: every sub-connection
+// gains its weight, the one with the highest running weight is picked and
+// loses the total weight. Picks are spread out instead of being bursty.
+func (wb *wrrBalanced) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if len(wb.scs) == 0 {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+
+	wb.mu.Lock()
+	var best *weightedSubConn
+	total := 0
+	for _, wsc := range wb.scs {
+		wsc.current += wsc.weight
+		total += wsc.weight
+		if best == nil || wsc.current > best.current {
+			best = wsc
+		}
+	}
+	best.current -= total
+	wb.mu.Unlock()
+
+	return balancer.PickResult{SubConn: best.sc}, nil
+}
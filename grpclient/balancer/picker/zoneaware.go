@@ -0,0 +1,34 @@
+package picker
+
+import (
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+)
+
+// newZoneAware returns a new zone aware picker.
+// It roundrobins over the ready sub-connections in the zone of the client, or
+// over all of them once fewer than "Config.MinZoneReady" are ready in the zone.
+func newZoneAware(cfg Config) Picker {
+	minReady := cfg.MinZoneReady
+	if minReady <= 0 {
+		minReady = 1
+	}
+
+	local := make(map[balancer.SubConn]resolver.Address)
+	if cfg.Zone != "" {
+		for sc, addr := range cfg.SubConnToResolverAddress {
+			if md, ok := GetAddrMetadata(addr); ok && md.Zone == cfg.Zone {
+				local[sc] = addr
+			}
+		}
+	}
+
+	scToAddr := cfg.SubConnToResolverAddress
+	if len(local) > 0 && cfg.ZoneReady >= minReady {
+		scToAddr = local
+	}
+
+	rb := newRoundrobinBalanced(Config{SubConnToResolverAddress: scToAddr}).(*rrBalanced)
+	rb.p = ZoneAware
+	return rb
+}
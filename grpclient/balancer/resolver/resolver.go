@@ -51,7 +51,7 @@ func (e *ResolverGroup) addResolver(r *Resolver) {
 	addrs := epsToAddrs(e.endpoints...)
 	e.resolvers = append(e.resolvers, r)
 	e.mu.Unlock()
-	r.cc.NewAddress(addrs) // 此方法会通过接口的形式通知balancer.HandleResolvedAddrs
+	r.cc.UpdateState(resolver.State{Addresses: addrs}) // 此方法会通过接口的形式通知balancer.UpdateClientConnState
 }
 
 func (e *ResolverGroup) removeResolver(r *Resolver) {
@@ -73,7 +73,7 @@ func (e *ResolverGroup) SetEndpoints(endpoints []string) {
 	e.endpoints = endpoints
 
 	for _, r := range e.resolvers {
-		r.cc.NewAddress(addrs)
+		r.cc.UpdateState(resolver.State{Addresses: addrs})
 	}
 
 	e.mu.Unlock()
@@ -99,11 +99,11 @@ func (e *ResolverGroup) Close() {
 }
 
 // Build creates or reuses an etcd resolver for the etcd cluster name identified by the authority part of the target.
-func (b *builder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOption) (resolver.Resolver, error) {
-	if len(target.Authority) < 1 {
+func (b *builder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	if len(target.URL.Host) < 1 {
 		return nil, fmt.Errorf("'etcd' target scheme requires non-empty authority identifying etcd cluster being routed to")
 	}
-	id := target.Authority
+	id := target.URL.Host
 	es, err := b.getResolverGroup(id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build resolver: %v", err)
@@ -162,12 +162,17 @@ type Resolver struct {
 func epsToAddrs(eps ...string) (addrs []resolver.Address) {
 	addrs = make([]resolver.Address, 0, len(eps))
 	for _, ep := range eps {
-		addrs = append(addrs, resolver.Address{Addr: ep})
+		addr := resolver.Address{Addr: ep}
+		// verify the TLS certificate of each endpoint against its own host
+		if proto, host, _ := ParseEndpoint(ep); proto == "tcp" {
+			addr.ServerName = host
+		}
+		addrs = append(addrs, addr)
 	}
 	return addrs
 }
 
-func (*Resolver) ResolveNow(o resolver.ResolveNowOption) {}
+func (*Resolver) ResolveNow(o resolver.ResolveNowOptions) {}
 
 func (r *Resolver) Close() {
 	es, err := bldr.getResolverGroup(r.endpointID)
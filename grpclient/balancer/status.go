@@ -0,0 +1,108 @@
+package balancer
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	grpcconnectivity "google.golang.org/grpc/connectivity"
+)
+
+// statusBufferSize is the capacity of the channels returned by "StatusTracker.Subscribe".
+var statusBufferSize = 64
+
+// EndpointStatus describes the connectivity of one endpoint of a client connection.
+type EndpointStatus struct {
+	// Addr is the address of the endpoint.
+	Addr string
+
+	// State is the current connectivity state of the endpoint's sub-connection.
+	// Shutdown is only sent to subscribers, when the endpoint is removed.
+	State grpcconnectivity.State
+
+	// LastTransition is the time the endpoint entered State.
+	LastTransition time.Time
+
+	// LastError is the error of the last failed connection attempt, if any.
+	// It is kept after the endpoint recovers.
+	LastError error
+}
+
+// StatusTracker records the connectivity of every endpoint of a balancer. It is handed to
+// the balancer of a client connection by "Options.Status", otherwise every balancer records
+// into a tracker of its own.
+type StatusTracker struct {
+	mu        sync.RWMutex
+	endpoints map[string]EndpointStatus
+	subs      map[chan EndpointStatus]struct{}
+}
+
+// NewStatusTracker returns an empty status tracker.
+func NewStatusTracker() *StatusTracker {
+	return &StatusTracker{
+		endpoints: make(map[string]EndpointStatus),
+		subs:      make(map[chan EndpointStatus]struct{}),
+	}
+}
+
+// Endpoints returns the status of every endpoint, sorted by address.
+func (st *StatusTracker) Endpoints() []EndpointStatus {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	eps := make([]EndpointStatus, 0, len(st.endpoints))
+	for _, es := range st.endpoints {
+		eps = append(eps, es)
+	}
+	sort.Slice(eps, func(i, j int) bool { return eps[i].Addr < eps[j].Addr })
+	return eps
+}
+
+// Subscribe returns a channel receiving every connectivity transition until ctx is done,
+// the channel is closed then. Transitions are dropped while the channel is full.
+func (st *StatusTracker) Subscribe(ctx context.Context) <-chan EndpointStatus {
+	ch := make(chan EndpointStatus, statusBufferSize)
+
+	st.mu.Lock()
+	st.subs[ch] = struct{}{}
+	st.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		st.mu.Lock()
+		delete(st.subs, ch)
+		close(ch)
+		st.mu.Unlock()
+	}()
+
+	return ch
+}
+
+// record updates the status of addr and notifies the subscribers.
+func (st *StatusTracker) record(addr string, s grpcconnectivity.State, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	es := st.endpoints[addr]
+	es.Addr = addr
+	es.State = s
+	es.LastTransition = time.Now()
+	if err != nil {
+		es.LastError = err
+	}
+
+	if s == grpcconnectivity.Shutdown {
+		delete(st.endpoints, addr)
+	} else {
+		st.endpoints[addr] = es
+	}
+
+	for ch := range st.subs {
+		select {
+		case ch <- es:
+		default:
+		}
+	}
+}
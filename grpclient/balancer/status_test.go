@@ -0,0 +1,98 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	grpcconnectivity "google.golang.org/grpc/connectivity"
+)
+
+func TestStatusTrackerRecord(t *testing.T) {
+	st := NewStatusTracker()
+	connErr := errors.New("connection refused")
+
+	tests := []struct {
+		addr  string
+		state grpcconnectivity.State
+		err   error
+
+		// the endpoints and their last errors after recording
+		endpoints []string
+		lastErrs  []error
+	}{
+		{"b:80", grpcconnectivity.Connecting, nil, []string{"b:80"}, []error{nil}},
+		{"a:80", grpcconnectivity.Connecting, nil, []string{"a:80", "b:80"}, []error{nil, nil}},
+		{"b:80", grpcconnectivity.TransientFailure, connErr, []string{"a:80", "b:80"}, []error{nil, connErr}},
+		// the last error is kept after recovering
+		{"b:80", grpcconnectivity.Ready, nil, []string{"a:80", "b:80"}, []error{nil, connErr}},
+		{"a:80", grpcconnectivity.Shutdown, nil, []string{"b:80"}, []error{connErr}},
+	}
+
+	for i, tt := range tests {
+		before := time.Now()
+		st.record(tt.addr, tt.state, tt.err)
+
+		var endpoints []string
+		var lastErrs []error
+		for _, es := range st.Endpoints() {
+			endpoints = append(endpoints, es.Addr)
+			lastErrs = append(lastErrs, es.LastError)
+			if es.Addr == tt.addr && (es.State != tt.state || es.LastTransition.Before(before)) {
+				t.Errorf("#%d: expected %s to be %v since %v, got %v since %v", i, tt.addr, tt.state, before, es.State, es.LastTransition)
+			}
+		}
+		if !reflect.DeepEqual(endpoints, tt.endpoints) || !reflect.DeepEqual(lastErrs, tt.lastErrs) {
+			t.Errorf("#%d: expected %v %v, got %v %v", i, tt.endpoints, tt.lastErrs, endpoints, lastErrs)
+		}
+	}
+}
+
+func TestStatusTrackerSubscribe(t *testing.T) {
+	defer func(n int) { statusBufferSize = n }(statusBufferSize)
+	statusBufferSize = 2
+
+	st := NewStatusTracker()
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	ch1, ch2 := st.Subscribe(ctx1), st.Subscribe(ctx2)
+
+	// the transitions beyond the buffer are dropped instead of blocking the balancer
+	states := []grpcconnectivity.State{grpcconnectivity.Connecting, grpcconnectivity.Ready, grpcconnectivity.Shutdown}
+	for _, s := range states {
+		st.record("a:80", s, nil)
+	}
+
+	for i, ch := range []<-chan EndpointStatus{ch1, ch2} {
+		for _, s := range states[:2] {
+			if es := <-ch; es.Addr != "a:80" || es.State != s {
+				t.Errorf("#%d: expected a:80 %v, got %v %v", i, s, es.Addr, es.State)
+			}
+		}
+		select {
+		case es := <-ch:
+			t.Errorf("#%d: expected the transition to be dropped, got %v", i, es)
+		default:
+		}
+	}
+
+	// the channel is closed once ctx is done, the other subscriber keeps receiving
+	cancel1()
+	select {
+	case _, ok := <-ch1:
+		if ok {
+			t.Error("expected the channel to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the channel to be closed")
+	}
+
+	st.record("b:80", grpcconnectivity.Connecting, nil)
+	if es := <-ch2; es.Addr != "b:80" {
+		t.Errorf("expected b:80, got %v", es.Addr)
+	}
+}
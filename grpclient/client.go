@@ -3,25 +3,32 @@ package grpclient
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/xkeyideal/grpcwatch/grpclient/balancer"
 	"github.com/xkeyideal/grpcwatch/grpclient/balancer/picker"
 	"github.com/xkeyideal/grpcwatch/grpclient/balancer/resolver"
+	"github.com/xkeyideal/grpcwatch/transport"
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
 
 	"github.com/google/uuid"
-	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/grpclog"
 	"google.golang.org/grpc/keepalive"
 )
 
 func init() {
-	balancer.RegisterBuilder(balancer.Config{
-		Policy: picker.RoundrobinBalanced,
-		Name:   roundRobinBalancerName,
-	})
+	for _, policy := range []picker.Policy{picker.RoundrobinBalanced, picker.LeastLoaded, picker.WeightedRoundrobin, picker.RingHash, picker.ZoneAware, picker.Custom} {
+		balancer.RegisterBuilder(balancer.Config{
+			Policy:      policy,
+			Name:        BalancerName(policy),
+			HealthCheck: true,
+		})
+	}
 }
 
 type GrpcClient struct {
@@ -29,12 +36,20 @@ type GrpcClient struct {
 
 	cfg           *GrpcClientConfig
 	resolverGroup *resolver.ResolverGroup
+	balancerName  string
 	mu            *sync.RWMutex
 
+	// lbConfig is passed to the balancer by the service config
+	lbConfig balancer.LBConfig
+
 	ctx    context.Context
 	cancel context.CancelFunc
 
 	callOpts []grpc.CallOption
+
+	// status records the connectivity of the endpoints of Conn, it is handed to the balancer
+	// by the lb config
+	status *balancer.StatusTracker
 }
 
 // Close shuts down the client's etcd connections.
@@ -44,6 +59,7 @@ func (c *GrpcClient) Close() error {
 	if c.resolverGroup != nil {
 		c.resolverGroup.Close()
 	}
+	balancer.UnregisterOptions(c.lbConfig.OptionsID)
 	if c.Conn != nil {
 		return c.Conn.Close()
 	}
@@ -64,6 +80,27 @@ func (c *GrpcClient) Endpoints() []string {
 	return eps
 }
 
+// EndpointStatus returns the connectivity state, time of the last transition and
+// last connection error of every endpoint, sorted by address.
+func (c *GrpcClient) EndpointStatus() []balancer.EndpointStatus {
+	return c.status.Endpoints()
+}
+
+// WatchEndpointStatus returns a channel receiving every connectivity transition of
+// the endpoints until ctx is done or the client is closed. Transitions are dropped
+// while the channel is full, call "EndpointStatus" to get the current status.
+func (c *GrpcClient) WatchEndpointStatus(ctx context.Context) <-chan balancer.EndpointStatus {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.ctx.Done():
+		}
+		cancel()
+	}()
+	return c.status.Subscribe(ctx)
+}
+
 // SetEndpoints updates client's endpoints.
 func (c *GrpcClient) SetEndpoints(eps ...string) {
 	c.mu.Lock()
@@ -72,17 +109,98 @@ func (c *GrpcClient) SetEndpoints(eps ...string) {
 	c.resolverGroup.SetEndpoints(eps)
 }
 
+// Sync synchronizes client's endpoints with the known members of the watch server cluster.
+// The endpoints are left unchanged if the servers do not advertise themselves.
+func (c *GrpcClient) Sync(ctx context.Context) error {
+	resp, err := pb.NewWatchRPCClient(c.Conn).MemberList(ctx, &pb.Empty{}, c.callOpts...)
+	if err != nil {
+		return err
+	}
+	if len(resp.Endpoints) == 0 {
+		return nil
+	}
+
+	eps := append([]string(nil), resp.Endpoints...)
+	sort.Strings(eps)
+	cur := c.Endpoints()
+	sort.Strings(cur)
+	if stringsEqual(eps, cur) {
+		return nil
+	}
+
+	c.SetEndpoints(eps...)
+	return nil
+}
+
+func (c *GrpcClient) autoSync() {
+	if c.cfg.AutoSyncInterval == time.Duration(0) {
+		return
+	}
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(c.cfg.AutoSyncInterval):
+			ctx, cancel := context.WithTimeout(c.ctx, 5*time.Second)
+			err := c.Sync(ctx)
+			cancel()
+			if err != nil && err != c.ctx.Err() {
+				grpclog.Warningf("grpclient: auto sync endpoints failed: %v", err)
+			}
+		}
+	}
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // dialWithBalancer dials the client's current load balanced resolver group.  The scheme of the host
 // of the provided endpoint determines the scheme used for all endpoints of the client connection.
 func (c *GrpcClient) dialWithBalancer(ep string, dopts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	_, host, _ := resolver.ParseEndpoint(ep)
 	target := c.resolverGroup.Target(host)
-	return c.dial(target, dopts...)
+	creds, err := c.dialWithBalancerCreds(ep)
+	if err != nil {
+		return nil, err
+	}
+	return c.dial(target, creds, dopts...)
+}
+
+// dialWithBalancerCreds returns the transport credentials selected by the scheme of the
+// provided endpoint, or nil if the connection is insecure.
+func (c *GrpcClient) dialWithBalancerCreds(ep string) (credentials.TransportCredentials, error) {
+	_, _, scheme := resolver.ParseEndpoint(ep)
+	switch scheme {
+	case "http", "unix":
+		return nil, nil
+	case "https", "unixs":
+	default:
+		if c.cfg.TLS == nil {
+			return nil, nil
+		}
+	}
+
+	// secure endpoints without TLS config are verified against the system roots
+	info := transport.TLSInfo{}
+	if c.cfg.TLS != nil {
+		info = *c.cfg.TLS
+	}
+	return info.ClientCredentials()
 }
 
 // dial configures and dials any grpc balancer target.
-func (c *GrpcClient) dial(target string, dopts ...grpc.DialOption) (*grpc.ClientConn, error) {
-	opts, err := c.dialSetupOpts(dopts...)
+func (c *GrpcClient) dial(target string, creds credentials.TransportCredentials, dopts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	opts, err := c.dialSetupOpts(creds, dopts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to configure dialer: %v", err)
 	}
@@ -108,7 +226,7 @@ func (c *GrpcClient) dial(target string, dopts ...grpc.DialOption) (*grpc.Client
 }
 
 // dialSetupOpts gives the dial opts prior to any authentication.
-func (c *GrpcClient) dialSetupOpts(dopts ...grpc.DialOption) (opts []grpc.DialOption, err error) {
+func (c *GrpcClient) dialSetupOpts(creds credentials.TransportCredentials, dopts ...grpc.DialOption) (opts []grpc.DialOption, err error) {
 	if c.cfg.DialKeepAliveTime > 0 {
 		params := keepalive.ClientParameters{
 			Time:                c.cfg.DialKeepAliveTime,
@@ -119,20 +237,35 @@ func (c *GrpcClient) dialSetupOpts(dopts ...grpc.DialOption) (opts []grpc.DialOp
 	}
 	opts = append(opts, dopts...)
 
+	// 负载均衡策略通过service config选择，开启健康检查时只向grpc.health.v1健康检查为SERVING的服务端发送请求
+	var healthCheckServiceName *string
+	if c.cfg.HealthCheck {
+		healthCheckServiceName = &c.cfg.HealthCheckServiceName
+	}
+	opts = append(opts, grpc.WithDefaultServiceConfig(serviceConfig(c.balancerName, c.lbConfig, healthCheckServiceName)))
+
 	dialer := resolver.Dialer
-	opts = append(opts, grpc.WithInsecure(), grpc.WithInitialWindowSize(65536*100)) // 100*64K
-	opts = append(opts, grpc.WithContextDialer(dialer))
+	if creds != nil {
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	opts = append(opts, grpc.WithInitialWindowSize(65536*100)) // 100*64K
 
-	// 设置拦截器
-	retryOpts := []grpc_retry.CallOption{
-		grpc_retry.WithMax(3),
-		grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100 * time.Millisecond)),
-		grpc_retry.WithCodes(codes.NotFound, codes.Aborted),
+	if c.cfg.TokenSource != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(newTokenCredentials(c.cfg.TokenSource, c.cfg.AllowInsecureToken)))
 	}
+	opts = append(opts, grpc.WithContextDialer(dialer))
 
+	// 设置拦截器，只重试服务端明确未处理的请求，双向流(如Watch)不重试
+	rrBackoff := withBackoff(c.roundRobinBackoff(defaultBackoffWaitBetween, defaultBackoffJitterFraction))
+	retryCodes := withCodes(defaultRetryCodes...)
+	if len(c.cfg.RetryCodes) > 0 {
+		retryCodes = withCodes(c.cfg.RetryCodes...)
+	}
 	opts = append(opts,
-		//grpc.WithStreamInterceptor(c.streamClientInterceptor(withMax(0), rrBackoff)),
-		grpc.WithUnaryInterceptor(grpc_retry.UnaryClientInterceptor(retryOpts...)),
+		grpc.WithStreamInterceptor(c.streamClientInterceptor(withMax(defaultStreamMaxRetries), rrBackoff, retryCodes)),
+		grpc.WithUnaryInterceptor(c.unaryClientInterceptor(withMax(defaultUnaryMaxRetries), rrBackoff, retryCodes)),
 	)
 
 	return opts, nil
@@ -148,6 +281,7 @@ func NewGRPCClient(cfg *GrpcClientConfig) (*GrpcClient, error) {
 		cancel:   cancel,
 		mu:       new(sync.RWMutex),
 		callOpts: defaultCallOpts,
+		status:   balancer.NewStatusTracker(),
 	}
 
 	if cfg.MaxCallSendMsgSize > 0 || cfg.MaxCallRecvMsgSize > 0 {
@@ -168,6 +302,11 @@ func NewGRPCClient(cfg *GrpcClientConfig) (*GrpcClient, error) {
 		client.callOpts = callOpts
 	}
 
+	if cfg.BalancerPolicy == picker.Custom && cfg.PickerFactory == nil {
+		client.cancel()
+		return nil, fmt.Errorf("custom balancer policy requires PickerFactory")
+	}
+
 	var err error
 
 	client.resolverGroup, err = resolver.NewResolverGroup(fmt.Sprintf("client-%s", uuid.New().String()))
@@ -180,14 +319,40 @@ func NewGRPCClient(cfg *GrpcClientConfig) (*GrpcClient, error) {
 
 	client.resolverGroup.SetEndpoints(cfg.Endpoints)
 
-	conn, err := client.dialWithBalancer(dialEndpoint, grpc.WithBalancerName(roundRobinBalancerName))
+	policy := picker.RoundrobinBalanced
+	switch {
+	case cfg.PickerFactory != nil:
+		policy = picker.Custom
+	case cfg.BalancerPolicy != picker.Error:
+		policy = cfg.BalancerPolicy
+	}
+
+	// 自定义picker、outlier detection与可用区是每个client独有的配置，通过service config的loadBalancingConfig传递给balancer，
+	// 无法序列化的配置以及记录连接状态的StatusTracker注册在balancer包中，由OptionsID引用
+	client.balancerName = BalancerName(policy)
+	client.lbConfig = balancer.LBConfig{
+		Zone:            cfg.Zone,
+		MinZoneReady:    cfg.MinZoneReady,
+		HashMetadataKey: cfg.HashKey.MetadataKey,
+	}
+	client.lbConfig.OptionsID = balancer.RegisterOptions(balancer.Options{
+		Factory:          cfg.PickerFactory,
+		OutlierDetection: cfg.OutlierDetection,
+		HashContextKey:   cfg.HashKey.ContextKey,
+		Status:           client.status,
+	})
+
+	conn, err := client.dialWithBalancer(dialEndpoint)
 	if err != nil {
 		client.cancel()
 		client.resolverGroup.Close()
+		balancer.UnregisterOptions(client.lbConfig.OptionsID)
 		return nil, err
 	}
 
 	client.Conn = conn
 
+	go client.autoSync()
+
 	return client, nil
 }
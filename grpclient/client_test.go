@@ -0,0 +1,254 @@
+package grpclient
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xkeyideal/grpcwatch/grpclient/balancer"
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testServer answers GetAppServers with its own address, and MemberList with members.
+type testServer struct {
+	pb.UnimplementedWatchRPCServer
+
+	addr   string
+	health *health.Server
+
+	mu      sync.Mutex
+	members []string
+}
+
+func (s *testServer) GetAppServers(context.Context, *pb.App) (*pb.GetAppResponse, error) {
+	return &pb.GetAppResponse{App: &pb.App{Name: s.addr}}, nil
+}
+
+func (s *testServer) MemberList(context.Context, *pb.Empty) (*pb.MemberListResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &pb.MemberListResponse{Endpoints: s.members}, nil
+}
+
+func (s *testServer) setMembers(members ...string) {
+	s.mu.Lock()
+	s.members = members
+	s.mu.Unlock()
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{addr: l.Addr().String(), health: health.NewServer()}
+	server := grpc.NewServer()
+	pb.RegisterWatchRPCServer(server, s)
+	healthpb.RegisterHealthServer(server, s.health)
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+	return s
+}
+
+func newTestClient(t *testing.T, cfg *GrpcClientConfig) *GrpcClient {
+	t.Helper()
+
+	c, err := NewGRPCClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// servedBy returns the addresses of the servers answering n requests of c.
+func servedBy(t *testing.T, c *GrpcClient, n int) map[string]int {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	served := make(map[string]int)
+	remote := pb.NewWatchRPCClient(c.Conn)
+	for i := 0; i < n; i++ {
+		resp, err := remote.GetAppServers(ctx, &pb.App{}, grpc.WaitForReady(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		served[resp.App.Name]++
+	}
+	return served
+}
+
+// waitServedBy waits until the requests of c are served by exactly the servers of addrs.
+func waitServedBy(t *testing.T, c *GrpcClient, addrs ...string) {
+	t.Helper()
+
+	var served map[string]int
+	for i := 0; i < 200; i++ {
+		served = servedBy(t, c, 10)
+		ok := len(served) == len(addrs)
+		for _, addr := range addrs {
+			ok = ok && served[addr] > 0
+		}
+		if ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected the requests to be served by %v, got %v", addrs, served)
+}
+
+func TestHealthCheck(t *testing.T) {
+	const service = "watchpb.WatchRPC"
+
+	a, b := newTestServer(t), newTestServer(t)
+	for _, s := range []*testServer{a, b} {
+		s.health.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	}
+
+	c := newTestClient(t, &GrpcClientConfig{
+		Endpoints:              []string{a.addr, b.addr},
+		HealthCheck:            true,
+		HealthCheckServiceName: service,
+	})
+	waitServedBy(t, c, a.addr, b.addr)
+
+	tests := []struct {
+		status healthpb.HealthCheckResponse_ServingStatus
+
+		servedBy []string
+	}{
+		{healthpb.HealthCheckResponse_NOT_SERVING, []string{a.addr}},
+		{healthpb.HealthCheckResponse_SERVING, []string{a.addr, b.addr}},
+	}
+
+	for _, tt := range tests {
+		b.health.SetServingStatus(service, tt.status)
+		waitServedBy(t, c, tt.servedBy...)
+	}
+}
+
+// waitEndpointStatus waits until the status of every endpoint of c is in states.
+func waitEndpointStatus(t *testing.T, c *GrpcClient, states map[string]connectivity.State) []balancer.EndpointStatus {
+	t.Helper()
+
+	var eps []balancer.EndpointStatus
+	for i := 0; i < 500; i++ {
+		eps = c.EndpointStatus()
+		ok := len(eps) == len(states)
+		for _, es := range eps {
+			ok = ok && states[es.Addr] == es.State
+		}
+		if ok {
+			return eps
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected the endpoints to be %v, got %v", states, eps)
+	return nil
+}
+
+func TestEndpointStatus(t *testing.T) {
+	a := newTestServer(t)
+
+	// nothing listens on the closed endpoint
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	c1 := newTestClient(t, &GrpcClientConfig{Endpoints: []string{a.addr, closed}})
+	c2 := newTestClient(t, &GrpcClientConfig{Endpoints: []string{a.addr}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := c1.WatchEndpointStatus(ctx)
+
+	servedBy(t, c1, 1)
+	eps := waitEndpointStatus(t, c1, map[string]connectivity.State{a.addr: connectivity.Ready, closed: connectivity.TransientFailure})
+	for _, es := range eps {
+		if (es.LastError != nil) != (es.Addr == closed) {
+			t.Errorf("expected only %s to have a connection error, got %v", closed, es)
+		}
+	}
+
+	// every client tracks the endpoints of its own
+	servedBy(t, c2, 1)
+	waitEndpointStatus(t, c2, map[string]connectivity.State{a.addr: connectivity.Ready})
+
+	seen := make(map[string]bool)
+	for es := range ch {
+		seen[es.Addr+" "+es.State.String()] = true
+		if seen[a.addr+" READY"] && seen[closed+" TRANSIENT_FAILURE"] {
+			break
+		}
+	}
+
+	// the channel is closed once the client is closed
+	c1.Close()
+	for range ch {
+	}
+}
+
+func TestSync(t *testing.T) {
+	a, b := newTestServer(t), newTestServer(t)
+	c := newTestClient(t, &GrpcClientConfig{Endpoints: []string{a.addr}})
+
+	tests := []struct {
+		members []string
+
+		endpoints []string
+	}{
+		// the endpoints are kept if the servers do not advertise themselves
+		{nil, []string{a.addr}},
+		{[]string{b.addr, a.addr}, sortedStrings(a.addr, b.addr)},
+		{[]string{b.addr}, []string{b.addr}},
+	}
+
+	for i, tt := range tests {
+		a.setMembers(tt.members...)
+		b.setMembers(tt.members...)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := c.Sync(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if eps := c.Endpoints(); !reflect.DeepEqual(eps, tt.endpoints) {
+			t.Errorf("#%d: expected endpoints %v, got %v", i, tt.endpoints, eps)
+		}
+		waitServedBy(t, c, tt.endpoints...)
+	}
+}
+
+func TestAutoSync(t *testing.T) {
+	a, b := newTestServer(t), newTestServer(t)
+	c := newTestClient(t, &GrpcClientConfig{Endpoints: []string{a.addr}, AutoSyncInterval: 10 * time.Millisecond})
+
+	for _, members := range [][]string{{a.addr, b.addr}, {b.addr}} {
+		a.setMembers(members...)
+		b.setMembers(members...)
+		waitServedBy(t, c, members...)
+	}
+}
+
+func sortedStrings(ss ...string) []string {
+	sort.Strings(ss)
+	return ss
+}
@@ -18,9 +18,9 @@ import (
 	"context"
 	"fmt"
 
-	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
-	"github.com/coreos/etcd/mvcc/mvccpb"
-	v3 "go.etcd.io/etcd/clientv3"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	v3 "go.etcd.io/etcd/client/v3"
 )
 
 func waitDelete(ctx context.Context, client *v3.Client, key string, rev int64) error {
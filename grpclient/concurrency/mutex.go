@@ -19,8 +19,8 @@ import (
 	"fmt"
 	"sync"
 
-	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
-	v3 "go.etcd.io/etcd/clientv3"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	v3 "go.etcd.io/etcd/client/v3"
 )
 
 // Mutex implements the sync Locker interface with etcd
@@ -2,16 +2,67 @@ package grpclient
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math"
 	"time"
 
+	"github.com/xkeyideal/grpcwatch/grpclient/balancer"
 	"github.com/xkeyideal/grpcwatch/grpclient/balancer/picker"
+	"github.com/xkeyideal/grpcwatch/transport"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
-var roundRobinBalancerName = fmt.Sprintf("grpc-%s", picker.RoundrobinBalanced.String())
+// BalancerName returns the name the balancer of the given picker policy is registered with,
+// to be used with "BalancerServiceConfig" when dialing other targets such as grpcwatch.
+func BalancerName(policy picker.Policy) string {
+	return fmt.Sprintf("grpc-%s", policy.String())
+}
+
+// BalancerServiceConfig returns the service config selecting the balancer registered with name,
+// to be used with "grpc.WithDefaultServiceConfig".
+func BalancerServiceConfig(name string, opts ...BalancerOption) string {
+	var lbConfig balancer.LBConfig
+	for _, opt := range opts {
+		opt(&lbConfig)
+	}
+	return serviceConfig(name, lbConfig, nil)
+}
+
+// BalancerOption configures the balancer selected by "BalancerServiceConfig".
+type BalancerOption func(*balancer.LBConfig)
+
+// WithHashMetadataKey sets the outgoing metadata key whose value the "picker.RingHash"
+// policy hashes, see "picker.HashKey".
+func WithHashMetadataKey(key string) BalancerOption {
+	return func(cfg *balancer.LBConfig) { cfg.HashMetadataKey = key }
+}
+
+// WithZone sets the availability zone of the client used by the "picker.ZoneAware" policy,
+// and the number of ready endpoints in zone below which requests spill over to the other zones.
+func WithZone(zone string, minZoneReady int) BalancerOption {
+	return func(cfg *balancer.LBConfig) {
+		cfg.Zone = zone
+		cfg.MinZoneReady = minZoneReady
+	}
+}
+
+// serviceConfig returns the service config selecting the balancer registered with name
+// configured by lbConfig, and enabling health checking of healthCheckServiceName if it is not nil.
+func serviceConfig(name string, lbConfig balancer.LBConfig, healthCheckServiceName *string) string {
+	sc := map[string]interface{}{
+		"loadBalancingConfig": []map[string]interface{}{{name: lbConfig}},
+	}
+	if healthCheckServiceName != nil {
+		sc["healthCheckConfig"] = map[string]string{"serviceName": *healthCheckServiceName}
+	}
+
+	// the config only holds strings and numbers, marshaling never fails
+	js, _ := json.Marshal(sc)
+	return string(js)
+}
 
 var (
 	// client-side handling retrying of request failures where data was not written to the wire or
@@ -57,10 +108,65 @@ type GrpcClientConfig struct {
 	// Endpoints is a list of URLs.
 	Endpoints []string
 
-	// AutoSyncInterval is the interval to update endpoints with its latest members.
+	// BalancerPolicy is the picker policy used to balance requests over Endpoints.
+	// If 0, it defaults to "picker.RoundrobinBalanced".
+	BalancerPolicy picker.Policy
+
+	// PickerFactory builds the picker of the "picker.Custom" policy, which is used
+	// whenever it is set regardless of "BalancerPolicy". It is handed to the balancer
+	// of the client by "balancer.RegisterOptions".
+	PickerFactory picker.Factory
+
+	// OutlierDetection temporarily ejects endpoints whose requests keep failing.
+	// If nil, outlier detection is disabled.
+	OutlierDetection *balancer.OutlierDetection
+
+	// HashKey configures where the "picker.RingHash" policy reads the key of a request from.
+	// If empty, the key set by "picker.WithHashKey" is used.
+	HashKey picker.HashKey
+
+	// Zone is the availability zone of the client. With the "picker.ZoneAware" policy,
+	// requests are only sent to endpoints in the same zone while enough of them are ready.
+	Zone string
+
+	// MinZoneReady is the number of ready endpoints in Zone below which requests
+	// spill over to the other zones. If 0, it defaults to 1.
+	MinZoneReady int
+
+	// HealthCheck enables active health checking of every endpoint via "grpc.health.v1.Health/Watch",
+	// requests are only sent to endpoints reporting SERVING. Endpoints not implementing the health
+	// service are treated as SERVING.
+	HealthCheck bool
+
+	// HealthCheckServiceName is the service name the health of endpoints is checked for.
+	// If empty, the overall health of the server is checked.
+	HealthCheckServiceName string
+
+	// AutoSyncInterval is the interval to update endpoints with its latest members,
+	// as listed by the "MemberList" RPC of the watch servers (see "watchserver.GrpcServerConfig.AdvertiseAddr").
 	// 0 disables auto-sync. By default auto-sync is disabled.
 	AutoSyncInterval time.Duration
 
+	// TLS holds the client secure credentials, if any. It is used for all endpoints if the
+	// endpoints have no scheme, or have the "https" or "unixs" scheme. Certificates are
+	// reloaded from disk when they change.
+	TLS *transport.TLSInfo
+
+	// TokenSource provides the bearer token attached to every request for the watch server
+	// to authenticate the client. The token is cached until shortly before it expires.
+	// Use "StaticToken" for a fixed token. If nil, requests carry no token.
+	TokenSource TokenSource
+
+	// AllowInsecureToken permits sending the token of TokenSource over insecure connections,
+	// e.g. in development. By default dialing insecure endpoints with a TokenSource fails.
+	AllowInsecureToken bool
+
+	// RetryCodes are the status codes of failed requests that are retried, with a jittered
+	// backoff and within the deadline of the caller. Unary and server-streaming requests are
+	// retried, client and bidirectional streams are not. If empty, it defaults to
+	// "codes.Unavailable", i.e. requests the watch server did not process.
+	RetryCodes []codes.Code
+
 	// DialTimeout is the timeout for failing to establish a connection.
 	DialTimeout time.Duration
 
@@ -0,0 +1,119 @@
+package grpclient
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestJitterUp(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		jitter   float64
+	}{
+		{0, 0.1},
+		{time.Second, 0},
+		{time.Second, 0.1},
+		{25 * time.Millisecond, 0.5},
+		{time.Second, 1},
+	}
+
+	for i, tt := range tests {
+		min := time.Duration(float64(tt.duration) * (1 - tt.jitter))
+		max := time.Duration(float64(tt.duration) * (1 + tt.jitter))
+		for j := 0; j < 100; j++ {
+			if d := jitterUp(tt.duration, tt.jitter); d < min || d > max {
+				t.Fatalf("#%d: expected a duration within [%v, %v], got %v", i, min, max, d)
+			}
+		}
+	}
+}
+
+func TestRoundRobinBackoff(t *testing.T) {
+	tests := []struct {
+		endpoints []string
+
+		// attempts that wait, the others retry the next endpoint immediately
+		waits []uint
+	}{
+		{nil, []uint{1, 2, 3, 4, 5, 6}},
+		{[]string{"127.0.0.1:2379"}, []uint{1, 2, 3, 4, 5, 6}},
+		{[]string{"127.0.0.1:2379", "127.0.0.1:22379"}, []uint{2, 4, 6}},
+		{[]string{"127.0.0.1:2379", "127.0.0.1:22379", "127.0.0.1:32379"}, []uint{3, 6}},
+	}
+
+	waitBetween := 25 * time.Millisecond
+	for i, tt := range tests {
+		c := &GrpcClient{cfg: &GrpcClientConfig{Endpoints: tt.endpoints}, mu: new(sync.RWMutex)}
+		backoff := c.roundRobinBackoff(waitBetween, 0.1)
+
+		waits := make(map[uint]struct{}, len(tt.waits))
+		for _, attempt := range tt.waits {
+			waits[attempt] = struct{}{}
+		}
+		for attempt := uint(1); attempt <= 6; attempt++ {
+			d := backoff(attempt)
+			if _, ok := waits[attempt]; !ok {
+				if d != 0 {
+					t.Errorf("#%d: expected attempt %d not to wait, got %v", i, attempt, d)
+				}
+				continue
+			}
+			if d < waitBetween*9/10 || d > waitBetween*11/10 {
+				t.Errorf("#%d: expected attempt %d to wait about %v, got %v", i, attempt, waitBetween, d)
+			}
+		}
+	}
+}
+
+func TestRetryOptionsRetryable(t *testing.T) {
+	tests := []struct {
+		codes     []codes.Code
+		err       error
+		retryable bool
+	}{
+		{defaultRetryCodes, status.Error(codes.Unavailable, ""), true},
+		{defaultRetryCodes, status.Error(codes.NotFound, ""), false},
+		// context errors are only retried if listed
+		{defaultRetryCodes, status.Error(codes.DeadlineExceeded, ""), false},
+		{defaultRetryCodes, status.Error(codes.Canceled, ""), false},
+		{[]codes.Code{codes.Unavailable, codes.DeadlineExceeded}, status.Error(codes.DeadlineExceeded, ""), true},
+		{[]codes.Code{codes.ResourceExhausted}, status.Error(codes.Unavailable, ""), false},
+	}
+
+	for i, tt := range tests {
+		opts := reuseOrNewWithCallOptions(defaultRetryOptions, []retryOption{withCodes(tt.codes...)})
+		if retryable := opts.retryable(tt.err); retryable != tt.retryable {
+			t.Errorf("#%d: expected retryable %v, got %v", i, tt.retryable, retryable)
+		}
+	}
+}
+
+func TestWaitRetryBackoff(t *testing.T) {
+	tests := []struct {
+		attempt uint
+		wait    time.Duration
+		timeout time.Duration
+		code    codes.Code
+	}{
+		// the first attempt never waits
+		{0, time.Hour, time.Millisecond, codes.OK},
+		{1, time.Millisecond, time.Second, codes.OK},
+		// the wait would exceed the deadline
+		{1, time.Hour, time.Second, codes.DeadlineExceeded},
+	}
+
+	for i, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+		opts := reuseOrNewWithCallOptions(defaultRetryOptions, []retryOption{withBackoff(backoffLinearWithJitter(tt.wait, 0))})
+		err := waitRetryBackoff(ctx, tt.attempt, opts)
+		cancel()
+		if code := status.Code(err); code != tt.code {
+			t.Errorf("#%d: expected %v, got %v", i, tt.code, err)
+		}
+	}
+}
@@ -44,14 +44,21 @@ func main() {
 		fmt.Println(resp)
 	}
 
-	watcherServer := watchclient.NewWatcher("", zapcore.DebugLevel, client)
+	watcherServer := watchclient.NewWatcherWithConfig(&watchclient.WatcherConfig{
+		LogLevel:              zapcore.DebugLevel,
+		ProgressNotifyTimeout: 3 * time.Second,
+	}, client)
 	ch := watcherServer.Watch(context.Background(), "watchertest", app)
 
 	go func() {
 		created := false
 		for {
 			select {
-			case resp := <-ch:
+			case resp, ok := <-ch:
+				// watch被取消后channel会被关闭
+				if !ok {
+					return
+				}
 				fmt.Println(time.Now(), resp)
 				// canceled分为两种情况
 				// 1. 客户端出现异常，处理方案在watchclient/watch_grpc_stream.go 的 run() defer中，此时会主动调用closeStream退出watch steam
@@ -72,9 +79,19 @@ func main() {
 		}
 	}()
 
-	time.Sleep(5 * time.Second)
-	// CloseStream做了watchID是否存在的判断
-	// 当出现canceled的case 1时，也不会因为close(wgs.donec)两次出现panic
+	// 注册一个新的服务器地址，watch会收到UPDATE事件
+	server := &pb.AppServer{Ip: "127.0.0.1", Port: "9091"}
+	kctx, kcancel := context.WithCancel(context.Background())
+	go queryServer.KeepAlive(kctx, app, server, 3)
+
+	time.Sleep(3 * time.Second)
+
+	// 停止心跳并注销，watch会再次收到UPDATE事件
+	kcancel()
+	queryServer.Deregister(context.Background(), app, server)
+
+	time.Sleep(2 * time.Second)
+	// CloseStream做了watchID是否存在的判断，不存在的watchID直接忽略
 	watcherServer.CloseStream("watchertest")
 	time.Sleep(2 * time.Second)
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
 	"github.com/xkeyideal/grpcwatch/watchserver"
 
 	"go.uber.org/zap"
@@ -49,22 +50,31 @@ func newLogger(logFilename string, level zapcore.Level) *zap.Logger {
 
 func main() {
 	cfg := &watchserver.GrpcServerConfig{
-		Port:                  5853,
-		MaxConnectionIdle:     20,
-		PingInterval:          1,
-		Timeout:               3,
-		KeepAliveMinTime:      1,
-		MaxConnectionAge:      120,
-		MaxConnectionAgeGrace: 5,
-		WriteBufferSize:       2 * 1024 * 1024, //2MB
-		ReadBufferSize:        2 * 1024 * 1024,
-		MaxRecvMsgSize:        12 * 1024 * 1024,
-		MaxSendMsgSize:        12 * 1024 * 1024,
-		MaxConcurrentStreams:  655360,
+		Port:                   5853,
+		MaxConnectionIdle:      20,
+		PingInterval:           1,
+		Timeout:                3,
+		KeepAliveMinTime:       1,
+		MaxConnectionAge:       120,
+		MaxConnectionAgeGrace:  5,
+		WriteBufferSize:        2 * 1024 * 1024, //2MB
+		ReadBufferSize:         2 * 1024 * 1024,
+		MaxRecvMsgSize:         12 * 1024 * 1024,
+		MaxSendMsgSize:         12 * 1024 * 1024,
+		MaxConcurrentStreams:   655360,
+		RegisterTTL:            10,
+		ProgressNotifyInterval: 1,
+		Registry:               watchserver.NewMemoryRegistry(),
+	}
+
+	// 预先注册一个测试用的服务器地址
+	err := cfg.Registry.Register(&pb.App{Name: "1122", Env: "qa"}, &pb.AppServer{Ip: "127.0.0.1", Port: "9090"}, 0)
+	if err != nil {
+		panic(err)
 	}
 
 	lg := newLogger("", zapcore.DebugLevel)
-	err := watchserver.NewGrpcServer(cfg, lg)
+	err = watchserver.NewGrpcServer(cfg, lg)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,273 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSInfo configures TLS for both the watch server and its clients.
+// Certificates, keys and CAs are reloaded from disk whenever the files change,
+// so they can be rotated without restarting.
+type TLSInfo struct {
+	// CertFile is the certificate presented to the peer, it is the server certificate
+	// on the watch server and the client certificate for mutual TLS on clients.
+	CertFile string
+
+	// KeyFile is the private key of CertFile.
+	KeyFile string
+
+	// TrustedCAFile verifies the certificates of peers. On clients, the system roots are
+	// used if empty. On the watch server, it is required by ClientCertAuth.
+	TrustedCAFile string
+
+	// ServerName overrides the name the server certificate is verified against on clients.
+	// If empty, the host of the endpoint is used.
+	ServerName string
+
+	// ClientCertAuth requires clients to present a certificate signed by TrustedCAFile.
+	// It only applies to the watch server.
+	ClientCertAuth bool
+}
+
+func (info TLSInfo) String() string {
+	return fmt.Sprintf("cert = %s, key = %s, trusted-ca = %s, client-cert-auth = %v",
+		info.CertFile, info.KeyFile, info.TrustedCAFile, info.ClientCertAuth)
+}
+
+// Empty returns true if no certificate is configured.
+func (info TLSInfo) Empty() bool {
+	return info.CertFile == "" && info.KeyFile == ""
+}
+
+// ServerConfig generates a tls.Config for the watch server.
+func (info TLSInfo) ServerConfig() (*tls.Config, error) {
+	if info.Empty() {
+		return nil, errors.New("transport: server requires a certificate and key")
+	}
+	if info.ClientCertAuth && info.TrustedCAFile == "" {
+		return nil, errors.New("transport: client certificate authentication requires a trusted CA file")
+	}
+
+	r := &reloader{info: info}
+	if _, err := r.certificate(); err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		},
+	}
+
+	if info.TrustedCAFile != "" {
+		if _, err := r.caPool(); err != nil {
+			return nil, err
+		}
+		if info.ClientCertAuth {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+
+		// ClientCAs is read once per handshake from the config returned here,
+		// so that a rotated CA takes effect for new connections.
+		base := cfg.Clone()
+		cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			pool, err := r.caPool()
+			if err != nil {
+				return nil, err
+			}
+			c := base.Clone()
+			c.ClientCAs = pool
+			return c, nil
+		}
+	}
+
+	return cfg, nil
+}
+
+// ClientConfig generates a tls.Config for clients of the watch server.
+// The trusted CA is loaded once, use ClientCredentials to pick up a rotated CA.
+func (info TLSInfo) ClientConfig() (*tls.Config, error) {
+	cfg, _, err := info.clientConfig()
+	return cfg, err
+}
+
+// ClientCredentials generates grpc transport credentials for clients of the watch server.
+// The trusted CA is reloaded for every new connection, and the server certificate is
+// verified against ServerName, or the host of the dialed endpoint (DNS name or IP) if empty.
+func (info TLSInfo) ClientCredentials() (credentials.TransportCredentials, error) {
+	cfg, r, err := info.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &clientCredentials{
+		TransportCredentials: credentials.NewTLS(cfg),
+		info:                 info,
+		cfg:                  cfg,
+		r:                    r,
+	}, nil
+}
+
+func (info TLSInfo) clientConfig() (*tls.Config, *reloader, error) {
+	if (info.CertFile == "") != (info.KeyFile == "") {
+		return nil, nil, errors.New("transport: client certificate requires both cert and key files")
+	}
+
+	r := &reloader{info: info}
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: info.ServerName,
+	}
+
+	if !info.Empty() {
+		if _, err := r.certificate(); err != nil {
+			return nil, nil, err
+		}
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		}
+	}
+
+	if info.TrustedCAFile != "" {
+		pool, err := r.caPool()
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, r, nil
+}
+
+// clientCredentials sets RootCAs to the current CA pool before every handshake,
+// the certificate chain and host name are verified by crypto/tls as usual.
+type clientCredentials struct {
+	credentials.TransportCredentials
+
+	info TLSInfo
+	cfg  *tls.Config
+	r    *reloader
+}
+
+func (c *clientCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	cfg := c.cfg.Clone()
+	if c.info.TrustedCAFile != "" {
+		pool, err := c.r.caPool()
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	// an empty ServerName is set to the host of authority by grpc
+	return credentials.NewTLS(cfg).ClientHandshake(ctx, authority, rawConn)
+}
+
+func (c *clientCredentials) Clone() credentials.TransportCredentials {
+	return &clientCredentials{
+		TransportCredentials: c.TransportCredentials.Clone(),
+		info:                 c.info,
+		cfg:                  c.cfg.Clone(),
+		r:                    c.r,
+	}
+}
+
+// OverrideServerName keeps the handshake config in sync with the embedded credentials.
+func (c *clientCredentials) OverrideServerName(serverName string) error {
+	c.cfg.ServerName = serverName
+	return c.TransportCredentials.OverrideServerName(serverName)
+}
+
+// reloader caches the certificate and CA pool of a TLSInfo, and loads them
+// again once the modification time of any of the files changes.
+type reloader struct {
+	info TLSInfo
+
+	mu sync.Mutex
+
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+
+	pool  *x509.CertPool
+	caMod time.Time
+}
+
+// certificate returns the current key pair. If the files cannot be loaded,
+// e.g. while the cert and key are being replaced, the previous one is kept.
+func (r *reloader) certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certMod, err1 := modTime(r.info.CertFile)
+	keyMod, err2 := modTime(r.info.KeyFile)
+	if r.cert != nil && err1 == nil && err2 == nil && certMod.Equal(r.certMod) && keyMod.Equal(r.keyMod) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.info.CertFile, r.info.KeyFile)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, err
+	}
+
+	r.cert, r.certMod, r.keyMod = &cert, certMod, keyMod
+	return r.cert, nil
+}
+
+// caPool returns the current trusted CA pool, keeping the previous one if
+// the file cannot be loaded.
+func (r *reloader) caPool() (*x509.CertPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	caMod, err := modTime(r.info.TrustedCAFile)
+	if r.pool != nil && err == nil && caMod.Equal(r.caMod) {
+		return r.pool, nil
+	}
+
+	pool, err := newCertPool(r.info.TrustedCAFile)
+	if err != nil {
+		if r.pool != nil {
+			return r.pool, nil
+		}
+		return nil, err
+	}
+
+	r.pool, r.caMod = pool, caMod
+	return r.pool, nil
+}
+
+func newCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("transport: no certificate found in %s", caFile)
+	}
+	return pool, nil
+}
+
+func modTime(file string) (time.Time, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
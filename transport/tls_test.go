@@ -0,0 +1,285 @@
+package transport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// writeCert writes a self-signed certificate with serial and its key to certFile and keyFile,
+// and sets their modification time to mod. The certificate is valid for hosts, or localhost if empty.
+func writeCert(t *testing.T, certFile, keyFile string, serial int64, mod time.Time, hosts ...string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) == 0 {
+		hosts = []string{"localhost"}
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: hosts[0]},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), mod)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), mod)
+}
+
+func writeFile(t *testing.T, file string, data []byte, mod time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func certSerial(t *testing.T, cert *tls.Certificate) int64 {
+	t.Helper()
+
+	c, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.SerialNumber.Int64()
+}
+
+func TestReloaderCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	r := &reloader{info: TLSInfo{CertFile: certFile, KeyFile: keyFile}}
+
+	if _, err := r.certificate(); err == nil {
+		t.Fatal("expected an error without any certificate")
+	}
+
+	mod := time.Now().Add(-time.Hour)
+	tests := []struct {
+		// update changes the files before loading
+		update func()
+		serial int64
+	}{
+		// loaded the first time
+		{func() { writeCert(t, certFile, keyFile, 1, mod) }, 1},
+		// unchanged files are not loaded again
+		{func() {}, 1},
+		// rewritten without changing the modification time
+		{func() { writeCert(t, certFile, keyFile, 2, mod) }, 1},
+		// rotated
+		{func() { writeCert(t, certFile, keyFile, 3, mod.Add(time.Minute)) }, 3},
+		// the previous certificate is kept while the files are invalid or missing
+		{func() { writeFile(t, certFile, []byte("invalid"), mod.Add(2*time.Minute)) }, 3},
+		{func() { os.Remove(keyFile) }, 3},
+		{func() { writeCert(t, certFile, keyFile, 4, mod.Add(3*time.Minute)) }, 4},
+	}
+
+	var prev *tls.Certificate
+	for i, tt := range tests {
+		tt.update()
+
+		cert, err := r.certificate()
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if serial := certSerial(t, cert); serial != tt.serial {
+			t.Errorf("#%d: expected serial %d, got %d", i, tt.serial, serial)
+		}
+		if prev != nil && certSerial(t, prev) == tt.serial && cert != prev {
+			t.Errorf("#%d: expected the cached certificate", i)
+		}
+		prev = cert
+	}
+}
+
+func TestReloaderCAPool(t *testing.T) {
+	dir := t.TempDir()
+	caFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	r := &reloader{info: TLSInfo{TrustedCAFile: caFile}}
+
+	if _, err := r.caPool(); err == nil {
+		t.Fatal("expected an error without any CA")
+	}
+
+	mod := time.Now().Add(-time.Hour)
+	tests := []struct {
+		update func()
+		reload bool
+	}{
+		{func() { writeCert(t, caFile, keyFile, 1, mod) }, true},
+		{func() {}, false},
+		{func() { writeCert(t, caFile, keyFile, 2, mod.Add(time.Minute)) }, true},
+		// the previous pool is kept while the file is invalid
+		{func() { writeFile(t, caFile, []byte("invalid"), mod.Add(2*time.Minute)) }, false},
+		{func() { os.Remove(caFile) }, false},
+	}
+
+	var prev *x509.CertPool
+	for i, tt := range tests {
+		tt.update()
+
+		pool, err := r.caPool()
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if reloaded := pool != prev; reloaded != tt.reload {
+			t.Errorf("#%d: expected reloaded %v, got %v", i, tt.reload, reloaded)
+		}
+		prev = pool
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, 1, time.Now())
+
+	tests := []struct {
+		info       TLSInfo
+		ok         bool
+		clientAuth tls.ClientAuthType
+	}{
+		{TLSInfo{}, false, tls.NoClientCert},
+		{TLSInfo{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.pem")}, false, tls.NoClientCert},
+		{TLSInfo{CertFile: certFile, KeyFile: keyFile, ClientCertAuth: true}, false, tls.NoClientCert},
+		{TLSInfo{CertFile: certFile, KeyFile: keyFile}, true, tls.NoClientCert},
+		{TLSInfo{CertFile: certFile, KeyFile: keyFile, TrustedCAFile: certFile}, true, tls.VerifyClientCertIfGiven},
+		{TLSInfo{CertFile: certFile, KeyFile: keyFile, TrustedCAFile: certFile, ClientCertAuth: true}, true, tls.RequireAndVerifyClientCert},
+	}
+
+	for i, tt := range tests {
+		cfg, err := tt.info.ServerConfig()
+		if (err == nil) != tt.ok {
+			t.Errorf("#%d: expected ok %v, got %v", i, tt.ok, err)
+			continue
+		}
+		if err == nil && cfg.ClientAuth != tt.clientAuth {
+			t.Errorf("#%d: expected client auth %v, got %v", i, tt.clientAuth, cfg.ClientAuth)
+		}
+	}
+}
+
+// handshake runs a TLS handshake of creds dialing authority against a server
+// presenting the certificate of certFile and keyFile.
+func handshake(t *testing.T, creds credentials.TransportCredentials, authority, certFile, keyFile string) error {
+	t.Helper()
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cconn, sconn := net.Pipe()
+	defer cconn.Close()
+	defer sconn.Close()
+
+	go tls.Server(sconn, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _, err = creds.ClientHandshake(ctx, authority, cconn)
+	return err
+}
+
+func TestClientCredentialsServerName(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	tests := []struct {
+		hosts      []string
+		serverName string
+		authority  string
+		ok         bool
+	}{
+		{[]string{"localhost"}, "", "localhost:2379", true},
+		{[]string{"localhost"}, "", "example.com:2379", false},
+		// an IP endpoint is verified against the IP SANs
+		{[]string{"127.0.0.1"}, "", "127.0.0.1:2379", true},
+		{[]string{"localhost"}, "", "127.0.0.1:2379", false},
+		{[]string{"10.0.0.1"}, "", "127.0.0.1:2379", false},
+		// ServerName overrides the host of the endpoint
+		{[]string{"localhost"}, "localhost", "127.0.0.1:2379", true},
+		{[]string{"127.0.0.1"}, "example.com", "127.0.0.1:2379", false},
+	}
+
+	for i, tt := range tests {
+		writeCert(t, certFile, keyFile, int64(i+1), time.Now().Add(time.Duration(i)*time.Minute), tt.hosts...)
+		creds, err := TLSInfo{TrustedCAFile: certFile, ServerName: tt.serverName}.ClientCredentials()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := handshake(t, creds, tt.authority, certFile, keyFile); (err == nil) != tt.ok {
+			t.Errorf("#%d: expected ok %v, got %v", i, tt.ok, err)
+		}
+	}
+}
+
+func TestClientCredentialsCARotation(t *testing.T) {
+	dir := t.TempDir()
+	caFile, caKeyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	mod := time.Now().Add(-time.Hour)
+	writeCert(t, caFile, caKeyFile, 1, mod)
+	writeCert(t, certFile, keyFile, 2, mod)
+
+	creds, err := TLSInfo{TrustedCAFile: caFile}.ClientCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := creds.Clone()
+
+	if err := handshake(t, creds, "localhost:2379", certFile, keyFile); err == nil {
+		t.Fatal("expected a certificate of an untrusted CA to be rejected")
+	}
+
+	// the self-signed server certificate becomes the trusted CA
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, caFile, data, mod.Add(time.Minute))
+
+	for i, c := range []credentials.TransportCredentials{creds, clone} {
+		if err := handshake(t, c, "localhost:2379", certFile, keyFile); err != nil {
+			t.Errorf("#%d: expected the rotated CA to be used, got %v", i, err)
+		}
+	}
+}
@@ -2,13 +2,20 @@ package watchclient
 
 import (
 	"context"
+	"io"
+	"time"
 
 	"github.com/xkeyideal/grpcwatch/grpclient"
 	pb "github.com/xkeyideal/grpcwatch/watchpb"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// 心跳中断后重新注册的等待时间
+var reregisterInterval = time.Second
+
 type AppServer struct {
 	remote   pb.WatchRPCClient
 	callOpts []grpc.CallOption
@@ -27,5 +34,102 @@ func NewAppServer(c *grpclient.GrpcClient) *AppServer {
 }
 
 func (s *AppServer) GetAppServers(app *pb.App) (*pb.GetAppResponse, error) {
-	return s.remote.GetAppServers(context.Background(), app, s.callOpts...)
+	return s.getAppServers(context.Background(), app)
+}
+
+func (s *AppServer) getAppServers(ctx context.Context, app *pb.App) (*pb.GetAppResponse, error) {
+	return s.remote.GetAppServers(ctx, app, s.callOpts...)
+}
+
+// Register 注册app的服务器地址，ttl为心跳超时时间，单位秒，为0时采用服务端的默认值
+// 返回服务端实际采用的ttl
+func (s *AppServer) Register(ctx context.Context, app *pb.App, server *pb.AppServer, ttl int64) (int64, error) {
+	resp, err := s.remote.Register(ctx, &pb.RegisterRequest{
+		App:    app,
+		Server: server,
+		Ttl:    ttl,
+	}, s.callOpts...)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Ttl, nil
+}
+
+// Deregister 注销app的服务器地址
+func (s *AppServer) Deregister(ctx context.Context, app *pb.App, server *pb.AppServer) error {
+	_, err := s.remote.Deregister(ctx, &pb.DeregisterRequest{
+		App:    app,
+		Server: server,
+	}, s.callOpts...)
+	return err
+}
+
+// KeepAlive 注册app的服务器地址，并按照ttl/3的间隔持续发送心跳，直到ctx结束
+// 心跳中断或者服务器地址已被服务端剔除时，会自动重新注册
+// 该方法会一直阻塞，调用方通常在单独的goroutine中调用，退出后需自行调用Deregister
+func (s *AppServer) KeepAlive(ctx context.Context, app *pb.App, server *pb.AppServer, ttl int64) error {
+	for {
+		err := s.keepAlive(ctx, app, server, ttl)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// 服务器地址被剔除时重新注册，其余非网络错误直接返回
+		if status.Code(err) != codes.NotFound && isHaltErr(ctx, err) {
+			return err
+		}
+
+		select {
+		case <-time.After(reregisterInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *AppServer) keepAlive(ctx context.Context, app *pb.App, server *pb.AppServer, ttl int64) error {
+	ttl, err := s.Register(ctx, app, server, ttl)
+	if err != nil {
+		return err
+	}
+
+	// 服务端不会剔除该服务器地址，无需心跳
+	if ttl <= 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	hctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := s.remote.Heartbeat(hctx, s.callOpts...)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(time.Duration(ttl) * time.Second / 3)
+	defer ticker.Stop()
+
+	req := &pb.HeartbeatRequest{
+		App:    app,
+		Server: server,
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := stream.Send(req); err != nil {
+				// Send在stream中断时返回io.EOF，真实的错误需要通过Recv获取
+				if err == io.EOF {
+					_, err = stream.Recv()
+				}
+				return err
+			}
+			if _, err := stream.Recv(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 }
@@ -0,0 +1,75 @@
+package watchclient
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff 记录一次断线重连过程中的回退状态，重连成功后丢弃
+type backoff struct {
+	policy BackoffPolicy
+
+	// 下一次等待的时间（不含jitter）
+	wait time.Duration
+
+	// 已经开始的重连次数，建立的stream失败（Recv错误）本身不计入
+	attempts int
+
+	// 第一次失败的时间
+	start time.Time
+}
+
+func newBackoff(policy *BackoffPolicy) *backoff {
+	p := DefaultBackoffPolicy
+	if policy != nil {
+		p = *policy
+		if p.Initial <= 0 {
+			p.Initial = DefaultBackoffPolicy.Initial
+		}
+		if p.Multiplier < 1 {
+			p.Multiplier = DefaultBackoffPolicy.Multiplier
+		}
+		if p.Max <= 0 {
+			p.Max = DefaultBackoffPolicy.Max
+		}
+		if p.Max < p.Initial {
+			p.Max = p.Initial
+		}
+	}
+
+	return &backoff{
+		policy: p,
+		wait:   p.Initial,
+		start:  time.Now(),
+	}
+}
+
+// next 记录一次失败并返回下一次重连前需要等待的时间，已经重连MaxAttempts次或者超过MaxElapsed时返回false
+func (b *backoff) next() (time.Duration, bool) {
+	b.attempts++
+	if b.policy.MaxAttempts > 0 && b.attempts > b.policy.MaxAttempts {
+		return 0, false
+	}
+
+	d := jitter(b.wait, b.policy.Jitter)
+	if b.policy.MaxElapsed > 0 && time.Since(b.start)+d > b.policy.MaxElapsed {
+		return 0, false
+	}
+
+	b.wait = time.Duration(float64(b.wait) * b.policy.Multiplier)
+	if b.wait > b.policy.Max {
+		b.wait = b.policy.Max
+	}
+	return d, true
+}
+
+// jitter 在d的基础上随机增减fraction比例
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return d
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	return time.Duration(float64(d) * (1 + fraction*(rand.Float64()*2-1)))
+}
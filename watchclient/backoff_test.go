@@ -0,0 +1,105 @@
+package watchclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffNext(t *testing.T) {
+	tests := []struct {
+		policy *BackoffPolicy
+
+		// waits expected before every reconnect, without jitter
+		waits []time.Duration
+
+		// whether the reconnect after waits is given up
+		giveUp bool
+	}{
+		{
+			nil,
+			[]time.Duration{time.Millisecond, 1250 * time.Microsecond, 1562500 * time.Nanosecond},
+			false,
+		},
+		{
+			&BackoffPolicy{Initial: 100 * time.Millisecond, Multiplier: 2, Max: time.Second},
+			[]time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second},
+			false,
+		},
+		// zero fields take the defaults
+		{
+			&BackoffPolicy{},
+			[]time.Duration{time.Millisecond, 1250 * time.Microsecond},
+			false,
+		},
+		// Max is never below Initial
+		{
+			&BackoffPolicy{Initial: time.Second, Multiplier: 2, Max: time.Millisecond},
+			[]time.Duration{time.Second, time.Second},
+			false,
+		},
+		// MaxAttempts counts reconnects only, 1 allows one reconnect
+		{
+			&BackoffPolicy{Initial: time.Millisecond, MaxAttempts: 1},
+			[]time.Duration{time.Millisecond},
+			true,
+		},
+		{
+			&BackoffPolicy{Initial: time.Millisecond, Multiplier: 2, MaxAttempts: 3},
+			[]time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond},
+			true,
+		},
+		// the next wait would exceed MaxElapsed
+		{
+			&BackoffPolicy{Initial: time.Second, MaxElapsed: 1500 * time.Millisecond},
+			[]time.Duration{time.Second},
+			true,
+		},
+	}
+
+	for i, tt := range tests {
+		b := newBackoff(tt.policy)
+		b.policy.Jitter = 0
+
+		for j, expected := range tt.waits {
+			wait, ok := b.next()
+			if !ok {
+				t.Fatalf("#%d: expected reconnect %d not to be given up", i, j+1)
+			}
+			if wait != expected {
+				t.Errorf("#%d: expected reconnect %d to wait %v, got %v", i, j+1, expected, wait)
+			}
+			if b.attempts != j+1 {
+				t.Errorf("#%d: expected %d attempts, got %d", i, j+1, b.attempts)
+			}
+
+			// as if the reconnect waited and failed
+			b.start = b.start.Add(-wait)
+		}
+
+		if _, ok := b.next(); ok == tt.giveUp {
+			t.Errorf("#%d: expected give up %v after %d reconnects", i, tt.giveUp, len(tt.waits))
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	tests := []struct {
+		d        time.Duration
+		fraction float64
+		min, max time.Duration
+	}{
+		{time.Second, 0, time.Second, time.Second},
+		{time.Second, -1, time.Second, time.Second},
+		{time.Second, 0.2, 800 * time.Millisecond, 1200 * time.Millisecond},
+		// the fraction is capped at 1
+		{time.Second, 2, 0, 2 * time.Second},
+	}
+
+	for i, tt := range tests {
+		for j := 0; j < 100; j++ {
+			if d := jitter(tt.d, tt.fraction); d < tt.min || d > tt.max {
+				t.Fatalf("#%d: expected a duration within [%v, %v], got %v", i, tt.min, tt.max, d)
+			}
+		}
+	}
+}
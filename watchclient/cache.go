@@ -0,0 +1,299 @@
+package watchclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xkeyideal/grpcwatch/grpclient"
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+)
+
+// watch的channel关闭后（例如服务端返回非网络错误）重新watch的等待时间
+var rewatchInterval = time.Second
+
+// ChangeHandler app的服务器地址列表发生变化时的回调，servers为变化后完整的列表，不可修改
+type ChangeHandler func(app *pb.App, servers []*pb.AppServer)
+
+// Cache 本地缓存app的服务器地址列表，先通过GetAppServers获取，再通过watch保持与服务端同步，
+// 调用方通过Get读取本地缓存，无需每次请求服务端
+type Cache struct {
+	appServer *AppServer
+	watcher   *Watcher
+
+	opts []WatchOption
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu protects entries, handlers and pending
+	mu sync.RWMutex
+
+	entries map[appKey]*cacheEntry
+
+	handlers []ChangeHandler
+
+	// 尚未收到第一次服务器地址列表的初始app个数, 为0时关闭readyc
+	pending int
+	readyc  chan struct{}
+
+	// 本地快照文件路径与启动时加载的快照
+	snapshotFile string
+	snapshots    map[appKey]*pb.GetAppResponse
+
+	// persistc 通知persistLoop写入本地快照
+	persistc chan struct{}
+
+	wg sync.WaitGroup
+
+	lg *zap.Logger
+}
+
+type cacheEntry struct {
+	servers []*pb.AppServer
+
+	// 服务器地址列表对应的revision
+	revision int64
+
+	// 已经收到第一次服务器地址列表
+	ready bool
+
+	// 是否计入Ready的等待
+	initial bool
+
+	// 列表来自本地快照，尚未收到服务端的数据
+	stale bool
+
+	// notifyMu 调用回调时持有，保证同一个app的回调按照变化的顺序依次调用
+	notifyMu sync.Mutex
+}
+
+func NewCache(cfg *CacheConfig, c *grpclient.GrpcClient) *Cache {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	watcher := NewWatcherWithConfig(&cfg.WatcherConfig, c)
+	cache := &Cache{
+		appServer: NewAppServer(c),
+		watcher:   watcher,
+		ctx:       ctx,
+		cancel:    cancel,
+		entries:   make(map[appKey]*cacheEntry),
+		readyc:    make(chan struct{}),
+		lg:        watcher.lg,
+	}
+
+	if cfg.Delta {
+		cache.opts = append(cache.opts, WithDelta(0))
+	}
+
+	if cfg.SnapshotFile != "" {
+		snapshots, err := loadSnapshot(cfg.SnapshotFile)
+		if err != nil {
+			cache.lg.Warn("cache load snapshot", zap.String("file", cfg.SnapshotFile), zap.Error(err))
+		}
+		cache.snapshotFile = cfg.SnapshotFile
+		cache.snapshots = snapshots
+		cache.persistc = make(chan struct{}, 1)
+
+		cache.wg.Add(1)
+		go cache.persistLoop()
+	}
+
+	cache.mu.Lock()
+	for _, app := range cfg.Apps {
+		if cache.add(app) {
+			e := cache.entries[appKey{name: app.Name, env: app.Env}]
+			e.initial = true
+			if !e.ready {
+				cache.pending++
+			}
+		}
+	}
+	if cache.pending == 0 {
+		close(cache.readyc)
+	}
+	cache.mu.Unlock()
+
+	return cache
+}
+
+// Ready 初始的app都收到第一次服务器地址列表后关闭
+func (c *Cache) Ready() <-chan struct{} {
+	return c.readyc
+}
+
+// Add 添加需要缓存的app，已经存在时忽略
+func (c *Cache) Add(app *pb.App) {
+	c.mu.Lock()
+	c.add(app)
+	c.mu.Unlock()
+}
+
+// add 必须在持有mu的情况下调用，返回是否新增
+func (c *Cache) add(app *pb.App) bool {
+	key := appKey{name: app.Name, env: app.Env}
+	if _, ok := c.entries[key]; ok || c.ctx.Err() != nil {
+		return false
+	}
+	e := &cacheEntry{}
+	if snap, ok := c.snapshots[key]; ok {
+		e.servers = snap.Servers
+		e.revision = snap.Revision
+		e.ready = true
+		e.stale = true
+	}
+	c.entries[key] = e
+
+	c.wg.Add(1)
+	go c.run(proto.Clone(app).(*pb.App), key)
+
+	return true
+}
+
+// Get 返回app当前的服务器地址列表与对应的revision，app未缓存或者尚未收到第一次列表时ok为false
+// 返回的列表不可修改，列表可能来自本地快照，通过Stale判断
+func (c *Cache) Get(app *pb.App) (servers []*pb.AppServer, revision int64, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, exist := c.entries[appKey{name: app.Name, env: app.Env}]
+	if !exist || !e.ready {
+		return nil, 0, false
+	}
+	return e.servers, e.revision, true
+}
+
+// Stale 返回app的服务器地址列表是否来自本地快照，尚未收到服务端的数据
+func (c *Cache) Stale(app *pb.App) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.entries[appKey{name: app.Name, env: app.Env}]
+	return ok && e.stale
+}
+
+// OnChange 注册服务器地址列表变化的回调，包括第一次收到列表，
+// 注册时已经有列表（包括来自本地快照的列表）的app立即回调一次，因此可能重复收到相同的列表，
+// 同一个app的回调按照变化的顺序依次调用，不同app的回调可能并发调用
+func (c *Cache) OnChange(handler ChangeHandler) {
+	c.mu.Lock()
+	c.handlers = append(c.handlers, handler)
+	entries := make(map[appKey]*cacheEntry, len(c.entries))
+	for key, e := range c.entries {
+		entries[key] = e
+	}
+	c.mu.Unlock()
+
+	for key, e := range entries {
+		e.notifyMu.Lock()
+		c.mu.RLock()
+		servers, ready := e.servers, e.ready
+		c.mu.RUnlock()
+		if ready {
+			handler(&pb.App{Name: key.name, Env: key.env}, servers)
+		}
+		e.notifyMu.Unlock()
+	}
+}
+
+// Close 停止所有的watch，之后Get返回最后一次缓存的列表
+func (c *Cache) Close() {
+	c.cancel()
+	c.watcher.Close()
+	c.wg.Wait()
+}
+
+// run 先获取app当前的服务器地址列表，再通过watch持续更新，直到Close
+func (c *Cache) run(app *pb.App, key appKey) {
+	defer c.wg.Done()
+
+	// 来自本地快照的列表同样通知回调
+	c.mu.RLock()
+	e := c.entries[key]
+	servers, stale, handlers := e.servers, e.stale, c.handlers
+	c.mu.RUnlock()
+	if stale {
+		c.notify(e, app, handlers, servers)
+	}
+
+	// GetAppServers失败时直接watch, created响应同样携带完整的服务器地址列表
+	resp, err := c.appServer.getAppServers(c.ctx, app)
+	if err != nil {
+		c.lg.Warn("cache get app servers", zap.Any("app", app), zap.Error(err))
+	} else {
+		c.update(app, key, resp.Servers, resp.Revision)
+	}
+
+	watchID := fmt.Sprintf("cache/%s/%s", app.Env, app.Name)
+	for {
+		for resp := range c.watcher.Watch(c.ctx, watchID, app, c.opts...) {
+			if resp.Canceled {
+				c.lg.Warn("cache watch canceled", zap.Any("app", app), zap.String("reason", resp.CancelReason))
+				continue
+			}
+			c.update(app, key, resp.Servers, resp.Revision)
+		}
+
+		select {
+		case <-time.After(rewatchInterval):
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Cache) update(app *pb.App, key appKey, servers []*pb.AppServer, revision int64) {
+	c.mu.Lock()
+	e := c.entries[key]
+	changed := !e.ready || !serversEqual(e.servers, servers)
+	if e.initial && !e.ready {
+		c.pending--
+		if c.pending == 0 {
+			close(c.readyc)
+		}
+	}
+	e.servers = servers
+	e.revision = revision
+	e.ready = true
+	e.stale = false
+	handlers := c.handlers
+	c.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if c.persistc != nil {
+		select {
+		case c.persistc <- struct{}{}:
+		default:
+		}
+	}
+
+	c.notify(e, app, handlers, servers)
+}
+
+func (c *Cache) notify(e *cacheEntry, app *pb.App, handlers []ChangeHandler, servers []*pb.AppServer) {
+	e.notifyMu.Lock()
+	defer e.notifyMu.Unlock()
+
+	for _, handler := range handlers {
+		handler(app, servers)
+	}
+}
+
+func serversEqual(a, b []*pb.AppServer) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !proto.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package watchclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"go.uber.org/zap"
+)
+
+// 本地快照文件的格式版本，格式不兼容时递增，读取到其他版本的快照时忽略
+const cacheSnapshotVersion = 1
+
+// cacheSnapshot 本地快照文件的内容
+type cacheSnapshot struct {
+	Version int `json:"version"`
+
+	Apps []*pb.GetAppResponse `json:"apps"`
+}
+
+// loadSnapshot 读取本地快照，文件不存在时返回空
+func loadSnapshot(filename string) (map[appKey]*pb.GetAppResponse, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	snap := &cacheSnapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, err
+	}
+	if snap.Version != cacheSnapshotVersion {
+		return nil, fmt.Errorf("watchclient: unsupported cache snapshot version %d", snap.Version)
+	}
+
+	apps := make(map[appKey]*pb.GetAppResponse, len(snap.Apps))
+	for _, resp := range snap.Apps {
+		if resp.GetApp() == nil {
+			continue
+		}
+		apps[appKey{name: resp.App.Name, env: resp.App.Env}] = resp
+	}
+	return apps, nil
+}
+
+// writeSnapshot 先写入同目录下的临时文件再rename, 保证快照文件不会因为进程退出而写坏
+func writeSnapshot(filename string, apps []*pb.GetAppResponse) error {
+	data, err := json.Marshal(&cacheSnapshot{
+		Version: cacheSnapshotVersion,
+		Apps:    apps,
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// persistLoop 服务器地址列表变化后写入本地快照，多次变化合并为一次写入
+func (c *Cache) persistLoop() {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-c.persistc:
+			c.persist()
+		case <-c.ctx.Done():
+			select {
+			case <-c.persistc:
+				c.persist()
+			default:
+			}
+			return
+		}
+	}
+}
+
+func (c *Cache) persist() {
+	c.mu.RLock()
+	apps := make([]*pb.GetAppResponse, 0, len(c.entries))
+	for key, e := range c.entries {
+		if !e.ready {
+			continue
+		}
+		apps = append(apps, &pb.GetAppResponse{
+			App:      &pb.App{Name: key.name, Env: key.env},
+			Servers:  e.servers,
+			Revision: e.revision,
+		})
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(apps, func(i, j int) bool {
+		if apps[i].App.Env != apps[j].App.Env {
+			return apps[i].App.Env < apps[j].App.Env
+		}
+		return apps[i].App.Name < apps[j].App.Name
+	})
+
+	if err := writeSnapshot(c.snapshotFile, apps); err != nil {
+		c.lg.Warn("cache write snapshot", zap.String("file", c.snapshotFile), zap.Error(err))
+	}
+}
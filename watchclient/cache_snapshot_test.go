@@ -0,0 +1,127 @@
+package watchclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+)
+
+func TestLoadSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+
+	tests := []struct {
+		filename string
+
+		apps []string
+		err  bool
+	}{
+		// a missing snapshot is empty
+		{filepath.Join(dir, "missing"), nil, false},
+		{write("v1", `{"version":1,"apps":[{"app":{"name":"svc","env":"qa"},"revision":3},{"revision":4}]}`), []string{"svc"}, false},
+		{write("v2", `{"version":2,"apps":[{"app":{"name":"svc","env":"qa"},"revision":3}]}`), nil, true},
+		{write("v0", `{"apps":[{"app":{"name":"svc","env":"qa"},"revision":3}]}`), nil, true},
+		{write("corrupted", `{"version":1,"apps":[`), nil, true},
+	}
+
+	for i, tt := range tests {
+		snapshots, err := loadSnapshot(tt.filename)
+		if (err != nil) != tt.err {
+			t.Errorf("#%d: expected error %v, got %v", i, tt.err, err)
+		}
+
+		var apps []string
+		for key := range snapshots {
+			apps = append(apps, key.name)
+		}
+		if !reflect.DeepEqual(apps, tt.apps) {
+			t.Errorf("#%d: expected apps %v, got %v", i, tt.apps, apps)
+		}
+	}
+}
+
+func TestWriteSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "cache.snapshot")
+	app := &pb.App{Name: "svc", Env: "qa"}
+
+	for rev := int64(1); rev <= 2; rev++ {
+		if err := writeSnapshot(filename, []*pb.GetAppResponse{{App: app, Revision: rev}}); err != nil {
+			t.Fatal(err)
+		}
+		snapshots, err := loadSnapshot(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snap := snapshots[appKey{name: app.Name, env: app.Env}]; snap.GetRevision() != rev {
+			t.Errorf("expected revision %d, got %v", rev, snap)
+		}
+	}
+
+	// a failed write leaves neither the temporary file nor a partial snapshot behind
+	if err := os.Mkdir(filepath.Join(dir, "dir.snapshot"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSnapshot(filepath.Join(dir, "dir.snapshot"), nil); err == nil {
+		t.Error("expected an error writing over a directory")
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if expected := []string{"cache.snapshot", "dir.snapshot"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected files %v, got %v", expected, names)
+	}
+}
+
+func TestCachePersist(t *testing.T) {
+	endpoint, registry := newTestServer(t)
+	app := &pb.App{Name: "svc", Env: "qa"}
+	if err := registry.Register(app, &pb.AppServer{Ip: "10.0.0.1", Port: "80"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "cache.snapshot")
+	c := newTestCache(t, &CacheConfig{Apps: []*pb.App{app}, SnapshotFile: filename}, []string{endpoint})
+	<-c.Ready()
+
+	if err := registry.Register(app, &pb.AppServer{Ip: "10.0.0.2", Port: "80"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot follows the changes of the list
+	expected := []string{"10.0.0.1", "10.0.0.2"}
+	for i := 0; ; i++ {
+		snapshots, err := loadSnapshot(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snap := snapshots[appKey{name: app.Name, env: app.Env}]
+		if reflect.DeepEqual(serverIPs(snap.GetServers()), expected) {
+			if snap.Revision != registry.Revision() {
+				t.Errorf("expected revision %d, got %d", registry.Revision(), snap.Revision)
+			}
+			break
+		}
+		if i == 200 {
+			t.Fatalf("expected the snapshot of %v, got %v", expected, snap)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,192 @@
+package watchclient
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xkeyideal/grpcwatch/grpclient"
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+	"github.com/xkeyideal/grpcwatch/watchserver"
+
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+)
+
+// newTestServer serves a watch server of a memory registry, it returns the endpoint and the registry.
+func newTestServer(t *testing.T) (string, watchserver.Registry) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := watchserver.NewMemoryRegistry()
+	server := grpc.NewServer()
+	pb.RegisterWatchRPCServer(server, watchserver.NewWatchRpcServer(&watchserver.GrpcServerConfig{}, zap.NewNop(), registry))
+	go server.Serve(l)
+
+	t.Cleanup(func() {
+		server.Stop()
+		registry.Close()
+	})
+	return l.Addr().String(), registry
+}
+
+// newTestCache creates a Cache of a client of endpoints, its logs are discarded.
+func newTestCache(t *testing.T, cfg *CacheConfig, endpoints []string) *Cache {
+	t.Helper()
+
+	// without a blocking dial, the client is created while the servers are down
+	cli, err := grpclient.NewGRPCClient(&grpclient.GrpcClientConfig{Endpoints: endpoints})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+
+	cfg.LogFilename = filepath.Join(t.TempDir(), "cache.log")
+	cfg.LogLevel = zapcore.FatalLevel
+	c := NewCache(cfg, cli)
+	t.Cleanup(c.Close)
+	return c
+}
+
+type changeEvent struct {
+	app     *pb.App
+	servers []*pb.AppServer
+}
+
+func recvChange(t *testing.T, ch <-chan changeEvent) changeEvent {
+	t.Helper()
+
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a change")
+	}
+	return changeEvent{}
+}
+
+func TestCacheSnapshotServerDown(t *testing.T) {
+	a, b := &pb.App{Name: "svc-a", Env: "qa"}, &pb.App{Name: "svc-b", Env: "qa"}
+	snapshots := []*pb.GetAppResponse{
+		{App: a, Servers: []*pb.AppServer{{Ip: "10.0.0.1", Port: "80"}}, Revision: 10},
+		{App: b, Servers: []*pb.AppServer{{Ip: "10.0.0.2", Port: "80"}}, Revision: 11},
+	}
+	filename := filepath.Join(t.TempDir(), "cache.snapshot")
+	if err := writeSnapshot(filename, snapshots); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing listens on the endpoint
+	c := newTestCache(t, &CacheConfig{
+		WatcherConfig: WatcherConfig{Backoff: &BackoffPolicy{Initial: 10 * time.Millisecond}},
+		Apps:          []*pb.App{a},
+		SnapshotFile:  filename,
+	}, []string{"127.0.0.1:1"})
+
+	select {
+	case <-c.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the cache to be ready with the snapshot")
+	}
+	servers, rev, ok := c.Get(a)
+	if !ok || rev != 10 || !serversEqual(servers, snapshots[0].Servers) || !c.Stale(a) {
+		t.Errorf("expected the stale snapshot of %v, got %v %d %v", a, servers, rev, ok)
+	}
+
+	// the handlers are notified of the lists loaded from the snapshot
+	changes := make(chan changeEvent, 4)
+	c.OnChange(func(app *pb.App, servers []*pb.AppServer) {
+		changes <- changeEvent{app, servers}
+	})
+	c.Add(b)
+
+	// the handler may be notified of svc-a twice if its watch starts after OnChange
+	notified := make(map[string]bool)
+	for len(notified) < len(snapshots) {
+		e := recvChange(t, changes)
+		for _, snap := range snapshots {
+			if proto.Equal(e.app, snap.App) && !serversEqual(e.servers, snap.Servers) {
+				t.Errorf("expected the snapshot of %v, got %v", snap.App, e.servers)
+			}
+		}
+		notified[e.app.Name] = true
+	}
+}
+
+func serverIPs(servers []*pb.AppServer) []string {
+	ips := []string{}
+	for _, s := range servers {
+		ips = append(ips, s.Ip)
+	}
+	return ips
+}
+
+func TestCacheSync(t *testing.T) {
+	tests := []struct {
+		delta bool
+	}{
+		{false},
+		{true},
+	}
+
+	app := &pb.App{Name: "svc", Env: "qa"}
+	server := func(ip string) *pb.AppServer { return &pb.AppServer{Ip: ip, Port: "80"} }
+
+	for i, tt := range tests {
+		endpoint, registry := newTestServer(t)
+		if err := registry.Register(app, server("10.0.0.1"), 0); err != nil {
+			t.Fatal(err)
+		}
+
+		c := newTestCache(t, &CacheConfig{Apps: []*pb.App{app}, Delta: tt.delta}, []string{endpoint})
+		select {
+		case <-c.Ready():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("#%d: expected the cache to be ready", i)
+		}
+
+		changes := make(chan changeEvent, 16)
+		c.OnChange(func(app *pb.App, servers []*pb.AppServer) {
+			changes <- changeEvent{app, servers}
+		})
+		if e := recvChange(t, changes); !reflect.DeepEqual(serverIPs(e.servers), []string{"10.0.0.1"}) {
+			t.Fatalf("#%d: expected the initial list, got %v", i, serverIPs(e.servers))
+		}
+
+		steps := []struct {
+			op      func() error
+			servers []string
+		}{
+			{func() error { return registry.Register(app, server("10.0.0.2"), 0) }, []string{"10.0.0.1", "10.0.0.2"}},
+			{func() error { return registry.Deregister(app, server("10.0.0.1")) }, []string{"10.0.0.2"}},
+			{func() error { return registry.Deregister(app, server("10.0.0.2")) }, []string{}},
+			{func() error { return registry.Register(app, server("10.0.0.3"), 0) }, []string{"10.0.0.3"}},
+		}
+
+		for j, step := range steps {
+			if err := step.op(); err != nil {
+				t.Fatal(err)
+			}
+			e := recvChange(t, changes)
+			if !proto.Equal(e.app, app) || !reflect.DeepEqual(serverIPs(e.servers), step.servers) {
+				t.Errorf("#%d.%d: expected %v, got %v %v", i, j, step.servers, e.app, serverIPs(e.servers))
+			}
+
+			servers, rev, ok := c.Get(app)
+			if !ok || c.Stale(app) || !reflect.DeepEqual(serverIPs(servers), step.servers) {
+				t.Errorf("#%d.%d: expected to get %v, got %v %v", i, j, step.servers, serverIPs(servers), ok)
+			}
+			if rev != registry.Revision() {
+				t.Errorf("#%d.%d: expected revision %d, got %d", i, j, registry.Revision(), rev)
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package watchclient
+
+import (
+	"time"
+
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type WatcherConfig struct {
+	// LogFilename 日志文件路径
+	LogFilename string
+
+	// LogLevel 日志级别
+	LogLevel zapcore.Level
+
+	// ProgressNotifyTimeout 超过该时间未收到服务端的任何响应（包括progress notify），
+	// 则认为gRPC stream已失效，触发断线重连。需要大于服务端的ProgressNotifyInterval，为0时不检测
+	ProgressNotifyTimeout time.Duration
+
+	// Backoff gRPC stream断线重连的回退策略，为nil时使用DefaultBackoffPolicy
+	Backoff *BackoffPolicy
+}
+
+// BackoffPolicy 断线重连的回退策略，每次重连失败后等待的时间从Initial开始按照Multiplier增长，不超过Max，
+// 并在此基础上随机增减Jitter比例，避免服务端重启后大量客户端同时重连
+type BackoffPolicy struct {
+	// Initial 第一次重连失败后等待的时间，为0时使用DefaultBackoffPolicy.Initial
+	Initial time.Duration
+
+	// Multiplier 每次重连失败后等待时间的增长倍数，小于1时使用DefaultBackoffPolicy.Multiplier
+	Multiplier float64
+
+	// Max 等待时间的上限（不含jitter），为0时使用DefaultBackoffPolicy.Max
+	Max time.Duration
+
+	// Jitter 等待时间随机增减的比例，取值[0, 1]，例如0.2表示在[0.8, 1.2]倍之间随机，为0时不增加随机
+	Jitter float64
+
+	// MaxAttempts 连续重连的次数上限，重连MaxAttempts次仍失败后放弃重连，所有watch（包括阻塞在Watch中的）收到canceled响应，
+	// 再次调用Watch时重新连接，为0时不限制
+	MaxAttempts int
+
+	// MaxElapsed 连续重连失败的总时长上限，超过后放弃重连，为0时不限制
+	MaxElapsed time.Duration
+}
+
+// DefaultBackoffPolicy 默认的断线重连回退策略，不限制重连次数与时长
+var DefaultBackoffPolicy = BackoffPolicy{
+	Initial:    time.Millisecond,
+	Multiplier: 1.25,
+	Max:        2 * time.Second,
+	Jitter:     0.2,
+}
+
+type CacheConfig struct {
+	// Cache内部Watcher的配置
+	WatcherConfig
+
+	// Apps 需要缓存的app，Ready等待这些app都收到第一次的服务器地址列表，之后也可以通过Cache.Add添加
+	Apps []*pb.App
+
+	// Delta 是否采用增量模式watch，适用于服务器数量较多的app
+	Delta bool
+
+	// SnapshotFile 本地快照文件路径，为空时不使用本地快照。
+	// 服务器地址列表变化后写入该文件，启动时先加载该文件中的列表并标记为stale, 直到收到服务端的数据，
+	// 避免服务端不可用时启动的服务没有任何服务器地址。
+	// 此时GrpcClientConfig.DialTimeout需要为0（不阻塞等待连接建立），否则服务端不可用时NewGRPCClient直接返回错误，无法创建Cache
+	SnapshotFile string
+}
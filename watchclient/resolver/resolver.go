@@ -0,0 +1,122 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xkeyideal/grpcwatch/grpclient/balancer/picker"
+	"github.com/xkeyideal/grpcwatch/watchclient"
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme grpcwatch resolver的scheme, target为grpcwatch://<env>/<app>
+const Scheme = "grpcwatch"
+
+// watch的channel关闭后（例如服务端返回非网络错误）重新watch的等待时间
+var rewatchInterval = time.Second
+
+// Register 向grpc注册grpcwatch resolver, app的服务器地址通过w watch获取，
+// 与resolver.Register一样必须在初始化时调用，之后可以通过grpc.Dial(Target(app))访问app，
+// 配合grpc.WithDefaultServiceConfig(grpclient.BalancerServiceConfig(name))在所有服务器地址之间负载均衡
+func Register(w *watchclient.Watcher) {
+	resolver.Register(&builder{watcher: w})
+}
+
+// Target 返回app对应的grpcwatch target
+func Target(app *pb.App) string {
+	return fmt.Sprintf("%s://%s/%s", Scheme, app.Env, app.Name)
+}
+
+type builder struct {
+	watcher *watchclient.Watcher
+
+	// 同一个app可能被多次Dial, 用于生成不重复的watchID
+	seq int64
+}
+
+// Build 为每个grpc.ClientConn创建一个watch, target的authority为env, endpoint为app名称
+func (b *builder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	env, name := target.URL.Host, target.Endpoint()
+	if env == "" || name == "" {
+		return nil, fmt.Errorf("malformed target, expected %s://<env>/<app>, but got %s", Scheme, target.URL.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Resolver{
+		watcher: b.watcher,
+		app:     &pb.App{Name: name, Env: env},
+		watchID: fmt.Sprintf("resolver/%s/%s/%d", env, name, atomic.AddInt64(&b.seq, 1)),
+		cc:      cc,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	r.wg.Add(1)
+	go r.watch()
+
+	return r, nil
+}
+
+func (b *builder) Scheme() string {
+	return Scheme
+}
+
+// Resolver 通过watch将app服务器地址的变化同步给grpc.ClientConn
+type Resolver struct {
+	watcher *watchclient.Watcher
+	app     *pb.App
+	watchID string
+
+	cc resolver.ClientConn
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (r *Resolver) watch() {
+	defer r.wg.Done()
+
+	for {
+		for resp := range r.watcher.Watch(r.ctx, r.watchID, r.app) {
+			if resp.Canceled {
+				grpclog.Warningf("grpcwatch resolver: watch %s canceled: %s", r.watchID, resp.CancelReason)
+				continue
+			}
+			if err := r.cc.UpdateState(resolver.State{Addresses: serversToAddrs(resp.Servers)}); err != nil {
+				grpclog.Warningf("grpcwatch resolver: watch %s update state: %v", r.watchID, err)
+			}
+		}
+
+		select {
+		case <-time.After(rewatchInterval):
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// serversToAddrs 服务器的权重、可用区等信息通过picker.AddrMetadata传递给负载均衡
+func serversToAddrs(servers []*pb.AppServer) []resolver.Address {
+	addrs := make([]resolver.Address, 0, len(servers))
+	for _, server := range servers {
+		addr := resolver.Address{Addr: net.JoinHostPort(server.Ip, server.Port)}
+		addrs = append(addrs, picker.SetAddrMetadata(addr, picker.AddrMetadata{Weight: server.Weight, Zone: server.Zone}))
+	}
+	return addrs
+}
+
+// ResolveNow watch会实时推送变化，无需处理
+func (*Resolver) ResolveNow(o resolver.ResolveNowOptions) {}
+
+func (r *Resolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
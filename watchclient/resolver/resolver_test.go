@@ -0,0 +1,159 @@
+package resolver
+
+import (
+	"net"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xkeyideal/grpcwatch/grpclient"
+	"github.com/xkeyideal/grpcwatch/grpclient/balancer/picker"
+	"github.com/xkeyideal/grpcwatch/watchclient"
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+	"github.com/xkeyideal/grpcwatch/watchserver"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+)
+
+// testClientConn records the states updated by a resolver.
+type testClientConn struct {
+	resolver.ClientConn
+
+	statec chan resolver.State
+}
+
+func (cc *testClientConn) UpdateState(state resolver.State) error {
+	cc.statec <- state
+	return nil
+}
+
+// newTestWatcher serves a watch server of a memory registry and returns a Watcher of it.
+func newTestWatcher(t *testing.T) (*watchclient.Watcher, watchserver.Registry) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := watchserver.NewMemoryRegistry()
+	server := grpc.NewServer()
+	pb.RegisterWatchRPCServer(server, watchserver.NewWatchRpcServer(&watchserver.GrpcServerConfig{}, zap.NewNop(), registry))
+	go server.Serve(l)
+	t.Cleanup(func() {
+		server.Stop()
+		registry.Close()
+	})
+
+	cli, err := grpclient.NewGRPCClient(&grpclient.GrpcClientConfig{Endpoints: []string{l.Addr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+
+	w := watchclient.NewWatcherWithConfig(&watchclient.WatcherConfig{
+		LogFilename: filepath.Join(t.TempDir(), "watch.log"),
+		LogLevel:    zapcore.FatalLevel,
+	}, cli)
+	t.Cleanup(w.Close)
+	return w, registry
+}
+
+func parseTarget(t *testing.T, target string) resolver.Target {
+	t.Helper()
+
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resolver.Target{URL: *u}
+}
+
+func TestBuildMalformedTarget(t *testing.T) {
+	tests := []struct {
+		target string
+	}{
+		{"grpcwatch:///svc"},
+		{"grpcwatch://qa/"},
+		{"grpcwatch://qa"},
+	}
+
+	b := &builder{}
+	for i, tt := range tests {
+		if _, err := b.Build(parseTarget(t, tt.target), &testClientConn{}, resolver.BuildOptions{}); err == nil {
+			t.Errorf("#%d: expected an error building %s", i, tt.target)
+		}
+	}
+}
+
+func TestResolverUpdateState(t *testing.T) {
+	w, registry := newTestWatcher(t)
+	app := &pb.App{Name: "svc", Env: "qa"}
+
+	cc := &testClientConn{statec: make(chan resolver.State, 16)}
+	r, err := (&builder{watcher: w}).Build(parseTarget(t, Target(app)), cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	tests := []struct {
+		op func() error
+
+		addrs []string
+		mds   []picker.AddrMetadata
+	}{
+		// the created response of an app without servers
+		{func() error { return nil }, []string{}, nil},
+		{
+			func() error {
+				return registry.Register(app, &pb.AppServer{Ip: "10.0.0.1", Port: "80", Weight: 3, Zone: "a"}, 0)
+			},
+			[]string{"10.0.0.1:80"}, []picker.AddrMetadata{{Weight: 3, Zone: "a"}},
+		},
+		{
+			func() error { return registry.Register(app, &pb.AppServer{Ip: "10.0.0.2", Port: "80"}, 0) },
+			[]string{"10.0.0.1:80", "10.0.0.2:80"}, []picker.AddrMetadata{{Weight: 3, Zone: "a"}, {}},
+		},
+		{
+			func() error { return registry.Deregister(app, &pb.AppServer{Ip: "10.0.0.1", Port: "80"}) },
+			[]string{"10.0.0.2:80"}, []picker.AddrMetadata{{}},
+		},
+		// other apps are not resolved
+		{
+			func() error {
+				registry.Register(&pb.App{Name: "other", Env: "qa"}, &pb.AppServer{Ip: "10.0.0.3", Port: "80"}, 0)
+				return registry.Deregister(app, &pb.AppServer{Ip: "10.0.0.2", Port: "80"})
+			},
+			[]string{}, nil,
+		},
+	}
+
+	for i, tt := range tests {
+		if err := tt.op(); err != nil {
+			t.Fatal(err)
+		}
+
+		var state resolver.State
+		select {
+		case state = <-cc.statec:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("#%d: timed out waiting for the state", i)
+		}
+
+		addrs := []string{}
+		var mds []picker.AddrMetadata
+		for _, addr := range state.Addresses {
+			addrs = append(addrs, addr.Addr)
+			md, _ := picker.GetAddrMetadata(addr)
+			mds = append(mds, md)
+		}
+		if !reflect.DeepEqual(addrs, tt.addrs) || !reflect.DeepEqual(mds, tt.mds) {
+			t.Errorf("#%d: expected %v %v, got %v %v", i, tt.addrs, tt.mds, addrs, mds)
+		}
+	}
+}
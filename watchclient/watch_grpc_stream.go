@@ -2,6 +2,8 @@ package watchclient
 
 import (
 	"context"
+	"net"
+	"sort"
 	"time"
 
 	pb "github.com/xkeyideal/grpcwatch/watchpb"
@@ -10,55 +12,145 @@ import (
 	"google.golang.org/grpc"
 )
 
-var maxBackoff = 2000 * time.Millisecond
-
-// GRPC stream管理
+// GRPC stream管理, 同一个Watcher的所有watch复用一个gRPC stream
 type watchGrpcStream struct {
 	owner    *Watcher
 	remote   pb.WatchRPCClient
 	callOpts []grpc.CallOption
 
-	watchID string
-
 	// ctx controls internal remote.Watch requests
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	// 原始创建watch的请求, 主要用于断线重连
-	initReq watchStreamRequest
+	// wcancel 取消当前的remote.Watch, 用于stream失效后的断线重连
+	wcancel context.CancelFunc
+
+	// 超过该时间未收到服务端的任何响应则认为stream已失效, 为0时不检测
+	progressTimeout time.Duration
+
+	// backoffPolicy 断线重连的回退策略, 为nil时使用DefaultBackoffPolicy
+	backoffPolicy *BackoffPolicy
+
+	// reconnectBackoff 连续重连失败的回退状态, 新的stream收到服务端响应后重置
+	// 只在run goroutine中访问
+	reconnectBackoff *backoff
+
+	// substreams holds all active watchers on this grpc stream, keyed by watchID
+	// 只在run goroutine中访问
+	substreams map[string]*watcherStream
 
 	// reqc sends a watch request from Watch() to the main goroutine
 	reqc chan watchStreamRequest
 
 	// respc receives data from the watch client
-	respc chan *pb.WatchResponse
+	respc chan *streamResponse
+
+	// closingc gets the watcherStream of closing watchers
+	closingc chan *watcherStream
+
+	// stopc is closed by Watcher.Close to shutdown all watchers immediately
+	stopc chan struct{}
 
 	// donec closes to broadcast shutdown
 	donec chan struct{}
 
 	// errc transmits errors from grpc Recv to the watch stream reconnect logic
-	errc chan error
+	errc chan *streamError
+
+	// closeErr 异常退出（例如重连超过回退策略的上限）的原因，Watcher关闭时为nil，donec关闭后才可以读取
+	closeErr error
+
+	// gen 当前remote.Watch的代数，每次新建stream时递增，
+	// 旧stream在断开前已经读到的响应与错误带有旧的代数，run收到后直接丢弃
+	// 只在run goroutine中访问
+	gen uint64
 
 	// 日志
 	lg *zap.Logger
 }
 
+// watcherStream 表示复用在gRPC stream上的单个watch
+type watcherStream struct {
+	// 原始创建watch的请求, 主要用于断线重连
+	initReq *watchCreateRequest
+
+	// 已经收到的最大revision, 断线重连后从lastRev+1开始补发事件
+	lastRev int64
+
+	// 断线重连后重新发送了watch request, 尚未收到服务端的响应
+	resuming bool
+
+	// 已经收到服务端的created响应
+	created bool
+
+	// 增量模式下每个app当前完整的服务器地址，按照ip:port索引
+	servers map[appKey]map[string]*pb.AppServer
+
+	// 前缀模式下已经推送给调用方并且有服务器地址的app
+	apps map[appKey]struct{}
+
+	// 前缀模式的watch被压缩后重新创建，调用方拥有但是尚未重新推送的app，
+	// 重新创建后的CREATE事件推送完毕（收到随后请求的progress notify）时为这些app推送DELETE事件
+	stale map[appKey]struct{}
+
+	// outc 交给调用方消费的channel
+	outc chan *pb.WatchResponse
+
+	// recvc buffers watch responses before publishing
+	recvc chan *pb.WatchResponse
+
+	// donec closes when the watcherStream goroutine stops.
+	donec chan struct{}
+}
+
+// streamResponse 服务端的响应及其所属stream的代数
+type streamResponse struct {
+	gen  uint64
+	resp *pb.WatchResponse
+}
+
+// streamError Recv的错误及其所属stream的代数
+type streamError struct {
+	gen uint64
+	err error
+}
+
+type appKey struct {
+	name string
+	env  string
+}
+
 func (wgs *watchGrpcStream) run() {
 	var wc pb.WatchRPC_WatchClient
 	var closeErr error
 
 	// 处理异常退出时，记录错误日志
 	defer func() {
+		// Watcher关闭导致的错误属于正常退出，不需要告知调用方
+		if closeErr != nil && wgs.closing() {
+			closeErr = nil
+		}
 		if closeErr != nil {
-			wgs.lg.Error("watch_grpc_stream client error closed", zap.String("watchID", wgs.watchID), zap.String("err", closeErr.Error()))
-			// 正常情况下退出，client会主动调用wgs.close(), 触发goroutine run 的退出信号
-			// 只有当closeErr != nil, 异常退出时，才需要如此处理，告知client主动退出
-			wgs.respc <- &pb.WatchResponse{
-				Canceled:     true,
-				CancelReason: closeErr.Error(),
+			wgs.lg.Error("watch_grpc_stream client error closed", zap.String("err", closeErr.Error()))
+		}
+
+		// 通知所有的watch退出，异常退出时推送canceled响应，告知调用方
+		for _, ws := range wgs.substreams {
+			if closeErr != nil {
+				wgs.deliver(ws, &pb.WatchResponse{
+					WatchId:      ws.initReq.watchID,
+					App:          ws.initReq.app,
+					Canceled:     true,
+					CancelReason: closeErr.Error(),
+				})
 			}
-			wgs.owner.closeStream(wgs)
+			close(ws.recvc)
 		}
+		wgs.substreams = nil
+
+		wgs.closeErr = closeErr
+		wgs.owner.closeStream(wgs)
+		close(wgs.donec)
 	}()
 
 	// 尝试连接服务端，若失败会不断的采用回退算法进行重试
@@ -66,74 +158,418 @@ func (wgs *watchGrpcStream) run() {
 		return
 	}
 
+	// 检测stream是否存活，收到服务端的任何响应都会重置
+	var livenessc <-chan time.Time
+	var liveness *time.Timer
+	if wgs.progressTimeout > 0 {
+		liveness = time.NewTimer(wgs.progressTimeout)
+		defer liveness.Stop()
+		livenessc = liveness.C
+	}
+	resetLiveness := func() {
+		if liveness == nil {
+			return
+		}
+		if !liveness.Stop() {
+			select {
+			case <-liveness.C:
+			default:
+			}
+		}
+		liveness.Reset(wgs.progressTimeout)
+	}
+
 	for {
 		select {
 		case req := <-wgs.reqc:
 			switch wreq := req.(type) {
 			// watch request 创建处理
 			case *watchCreateRequest:
+				if _, ok := wgs.substreams[wreq.watchID]; ok {
+					// 这里处理不可以重复watch
+					ch := make(chan *pb.WatchResponse)
+					close(ch)
+					wreq.retc <- ch
+					continue
+				}
+
+				ws := &watcherStream{
+					initReq: wreq,
+					servers: make(map[appKey]map[string]*pb.AppServer),
+					apps:    make(map[appKey]struct{}),
+					outc:    make(chan *pb.WatchResponse),
+					recvc:   make(chan *pb.WatchResponse, 16),
+					donec:   make(chan struct{}),
+				}
+				wgs.substreams[wreq.watchID] = ws
+				go wgs.serveSubstream(ws)
+
+				wreq.retc <- ws.outc
+
 				if err := wc.Send(wreq.toPB()); err != nil {
-					wgs.lg.Error("createwatch", zap.String("watchID", wgs.watchID), zap.Any("request", wreq),
+					wgs.lg.Error("createwatch", zap.String("watchID", wreq.watchID), zap.Any("request", wreq),
 						zap.String("err", err.Error()))
 				}
-			// 取消watch request的处理
+			// 取消watch request的处理, 收到服务端的canceled响应后再移除watch
 			case *watchCancelRequest:
+				if _, ok := wgs.substreams[wreq.watchID]; !ok {
+					continue
+				}
 				if err := wc.Send(wreq.toPB()); err != nil {
-					wgs.lg.Error("cancelwatch", zap.String("watchID", wgs.watchID), zap.Any("request", wreq),
+					wgs.lg.Error("cancelwatch", zap.String("watchID", wreq.watchID), zap.Any("request", wreq),
 						zap.String("err", err.Error()))
 				}
+			case *watchProgressRequest:
+				if err := wc.Send(wreq.toPB()); err != nil {
+					wgs.lg.Error("progress", zap.String("err", err.Error()))
+				}
+			case *watchSnapshotRequest:
+				if _, ok := wgs.substreams[wreq.watchID]; !ok {
+					continue
+				}
+				if err := wc.Send(wreq.toPB()); err != nil {
+					wgs.lg.Error("snapshot", zap.String("watchID", wreq.watchID), zap.String("err", err.Error()))
+				}
+			}
+		// 按照watch_id将服务端的响应分发给对应的watch
+		case sresp := <-wgs.respc:
+			// 旧stream的响应, 重连后重新发送的watch request尚未确认, 不能用来清除resuming
+			if sresp.gen != wgs.gen {
+				continue
+			}
+			resp := sresp.resp
+
+			resetLiveness()
+			// stream已恢复, 下一次断线从回退策略的Initial开始等待
+			wgs.reconnectBackoff = nil
+
+			// progress notify之前的事件均已收到，更新所有已创建的watch的revision
+			if resp.ProgressNotify {
+				for _, ws := range wgs.substreams {
+					if ws.created && !ws.resuming && resp.Revision > ws.lastRev {
+						ws.lastRev = resp.Revision
+					}
+					if ws.stale != nil && !ws.resuming {
+						wgs.deleteStale(ws, resp.Revision)
+					}
+				}
+				continue
+			}
+
+			ws, ok := wgs.substreams[resp.WatchId]
+			if !ok {
+				continue
+			}
+			wgs.dispatch(wc, ws, resp)
+		// 调用方的ctx结束，取消对应的watch
+		case ws := <-wgs.closingc:
+			if wgs.substreams[ws.initReq.watchID] != ws {
+				continue
+			}
+			wgs.removeSubstream(ws)
+
+			wr := &watchCancelRequest{watchID: ws.initReq.watchID}
+			if err := wc.Send(wr.toPB()); err != nil {
+				wgs.lg.Error("cancelwatch", zap.String("watchID", wr.watchID), zap.String("err", err.Error()))
 			}
 		// watch client failed on Recv; spawn another if possible
-		case err := <-wgs.errc:
+		case serr := <-wgs.errc:
+			// 旧stream的错误, 已经重连过, 无需再次重连
+			if serr.gen != wgs.gen {
+				continue
+			}
+			err := serr.err
+
 			if isHaltErr(wgs.ctx, err) {
 				closeErr = err
 				return
 			}
 
-			// 重试
-			if wc, closeErr = wgs.newWatchClient(); closeErr != nil {
+			// 重试, 连续失败时按照回退策略等待, 避免大量客户端同时重连
+			if closeErr = wgs.waitBackoff(err); closeErr != nil {
 				return
 			}
+			if wc, closeErr = wgs.reconnect(); closeErr != nil {
+				return
+			}
+			resetLiveness()
+		// 超时未收到服务端的任何响应，认为stream已失效，主动断开并重连
+		case <-livenessc:
+			wgs.lg.Warn("watch stream progress notify timeout", zap.Duration("timeout", wgs.progressTimeout))
 
-			// 重试连接成功后，在此发送 watch request
-			if err := wc.Send(wgs.initReq.(*watchCreateRequest).toPB()); err != nil {
-				wgs.lg.Error("recreatewatch", zap.String("watchID", wgs.watchID), zap.Any("request", wgs.initReq),
-					zap.String("err", err.Error()))
+			if wc, closeErr = wgs.reconnect(); closeErr != nil {
+				return
 			}
+			resetLiveness()
 		case <-wgs.ctx.Done():
 			return
-		case <-wgs.donec:
+		case <-wgs.stopc:
+			return
+		}
+	}
+}
+
+// reconnect 断开当前的remote.Watch并重新连接，成功后重新发送所有的watch request,
+// 从已收到的revision之后开始补发事件
+func (wgs *watchGrpcStream) reconnect() (pb.WatchRPC_WatchClient, error) {
+	wgs.wcancel()
+
+	wc, err := wgs.newWatchClient()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ws := range wgs.substreams {
+		ws.resuming = true
+		req := ws.resumeRequest()
+		if err := wc.Send(req.toPB()); err != nil {
+			wgs.lg.Error("recreatewatch", zap.String("watchID", req.watchID), zap.Any("request", req),
+				zap.String("err", err.Error()))
+		}
+	}
+	return wc, nil
+}
+
+// dispatch 处理某个watch收到的服务端响应
+func (wgs *watchGrpcStream) dispatch(wc pb.WatchRPC_WatchClient, ws *watcherStream, resp *pb.WatchResponse) {
+	resuming := ws.resuming
+	ws.resuming = false
+	if resp.Created {
+		// 断线重连后恢复watch的created响应只是确认，调用方已经收到过created响应，无需再次推送
+		if resuming && ws.created && ws.lastRev > 0 {
+			return
+		}
+		ws.created = true
+	}
+
+	// 断线期间错过的事件已被服务端压缩，重新创建watch获取当前完整的服务器地址列表
+	if resuming && resp.Canceled && resp.CancelReason == pb.CancelReasonCompacted {
+		wgs.lg.Warn("watch revision compacted", zap.String("watchID", resp.WatchId),
+			zap.Int64("lastRevision", ws.lastRev), zap.Int64("compactRevision", resp.CompactRevision))
+
+		ws.lastRev = 0
+		ws.servers = make(map[appKey]map[string]*pb.AppServer)
+		ws.resuming = true
+		req := ws.resumeRequest()
+		if err := wc.Send(req.toPB()); err != nil {
+			wgs.lg.Error("recreatewatch", zap.String("watchID", req.watchID), zap.Any("request", req),
+				zap.String("err", err.Error()))
+		}
+
+		// 断线期间被删除的app不会再收到事件，重新创建后服务端推送的CREATE事件之后紧跟progress notify,
+		// 届时为没有重新推送的app推送DELETE事件；过滤了CREATE或DELETE事件时无法据此判断，不推送
+		if req.prefix && !req.filtered(pb.WatchCreateRequest_NOCREATE) && !req.filtered(pb.WatchCreateRequest_NODELETE) {
+			ws.stale = ws.apps
+			ws.apps = make(map[appKey]struct{})
+			if err := wc.Send((&watchProgressRequest{}).toPB()); err != nil {
+				wgs.lg.Error("progress", zap.String("err", err.Error()))
+			}
+		}
+		return
+	}
+
+	if !resp.Canceled && resp.Revision > ws.lastRev {
+		ws.lastRev = resp.Revision
+	}
+
+	if ws.initReq.delta && !resp.Canceled {
+		ws.rebuild(resp)
+	}
+
+	if ws.initReq.prefix && !resp.Created && !resp.Canceled && resp.App != nil {
+		key := appKey{name: resp.App.Name, env: resp.App.Env}
+		if len(resp.Servers) == 0 {
+			delete(ws.apps, key)
+		} else {
+			ws.apps[key] = struct{}{}
+		}
+		delete(ws.stale, key)
+	}
+
+	wgs.deliver(ws, resp)
+
+	// 服务端确认watch已取消，移除该watch
+	if resp.Canceled {
+		wgs.removeSubstream(ws)
+	}
+}
+
+// deleteStale 为压缩后没有重新推送的app推送DELETE事件
+func (wgs *watchGrpcStream) deleteStale(ws *watcherStream, rev int64) {
+	keys := make([]appKey, 0, len(ws.stale))
+	for key := range ws.stale {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].env != keys[j].env {
+			return keys[i].env < keys[j].env
+		}
+		return keys[i].name < keys[j].name
+	})
+	ws.stale = nil
+
+	for _, key := range keys {
+		wgs.lg.Info("watch app deleted while compacted", zap.String("watchID", ws.initReq.watchID),
+			zap.String("app", key.name), zap.String("env", key.env))
+		wgs.deliver(ws, &pb.WatchResponse{
+			WatchId:  ws.initReq.watchID,
+			App:      &pb.App{Name: key.name, Env: key.env},
+			Event:    pb.EventType_DELETE,
+			Revision: rev,
+		})
+	}
+}
+
+// deliver 将响应交给watch的goroutine, 该goroutine已退出时丢弃
+func (wgs *watchGrpcStream) deliver(ws *watcherStream, resp *pb.WatchResponse) {
+	select {
+	case ws.recvc <- resp:
+	case <-ws.donec:
+	}
+}
+
+func (wgs *watchGrpcStream) removeSubstream(ws *watcherStream) {
+	delete(wgs.substreams, ws.initReq.watchID)
+	close(ws.recvc)
+}
+
+// serveSubstream 缓存单个watch的响应，并交给调用方消费，避免调用方消费缓慢阻塞其他watch
+func (wgs *watchGrpcStream) serveSubstream(ws *watcherStream) {
+	defer func() {
+		close(ws.donec)
+		close(ws.outc)
+	}()
+
+	ctx := ws.initReq.ctx
+
+	var buf []*pb.WatchResponse
+	for {
+		var outc chan *pb.WatchResponse
+		var next *pb.WatchResponse
+		if len(buf) > 0 {
+			outc = ws.outc
+			next = buf[0]
+		}
+
+		select {
+		// 放入channel，供业务逻辑消费
+		case outc <- next:
+			buf[0] = nil
+			buf = buf[1:]
+		case resp, ok := <-ws.recvc:
+			if !ok {
+				// watch已被移除，将剩余的响应交给调用方后退出
+				for _, resp := range buf {
+					select {
+					case ws.outc <- resp:
+					case <-ctx.Done():
+						return
+					case <-wgs.stopc:
+						return
+					}
+				}
+				return
+			}
+			buf = append(buf, resp)
+		case <-ctx.Done():
+			// 先退出goroutine关闭donec, 保证run向recvc发送数据时不会阻塞
+			go func() {
+				select {
+				case wgs.closingc <- ws:
+				case <-wgs.donec:
+				}
+			}()
+			return
+		case <-wgs.stopc:
 			return
 		}
 	}
 }
 
+// rebuild 增量模式下根据快照或者added与removed重建app完整的服务器地址列表，并填充到resp.Servers
+func (ws *watcherStream) rebuild(resp *pb.WatchResponse) {
+	key := appKey{name: resp.GetApp().GetName(), env: resp.GetApp().GetEnv()}
+
+	set, ok := ws.servers[key]
+	if !ok || resp.Snapshot {
+		set = make(map[string]*pb.AppServer)
+	}
+	if resp.Snapshot {
+		for _, server := range resp.Servers {
+			set[net.JoinHostPort(server.Ip, server.Port)] = server
+		}
+	}
+	for _, server := range resp.Removed {
+		delete(set, net.JoinHostPort(server.Ip, server.Port))
+	}
+	for _, server := range resp.Added {
+		set[net.JoinHostPort(server.Ip, server.Port)] = server
+	}
+
+	if len(set) == 0 {
+		delete(ws.servers, key)
+	} else {
+		ws.servers[key] = set
+	}
+
+	// 与服务端一致，按照ip:port排序
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	servers := make([]*pb.AppServer, 0, len(keys))
+	for _, k := range keys {
+		servers = append(servers, set[k])
+	}
+	resp.Servers = servers
+}
+
+// resumeRequest 返回断线重连时使用的watch request
+func (ws *watcherStream) resumeRequest() *watchCreateRequest {
+	req := *ws.initReq
+	if ws.lastRev > 0 {
+		req.startRev = ws.lastRev + 1
+	}
+	return &req
+}
+
 func (wgs *watchGrpcStream) newWatchClient() (pb.WatchRPC_WatchClient, error) {
-	wc, err := wgs.openWatchClient()
+	wctx, wcancel := context.WithCancel(wgs.ctx)
+	wc, err := wgs.openWatchClient(wctx)
 	if err != nil {
+		wcancel()
 		return nil, err
 	}
+	wgs.wcancel = wcancel
+	wgs.gen++
 
 	// receive data from new grpc stream
-	go wgs.serveWatchClient(wc)
+	go wgs.serveWatchClient(wctx, wc, wgs.gen)
 	return wc, nil
 }
 
 // 接收服务端推送的数据
-func (wgs *watchGrpcStream) serveWatchClient(wc pb.WatchRPC_WatchClient) {
+func (wgs *watchGrpcStream) serveWatchClient(wctx context.Context, wc pb.WatchRPC_WatchClient, gen uint64) {
 	for {
 		resp, err := wc.Recv()
+
+		// 该stream已被主动断开，新的stream已经接管，丢弃旧stream的数据与错误
+		if wctx.Err() != nil && wgs.ctx.Err() == nil {
+			return
+		}
+
 		// 接收服务端数据的时候，发生错误，需要判断code，进行重试或断开处理
 		if err != nil {
 			select {
-			case wgs.errc <- err:
+			case wgs.errc <- &streamError{gen: gen, err: err}:
 			case <-wgs.donec:
 			}
 			return
 		}
 		select {
-		// 放入channel，供业务逻辑消费
-		case wgs.respc <- resp:
+		case wgs.respc <- &streamResponse{gen: gen, resp: resp}:
 		case <-wgs.donec:
 			return
 		}
@@ -141,16 +577,14 @@ func (wgs *watchGrpcStream) serveWatchClient(wc pb.WatchRPC_WatchClient) {
 }
 
 // 开启创建与服务端的连接，并处理断线重连的问题
-func (wgs *watchGrpcStream) openWatchClient() (pb.WatchRPC_WatchClient, error) {
-	backoff := time.Millisecond
-	retryTimes := 0
+func (wgs *watchGrpcStream) openWatchClient(wctx context.Context) (pb.WatchRPC_WatchClient, error) {
 	for {
 		select {
 		case <-wgs.ctx.Done():
 			return nil, wgs.ctx.Err()
 		default:
 		}
-		ws, err := wgs.remote.Watch(wgs.ctx, wgs.callOpts...)
+		ws, err := wgs.remote.Watch(wctx, wgs.callOpts...)
 		if ws != nil && err == nil {
 			return ws, nil
 		}
@@ -161,28 +595,73 @@ func (wgs *watchGrpcStream) openWatchClient() (pb.WatchRPC_WatchClient, error) {
 			return nil, err
 		}
 
-		// 只有网络不可达的时候才重连
+		// retry, but backoff
 		// TODO: 此处可以适当的修改，例如追加报警策略等，好让调用方显示的知晓，而非隐式的重试
-		if isUnavailableErr(wgs.ctx, err) {
-			// retry, but backoff
-			if backoff < maxBackoff {
-				// 25% backoff factor
-				backoff = backoff + backoff/4
-				if backoff > maxBackoff {
-					backoff = maxBackoff
-				}
-			}
-			retryTimes++
-			wgs.lg.Warn("watch internet unavailable", zap.String("watchID", wgs.watchID), zap.Int("retrytimes", retryTimes),
-				zap.Int64("backoff", backoff.Milliseconds()))
-			time.Sleep(backoff)
+		if err = wgs.waitBackoff(err); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// waitBackoff 按照回退策略等待下一次重连，连续失败超过策略的上限时返回err，
+// 等待期间Watcher关闭则立即返回
+func (wgs *watchGrpcStream) waitBackoff(err error) error {
+	if wgs.reconnectBackoff == nil {
+		wgs.reconnectBackoff = newBackoff(wgs.backoffPolicy)
+	}
+	bo := wgs.reconnectBackoff
+
+	wait, ok := bo.next()
+	if !ok {
+		wgs.lg.Error("watch reconnect give up", zap.Int("retrytimes", bo.attempts-1),
+			zap.Duration("elapsed", time.Since(bo.start)), zap.String("err", err.Error()))
+		return err
+	}
+	wgs.lg.Warn("watch internet unavailable", zap.Int("retrytimes", bo.attempts),
+		zap.Int64("backoff", wait.Milliseconds()), zap.String("err", err.Error()))
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-wgs.stopc:
+		return context.Canceled
+	case <-wgs.ctx.Done():
+		return wgs.ctx.Err()
+	}
+}
+
+// closing 返回Watcher是否正在关闭
+func (wgs *watchGrpcStream) closing() bool {
+	select {
+	case <-wgs.stopc:
+		return true
+	default:
+		return wgs.ctx.Err() != nil
+	}
+}
+
+// closedWatchChan 返回stream已经退出后的watch channel，异常退出时推送携带退出原因的canceled响应，之后关闭
+func (wgs *watchGrpcStream) closedWatchChan(wr *watchCreateRequest) chan *pb.WatchResponse {
+	ch := make(chan *pb.WatchResponse, 1)
+	if wgs.closeErr != nil {
+		ch <- &pb.WatchResponse{
+			WatchId:      wr.watchID,
+			App:          wr.app,
+			Canceled:     true,
+			CancelReason: wgs.closeErr.Error(),
 		}
 	}
+	close(ch)
+	return ch
 }
 
 func (wgs *watchGrpcStream) close() {
+	close(wgs.stopc)
 	wgs.cancel()
-	close(wgs.donec)
+	<-wgs.donec
 
-	wgs.lg.Info("watch_grpc_stream", zap.String(wgs.watchID, "close"))
+	wgs.lg.Info("watch_grpc_stream close")
 }
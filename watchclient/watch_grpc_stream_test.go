@@ -0,0 +1,250 @@
+package watchclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeStream is a watch stream driven by the test.
+type fakeStream struct {
+	grpc.ClientStream
+
+	ctx   context.Context
+	reqc  chan *pb.WatchRequest
+	respc chan *pb.WatchResponse
+	errc  chan error
+}
+
+func (s *fakeStream) Send(req *pb.WatchRequest) error {
+	select {
+	case s.reqc <- req:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *fakeStream) Recv() (*pb.WatchResponse, error) {
+	select {
+	case resp := <-s.respc:
+		return resp, nil
+	case err := <-s.errc:
+		return nil, err
+	case <-s.ctx.Done():
+		return nil, status.FromContextError(s.ctx.Err()).Err()
+	}
+}
+
+// next returns the next request sent on the stream.
+func (s *fakeStream) next(t *testing.T) *pb.WatchRequest {
+	t.Helper()
+
+	select {
+	case req := <-s.reqc:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a request")
+		return nil
+	}
+}
+
+// fakeRemote hands every watch stream opened by a Watcher to the test.
+type fakeRemote struct {
+	pb.WatchRPCClient
+
+	streams chan *fakeStream
+}
+
+func (r *fakeRemote) Watch(ctx context.Context, _ ...grpc.CallOption) (pb.WatchRPC_WatchClient, error) {
+	s := &fakeStream{
+		ctx:   ctx,
+		reqc:  make(chan *pb.WatchRequest, 16),
+		respc: make(chan *pb.WatchResponse),
+		errc:  make(chan error),
+	}
+	r.streams <- s
+	return s, nil
+}
+
+// newFakeWatcher returns a Watcher whose streams are opened on a fakeRemote.
+func newFakeWatcher(t *testing.T) (*Watcher, *fakeRemote) {
+	t.Helper()
+
+	remote := &fakeRemote{streams: make(chan *fakeStream, 16)}
+	w := &Watcher{
+		remote: remote,
+		cfg:    &WatcherConfig{Backoff: &BackoffPolicy{Initial: time.Millisecond}},
+		lg:     zap.NewNop(),
+	}
+	t.Cleanup(w.Close)
+	return w, remote
+}
+
+func recvResponse(t *testing.T, ch <-chan *pb.WatchResponse) *pb.WatchResponse {
+	t.Helper()
+
+	select {
+	case resp := <-ch:
+		return resp
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a response")
+		return nil
+	}
+}
+
+func TestWatcherStreamResumeRequest(t *testing.T) {
+	tests := []struct {
+		startRev int64
+		lastRev  int64
+		expected int64
+	}{
+		{0, 0, 0},
+		{5, 0, 5},
+		{0, 10, 11},
+		{5, 10, 11},
+	}
+
+	for i, tt := range tests {
+		ws := &watcherStream{initReq: &watchCreateRequest{startRev: tt.startRev}, lastRev: tt.lastRev}
+		if req := ws.resumeRequest(); req.startRev != tt.expected {
+			t.Errorf("#%d: expected start revision %d, got %d", i, tt.expected, req.startRev)
+		}
+		if ws.initReq.startRev != tt.startRev {
+			t.Errorf("#%d: expected the initial request to be left unchanged", i)
+		}
+	}
+}
+
+func TestWatcherStreamRebuild(t *testing.T) {
+	app := &pb.App{Name: "svc", Env: "qa"}
+	other := &pb.App{Name: "other", Env: "qa"}
+	s1, s2, s3 := &pb.AppServer{Ip: "10.0.0.1", Port: "80"}, &pb.AppServer{Ip: "10.0.0.2", Port: "80"}, &pb.AppServer{Ip: "10.0.0.3", Port: "80"}
+	s4, s5 := &pb.AppServer{Ip: "10.0.0.4", Port: "80"}, &pb.AppServer{Ip: "10.0.1.1", Port: "80"}
+
+	tests := []struct {
+		resp     *pb.WatchResponse
+		expected []*pb.AppServer
+	}{
+		// the created response of a delta watch is a snapshot
+		{&pb.WatchResponse{App: app, Snapshot: true, Servers: []*pb.AppServer{s2, s1}}, []*pb.AppServer{s1, s2}},
+		{&pb.WatchResponse{App: app, Added: []*pb.AppServer{s3}}, []*pb.AppServer{s1, s2, s3}},
+		{&pb.WatchResponse{App: app, Removed: []*pb.AppServer{s1}}, []*pb.AppServer{s2, s3}},
+		// added and removed in the same event
+		{&pb.WatchResponse{App: app, Added: []*pb.AppServer{s4}, Removed: []*pb.AppServer{s2}}, []*pb.AppServer{s3, s4}},
+		// other apps are rebuilt separately
+		{&pb.WatchResponse{App: other, Added: []*pb.AppServer{s5}}, []*pb.AppServer{s5}},
+		// removing an unknown server changes nothing
+		{&pb.WatchResponse{App: app, Removed: []*pb.AppServer{s1}}, []*pb.AppServer{s3, s4}},
+		// a snapshot replaces the servers
+		{&pb.WatchResponse{App: app, Snapshot: true, Servers: []*pb.AppServer{s1}}, []*pb.AppServer{s1}},
+		{&pb.WatchResponse{App: app, Removed: []*pb.AppServer{s1}}, []*pb.AppServer{}},
+		{&pb.WatchResponse{App: app, Added: []*pb.AppServer{s2}}, []*pb.AppServer{s2}},
+		{&pb.WatchResponse{App: other}, []*pb.AppServer{s5}},
+		// a server whose labels changed is added again
+		{&pb.WatchResponse{App: app, Added: []*pb.AppServer{{Ip: "10.0.0.2", Port: "80", Labels: map[string]string{"zone": "b"}}}},
+			[]*pb.AppServer{{Ip: "10.0.0.2", Port: "80", Labels: map[string]string{"zone": "b"}}}},
+	}
+
+	ws := &watcherStream{servers: make(map[appKey]map[string]*pb.AppServer)}
+	for i, tt := range tests {
+		ws.rebuild(tt.resp)
+		if len(tt.resp.Servers) != len(tt.expected) {
+			t.Errorf("#%d: expected servers %v, got %v", i, tt.expected, tt.resp.Servers)
+			continue
+		}
+		for j := range tt.expected {
+			if !proto.Equal(tt.resp.Servers[j], tt.expected[j]) {
+				t.Errorf("#%d: expected servers %v, got %v", i, tt.expected, tt.resp.Servers)
+				break
+			}
+		}
+	}
+
+	// an app without any server is forgotten
+	ws.rebuild(&pb.WatchResponse{App: other, Removed: []*pb.AppServer{s5}})
+	if _, ok := ws.servers[appKey{name: other.Name, env: other.Env}]; ok {
+		t.Error("expected the servers of other to be removed")
+	}
+}
+
+func TestPrefixWatchCompacted(t *testing.T) {
+	tests := []struct {
+		opts []WatchOption
+
+		// the apps deleted while compacted are reported by DELETE events
+		deleted bool
+	}{
+		{nil, true},
+		{[]WatchOption{WithFilterUpdate()}, true},
+		{[]WatchOption{WithDelta(0)}, true},
+		// without the CREATE or DELETE events, the deleted apps are unknown
+		{[]WatchOption{WithFilterDelete()}, false},
+		{[]WatchOption{WithFilterCreate()}, false},
+	}
+
+	a, b := &pb.App{Name: "svc-a", Env: "qa"}, &pb.App{Name: "svc-b", Env: "qa"}
+	servers := []*pb.AppServer{{Ip: "10.0.0.1", Port: "80"}}
+
+	type event struct {
+		app   *pb.App
+		event pb.EventType
+	}
+
+	for i, tt := range tests {
+		w, remote := newFakeWatcher(t)
+		ch := w.Watch(context.Background(), "w", &pb.App{Name: "svc"}, append(tt.opts, WithPrefix())...)
+
+		s := <-remote.streams
+		s.next(t)
+		s.respc <- &pb.WatchResponse{WatchId: "w", Created: true, Revision: 10}
+		s.respc <- &pb.WatchResponse{WatchId: "w", App: a, Event: pb.EventType_CREATE, Servers: servers, Snapshot: true, Revision: 10}
+		s.respc <- &pb.WatchResponse{WatchId: "w", App: b, Event: pb.EventType_CREATE, Servers: servers, Snapshot: true, Revision: 10}
+		for j := 0; j < 3; j++ {
+			recvResponse(t, ch)
+		}
+
+		// svc-b is deleted while the stream is broken and the events are compacted
+		s.errc <- status.Error(codes.Unavailable, "broken")
+		s = <-remote.streams
+		if req := s.next(t).GetCreateRequest(); req.GetStartRevision() != 11 {
+			t.Fatalf("#%d: expected to resume from revision 11, got %v", i, req)
+		}
+		s.respc <- &pb.WatchResponse{WatchId: "w", Canceled: true, CancelReason: pb.CancelReasonCompacted, CompactRevision: 20}
+		if req := s.next(t).GetCreateRequest(); req == nil || req.StartRevision != 0 {
+			t.Fatalf("#%d: expected the watch to be created again, got %v", i, req)
+		}
+		if tt.deleted {
+			if req := s.next(t); req.GetProgressRequest() == nil {
+				t.Fatalf("#%d: expected a progress request, got %v", i, req)
+			}
+		}
+
+		s.respc <- &pb.WatchResponse{WatchId: "w", Created: true, Revision: 30}
+		s.respc <- &pb.WatchResponse{WatchId: "w", App: a, Event: pb.EventType_CREATE, Servers: servers, Snapshot: true, Revision: 30}
+		s.respc <- &pb.WatchResponse{ProgressNotify: true, Revision: 30}
+		s.respc <- &pb.WatchResponse{WatchId: "w", App: a, Event: pb.EventType_UPDATE, Servers: servers, Snapshot: true, Revision: 31}
+
+		expected := []event{{nil, pb.EventType_CREATE}, {a, pb.EventType_CREATE}}
+		if tt.deleted {
+			expected = append(expected, event{b, pb.EventType_DELETE})
+		}
+		expected = append(expected, event{a, pb.EventType_UPDATE})
+
+		for j, e := range expected {
+			resp := recvResponse(t, ch)
+			if resp.GetApp().GetName() != e.app.GetName() || resp.Event != e.event || resp.Created != (j == 0) {
+				t.Errorf("#%d: expected response %d of %v %v, got %v", i, j, e.app, e.event, resp)
+			}
+		}
+		w.Close()
+	}
+}
@@ -0,0 +1,54 @@
+package watchclient
+
+import (
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+)
+
+// WatchOption configures a watch request
+type WatchOption func(*watchCreateRequest)
+
+// WithFilterCreate 服务端不推送CREATE事件
+func WithFilterCreate() WatchOption {
+	return func(wcr *watchCreateRequest) {
+		wcr.filters = append(wcr.filters, pb.WatchCreateRequest_NOCREATE)
+	}
+}
+
+// WithFilterUpdate 服务端不推送UPDATE事件
+func WithFilterUpdate() WatchOption {
+	return func(wcr *watchCreateRequest) {
+		wcr.filters = append(wcr.filters, pb.WatchCreateRequest_NOUPDATE)
+	}
+}
+
+// WithFilterDelete 服务端不推送DELETE事件
+func WithFilterDelete() WatchOption {
+	return func(wcr *watchCreateRequest) {
+		wcr.filters = append(wcr.filters, pb.WatchCreateRequest_NODELETE)
+	}
+}
+
+// WithSelector 只关注labels包含selector所有键值对的服务器地址，例如只关注某个可用区的服务器
+func WithSelector(selector map[string]string) WatchOption {
+	return func(wcr *watchCreateRequest) {
+		wcr.selector = selector
+	}
+}
+
+// WithPrefix 将app名称作为前缀，关注所有名称以其开头的app，env为空时关注所有env，
+// 名称与env都为空时关注所有app。Created响应之后每个匹配的app推送一个CREATE事件
+func WithPrefix() WatchOption {
+	return func(wcr *watchCreateRequest) {
+		wcr.prefix = true
+	}
+}
+
+// WithDelta 增量模式，服务端只推送相对上一次推送的added与removed服务器地址，减少大规模app的推送数据量，
+// 客户端会重建完整的服务器地址列表并填充到servers中，调用方可以继续按照完整列表使用。
+// snapshotEvery大于0时，每个app每推送snapshotEvery个增量事件服务端推送一次完整快照
+func WithDelta(snapshotEvery uint32) WatchOption {
+	return func(wcr *watchCreateRequest) {
+		wcr.delta = true
+		wcr.snapshotEvery = snapshotEvery
+	}
+}
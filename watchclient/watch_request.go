@@ -1,6 +1,8 @@
 package watchclient
 
 import (
+	"context"
+
 	pb "github.com/xkeyideal/grpcwatch/watchpb"
 )
 
@@ -11,14 +13,54 @@ type watchStreamRequest interface {
 
 // 常规的watch参数
 type watchCreateRequest struct {
+	// ctx 调用方的ctx, 结束时取消该watch
+	ctx context.Context
+
 	watchID string
 	app     *pb.App
+
+	// retc receives a chan WatchResponse once the watch is established
+	retc chan chan *pb.WatchResponse
+
+	// 断线重连时从该revision开始补发事件
+	startRev int64
+
+	// 服务端不推送的事件类型
+	filters []pb.WatchCreateRequest_FilterType
+
+	// 只推送labels包含selector所有键值对的服务器地址
+	selector map[string]string
+
+	// app名称作为前缀，关注所有名称以其开头的app
+	prefix bool
+
+	// 增量模式，服务端只推送added与removed，由客户端重建完整的服务器地址列表
+	delta bool
+
+	// 增量模式下每个app每推送snapshotEvery个增量事件服务端推送一次完整快照
+	snapshotEvery uint32
+}
+
+// filtered 返回服务端是否过滤ft对应的事件
+func (wcr *watchCreateRequest) filtered(ft pb.WatchCreateRequest_FilterType) bool {
+	for _, f := range wcr.filters {
+		if f == ft {
+			return true
+		}
+	}
+	return false
 }
 
 func (wcr *watchCreateRequest) toPB() *pb.WatchRequest {
 	req := &pb.WatchCreateRequest{
-		WatchId: wcr.watchID,
-		App:     wcr.app,
+		WatchId:       wcr.watchID,
+		App:           wcr.app,
+		StartRevision: wcr.startRev,
+		Filters:       wcr.filters,
+		Selector:      wcr.selector,
+		Prefix:        wcr.prefix,
+		Delta:         wcr.delta,
+		SnapshotEvery: wcr.snapshotEvery,
 	}
 	cr := &pb.WatchRequest_CreateRequest{CreateRequest: req}
 	return &pb.WatchRequest{RequestUnion: cr}
@@ -35,3 +77,23 @@ func (wcr *watchCancelRequest) toPB() *pb.WatchRequest {
 	cr := &pb.WatchRequest_CancelRequest{CancelRequest: req}
 	return &pb.WatchRequest{RequestUnion: cr}
 }
+
+type watchSnapshotRequest struct {
+	watchID string
+}
+
+func (wsr *watchSnapshotRequest) toPB() *pb.WatchRequest {
+	req := &pb.WatchSnapshotRequest{
+		WatchId: wsr.watchID,
+	}
+	cr := &pb.WatchRequest_SnapshotRequest{SnapshotRequest: req}
+	return &pb.WatchRequest{RequestUnion: cr}
+}
+
+type watchProgressRequest struct{}
+
+func (wpr *watchProgressRequest) toPB() *pb.WatchRequest {
+	req := &pb.WatchProgressRequest{}
+	cr := &pb.WatchRequest_ProgressRequest{ProgressRequest: req}
+	return &pb.WatchRequest{RequestUnion: cr}
+}
@@ -22,21 +22,33 @@ type Watcher struct {
 	// gRPC call options
 	callOpts []grpc.CallOption
 
-	// mu protects the grpc streams map
-	mu sync.RWMutex
+	// mu protects the grpc stream
+	mu sync.Mutex
 
-	// streams holds all the active grpc streams keyed by ctx value.
-	streams map[string]*watchGrpcStream
+	// stream 所有的watch复用的gRPC stream, 在第一次Watch时创建, 异常退出后置为nil
+	stream *watchGrpcStream
+
+	// closed 调用Close后置为true, 不再允许Watch
+	closed bool
+
+	cfg *WatcherConfig
 
 	// log
 	lg *zap.Logger
 }
 
 func NewWatcher(logFilename string, logLevel zapcore.Level, c *grpclient.GrpcClient) *Watcher {
+	return NewWatcherWithConfig(&WatcherConfig{
+		LogFilename: logFilename,
+		LogLevel:    logLevel,
+	}, c)
+}
+
+func NewWatcherWithConfig(cfg *WatcherConfig, c *grpclient.GrpcClient) *Watcher {
 	w := &Watcher{
-		remote:  pb.NewWatchRPCClient(c.Conn),
-		streams: make(map[string]*watchGrpcStream),
-		lg:      newLogger(logFilename, logLevel),
+		remote: pb.NewWatchRPCClient(c.Conn),
+		cfg:    cfg,
+		lg:     newLogger(cfg.LogFilename, cfg.LogLevel),
 	}
 
 	if c != nil {
@@ -46,21 +58,24 @@ func NewWatcher(logFilename string, logLevel zapcore.Level, c *grpclient.GrpcCli
 	return w
 }
 
-func (w *Watcher) newWatcherGrpcStream(inctx context.Context, watchID string, initReq watchStreamRequest) *watchGrpcStream {
-	ctx, cancel := context.WithCancel(inctx)
+func (w *Watcher) newWatcherGrpcStream() *watchGrpcStream {
+	ctx, cancel := context.WithCancel(context.Background())
 	wgs := &watchGrpcStream{
-		owner:    w,
-		remote:   w.remote,
-		callOpts: w.callOpts,
-		watchID:  watchID,
-		initReq:  initReq,
-		ctx:      ctx,
-		cancel:   cancel,
-		reqc:     make(chan watchStreamRequest),
-		respc:    make(chan *pb.WatchResponse),
-		donec:    make(chan struct{}),
-		errc:     make(chan error, 1),
-		lg:       w.lg,
+		owner:           w,
+		remote:          w.remote,
+		callOpts:        w.callOpts,
+		ctx:             ctx,
+		cancel:          cancel,
+		progressTimeout: w.cfg.ProgressNotifyTimeout,
+		backoffPolicy:   w.cfg.Backoff,
+		substreams:      make(map[string]*watcherStream),
+		reqc:            make(chan watchStreamRequest),
+		respc:           make(chan *streamResponse),
+		closingc:        make(chan *watcherStream),
+		stopc:           make(chan struct{}),
+		donec:           make(chan struct{}),
+		errc:            make(chan *streamError, 1),
+		lg:              w.lg,
 	}
 
 	go wgs.run()
@@ -71,89 +86,146 @@ func (w *Watcher) newWatcherGrpcStream(inctx context.Context, watchID string, in
 //Close Watch 客户端程序退出，主动断开所有的watch连接
 func (w *Watcher) Close() {
 	w.mu.Lock()
-	streams := w.streams
-	w.streams = nil
+	wgs := w.stream
+	w.stream = nil
+	w.closed = true
 	w.mu.Unlock()
 
-	for watchID, wgs := range streams {
-		wr := &watchCancelRequest{
-			watchID: watchID,
-		}
-		wgs.reqc <- wr
+	// 关闭gRPC stream后，服务端会取消该stream上所有的watch
+	if wgs != nil {
 		wgs.close()
 	}
 
 	w.lg.Info("watcher close")
 }
 
-//CloseStream 业务主动断开某个watch的连接
+//CloseStream 业务主动断开某个watch的连接, 服务端确认取消后，watch的channel会收到canceled响应并关闭
 func (w *Watcher) CloseStream(watchID string) {
 	wr := &watchCancelRequest{
 		watchID: watchID,
 	}
 
 	w.mu.Lock()
-	if wgs, ok := w.streams[watchID]; ok {
-		wgs.reqc <- wr
-		wgs.close()
+	wgs := w.stream
+	w.mu.Unlock()
+
+	if wgs == nil {
+		return
+	}
+
+	select {
+	case wgs.reqc <- wr:
+	case <-wgs.donec:
 	}
-	delete(w.streams, watchID)
+}
+
+// RequestProgress 请求服务端立即推送一次progress notify, 所有watch的revision都会更新为服务端当前的revision
+func (w *Watcher) RequestProgress(ctx context.Context) error {
+	w.mu.Lock()
+	wgs := w.stream
 	w.mu.Unlock()
+
+	if wgs == nil {
+		return nil
+	}
+
+	select {
+	case wgs.reqc <- &watchProgressRequest{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-wgs.donec:
+		return nil
+	}
 }
 
+// RequestSnapshot 请求服务端立即推送增量模式watch的完整快照，非增量模式的watch每个事件都是完整的列表
+func (w *Watcher) RequestSnapshot(ctx context.Context, watchID string) error {
+	w.mu.Lock()
+	wgs := w.stream
+	w.mu.Unlock()
+
+	if wgs == nil {
+		return nil
+	}
+
+	select {
+	case wgs.reqc <- &watchSnapshotRequest{watchID: watchID}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-wgs.donec:
+		return nil
+	}
+}
+
+// closeStream gRPC stream退出后调用，下一次Watch时会重新创建
 func (w *Watcher) closeStream(wgs *watchGrpcStream) {
 	w.mu.Lock()
-	close(wgs.donec)
-	wgs.cancel()
-	if w.streams != nil {
-		delete(w.streams, wgs.watchID)
+	if w.stream == wgs {
+		w.stream = nil
 	}
 	w.mu.Unlock()
+	wgs.cancel()
 }
 
-//Watch 发起watch请求
-func (w *Watcher) Watch(ctx context.Context, watchID string, app *pb.App) chan *pb.WatchResponse {
+//Watch 发起watch请求, 同一个Watcher的所有watch复用一个gRPC stream, 通过watchID区分
+//opts可以设置服务端的事件过滤条件，ctx结束或者调用CloseStream后，返回的channel会被关闭，
+//重连超过回退策略的上限时返回的channel收到携带原因的canceled响应后关闭
+func (w *Watcher) Watch(ctx context.Context, watchID string, app *pb.App, opts ...WatchOption) chan *pb.WatchResponse {
 	wr := &watchCreateRequest{
+		ctx:     ctx,
 		watchID: watchID,
 		app:     app,
+		retc:    make(chan chan *pb.WatchResponse, 1),
+	}
+	for _, opt := range opts {
+		opt(wr)
 	}
 
 	w.mu.Lock()
-	if w.streams == nil {
+	if w.closed {
 		w.mu.Unlock()
 		ch := make(chan *pb.WatchResponse)
 		close(ch)
 		return ch
 	}
 
-	// 这里处理不可以重复watch
-	wgs := w.streams[watchID]
-	if wgs != nil {
-		w.mu.Unlock()
-		ch := make(chan *pb.WatchResponse)
-		close(ch)
-		return ch
+	wgs := w.stream
+	if wgs == nil {
+		wgs = w.newWatcherGrpcStream()
+		w.stream = wgs
 	}
-
-	wgs = w.newWatcherGrpcStream(ctx, watchID, wr)
-	w.streams[watchID] = wgs
 	w.mu.Unlock()
 
 	ok := false
 
-	// 阻塞等待连接服务端成功，除非调用方主动结束，否则一直重试
+	// 阻塞等待连接服务端成功，直到调用方主动结束或者重连超过回退策略的上限，
+	// stream退出后返回的channel推送canceled响应后关闭，只有再次调用Watch才会新建stream
 	select {
 	// 连接成功后，发送watch request
 	case wgs.reqc <- wr:
 		ok = true
 	case <-ctx.Done():
 	case <-wgs.donec:
-		return w.Watch(ctx, watchID, app)
+		return wgs.closedWatchChan(wr)
 	}
 
 	// 将watch response channel交给调用方处理
 	if ok {
-		return wgs.respc
+		select {
+		case ret := <-wr.retc:
+			return ret
+		case <-ctx.Done():
+		case <-wgs.donec:
+			// watch已经创建，退出原因会推送到它的channel
+			select {
+			case ret := <-wr.retc:
+				return ret
+			default:
+			}
+			return wgs.closedWatchChan(wr)
+		}
 	}
 
 	// couldn't create channel; return closed channel
@@ -181,20 +253,3 @@ func isHaltErr(ctx context.Context, err error) bool {
 	// TODO: are permanent Internal errors possible from grpc?
 	return ev.Code() != codes.Unavailable && ev.Code() != codes.Internal
 }
-
-// isUnavailableErr returns true if the given error is an unavailable error
-func isUnavailableErr(ctx context.Context, err error) bool {
-	if ctx != nil && ctx.Err() != nil {
-		return false
-	}
-	if err == nil {
-		return false
-	}
-	ev, ok := status.FromError(err)
-	if ok {
-		// Unavailable codes mean the system will be right back.
-		// (e.g., can't connect, lost leader)
-		return ev.Code() == codes.Unavailable
-	}
-	return false
-}
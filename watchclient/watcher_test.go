@@ -0,0 +1,73 @@
+package watchclient
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xkeyideal/grpcwatch/grpclient"
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestWatcher creates a Watcher of a client of endpoints, its logs are discarded.
+func newTestWatcher(t *testing.T, cfg *WatcherConfig, endpoints []string, dopts ...grpc.DialOption) *Watcher {
+	t.Helper()
+
+	cli, err := grpclient.NewGRPCClient(&grpclient.GrpcClientConfig{Endpoints: endpoints, DialOptions: dopts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+
+	cfg.LogFilename = filepath.Join(t.TempDir(), "watch.log")
+	cfg.LogLevel = zapcore.FatalLevel
+	w := NewWatcherWithConfig(cfg, cli)
+	t.Cleanup(w.Close)
+	return w
+}
+
+func TestWatchGiveUp(t *testing.T) {
+	// every attempt to open the watch stream fails
+	var opened int32
+	interceptor := func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, grpc.Streamer, ...grpc.CallOption) (grpc.ClientStream, error) {
+		atomic.AddInt32(&opened, 1)
+		return nil, status.Error(codes.Unavailable, "server down")
+	}
+	w := newTestWatcher(t, &WatcherConfig{Backoff: &BackoffPolicy{Initial: time.Millisecond, MaxAttempts: 2}},
+		[]string{"127.0.0.1:1"}, grpc.WithStreamInterceptor(interceptor))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	app := &pb.App{Name: "svc", Env: "qa"}
+	for i := 1; i <= 2; i++ {
+		var resps []*pb.WatchResponse
+		for resp := range w.Watch(ctx, "w", app) {
+			resps = append(resps, resp)
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("#%d: expected Watch to give up", i)
+		}
+
+		if len(resps) != 1 || !resps[0].Canceled || !strings.Contains(resps[0].CancelReason, "server down") {
+			t.Fatalf("#%d: expected a canceled response with the reason, got %v", i, resps)
+		}
+		if resps[0].WatchId != "w" || resps[0].App.Name != app.Name {
+			t.Errorf("#%d: expected the canceled response of the watch, got %v", i, resps[0])
+		}
+
+		// the first attempt and 2 reconnects, a new stream only on the next Watch
+		time.Sleep(50 * time.Millisecond)
+		if n := atomic.LoadInt32(&opened); n != int32(3*i) {
+			t.Errorf("#%d: expected %d attempts, got %d", i, 3*i, n)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package watchpb
+
+// WatchResponse.CancelReason 的取值
+const (
+	// CancelReasonClientStop 客户端主动取消watch
+	CancelReasonClientStop = "client stop"
+
+	// CancelReasonCompacted 请求的start_revision对应的事件已被压缩，无法补发
+	CancelReasonCompacted = "compacted"
+
+	// CancelReasonDuplicateWatchID 同一个stream上已经存在相同watch_id的watch
+	CancelReasonDuplicateWatchID = "duplicate watch id"
+
+	// CancelReasonPermissionDenied 客户端的身份没有watch该app的权限
+	CancelReasonPermissionDenied = "permission denied"
+)
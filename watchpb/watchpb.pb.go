@@ -55,6 +55,37 @@ func (EventType) EnumDescriptor() ([]byte, []int) {
 	return fileDescriptor_e705afab0fb6c037, []int{0}
 }
 
+type WatchCreateRequest_FilterType int32
+
+const (
+	// 过滤CREATE事件
+	WatchCreateRequest_NOCREATE WatchCreateRequest_FilterType = 0
+	// 过滤UPDATE事件
+	WatchCreateRequest_NOUPDATE WatchCreateRequest_FilterType = 1
+	// 过滤DELETE事件
+	WatchCreateRequest_NODELETE WatchCreateRequest_FilterType = 2
+)
+
+var WatchCreateRequest_FilterType_name = map[int32]string{
+	0: "NOCREATE",
+	1: "NOUPDATE",
+	2: "NODELETE",
+}
+
+var WatchCreateRequest_FilterType_value = map[string]int32{
+	"NOCREATE": 0,
+	"NOUPDATE": 1,
+	"NODELETE": 2,
+}
+
+func (x WatchCreateRequest_FilterType) String() string {
+	return proto.EnumName(WatchCreateRequest_FilterType_name, int32(x))
+}
+
+func (WatchCreateRequest_FilterType) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_e705afab0fb6c037, []int{4, 0}
+}
+
 type Empty struct {
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
@@ -87,11 +118,13 @@ func (m *Empty) XXX_DiscardUnknown() {
 var xxx_messageInfo_Empty proto.InternalMessageInfo
 
 type GetAppResponse struct {
-	App                  *App         `protobuf:"bytes,1,opt,name=app,proto3" json:"app,omitempty"`
-	Servers              []*AppServer `protobuf:"bytes,2,rep,name=servers,proto3" json:"servers,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
-	XXX_unrecognized     []byte       `json:"-"`
-	XXX_sizecache        int32        `json:"-"`
+	App     *App         `protobuf:"bytes,1,opt,name=app,proto3" json:"app,omitempty"`
+	Servers []*AppServer `protobuf:"bytes,2,rep,name=servers,proto3" json:"servers,omitempty"`
+	// 服务器地址列表对应的revision
+	Revision             int64    `protobuf:"varint,3,opt,name=revision,proto3" json:"revision,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *GetAppResponse) Reset()         { *m = GetAppResponse{} }
@@ -133,11 +166,24 @@ func (m *GetAppResponse) GetServers() []*AppServer {
 	return nil
 }
 
+func (m *GetAppResponse) GetRevision() int64 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
 type AppServer struct {
 	// IP
 	Ip string `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
 	// PORT
-	Port                 string   `protobuf:"bytes,2,opt,name=port,proto3" json:"port,omitempty"`
+	Port string `protobuf:"bytes,2,opt,name=port,proto3" json:"port,omitempty"`
+	// 服务器的标签，例如机房、可用区等，watch时可以通过selector筛选
+	Labels map[string]string `protobuf:"bytes,3,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// 服务器的权重，客户端按照权重分配流量，为0时等同于1
+	Weight uint32 `protobuf:"varint,4,opt,name=weight,proto3" json:"weight,omitempty"`
+	// 服务器所在的可用区，客户端优先访问与自己同一可用区的服务器
+	Zone                 string   `protobuf:"bytes,5,opt,name=zone,proto3" json:"zone,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -182,6 +228,27 @@ func (m *AppServer) GetPort() string {
 	return ""
 }
 
+func (m *AppServer) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+func (m *AppServer) GetWeight() uint32 {
+	if m != nil {
+		return m.Weight
+	}
+	return 0
+}
+
+func (m *AppServer) GetZone() string {
+	if m != nil {
+		return m.Zone
+	}
+	return ""
+}
+
 type App struct {
 	// 应用名称
 	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
@@ -232,8 +299,25 @@ func (m *App) GetEnv() string {
 }
 
 type WatchCreateRequest struct {
-	WatchId              string   `protobuf:"bytes,1,opt,name=watch_id,json=watchId,proto3" json:"watch_id,omitempty"`
-	App                  *App     `protobuf:"bytes,2,opt,name=app,proto3" json:"app,omitempty"`
+	WatchId string `protobuf:"bytes,1,opt,name=watch_id,json=watchId,proto3" json:"watch_id,omitempty"`
+	App     *App   `protobuf:"bytes,2,opt,name=app,proto3" json:"app,omitempty"`
+	// 从该revision开始推送事件，用于断线重连后补发错过的事件
+	// 为0时推送当前的服务器地址列表
+	StartRevision int64 `protobuf:"varint,3,opt,name=start_revision,json=startRevision,proto3" json:"start_revision,omitempty"`
+	// 服务端不推送这些类型的事件
+	Filters []WatchCreateRequest_FilterType `protobuf:"varint,4,rep,packed,name=filters,proto3,enum=watchpb.WatchCreateRequest_FilterType" json:"filters,omitempty"`
+	// 只推送labels包含selector所有键值对的服务器地址
+	// 设置selector后，事件类型按照筛选后的服务器地址集合计算
+	Selector map[string]string `protobuf:"bytes,5,rep,name=selector,proto3" json:"selector,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// 前缀模式，app.name作为前缀匹配，为空时匹配所有app，app.env为空时匹配所有env
+	// 例如关注env=prod的所有app，或者name以payments-开头的所有app
+	// 创建成功后先推送不携带服务器地址的created响应，再为每个匹配的app推送一个CREATE事件
+	Prefix bool `protobuf:"varint,6,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	// 增量模式，事件只携带相对上一次推送的added与removed服务器地址，不携带完整的servers
+	// created响应、周期性与按需的快照携带完整的servers并设置snapshot
+	Delta bool `protobuf:"varint,7,opt,name=delta,proto3" json:"delta,omitempty"`
+	// 增量模式下每个app每推送snapshot_every个增量事件推送一次完整快照，为0时只在请求时推送
+	SnapshotEvery        uint32   `protobuf:"varint,8,opt,name=snapshot_every,json=snapshotEvery,proto3" json:"snapshot_every,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -278,6 +362,48 @@ func (m *WatchCreateRequest) GetApp() *App {
 	return nil
 }
 
+func (m *WatchCreateRequest) GetStartRevision() int64 {
+	if m != nil {
+		return m.StartRevision
+	}
+	return 0
+}
+
+func (m *WatchCreateRequest) GetFilters() []WatchCreateRequest_FilterType {
+	if m != nil {
+		return m.Filters
+	}
+	return nil
+}
+
+func (m *WatchCreateRequest) GetSelector() map[string]string {
+	if m != nil {
+		return m.Selector
+	}
+	return nil
+}
+
+func (m *WatchCreateRequest) GetPrefix() bool {
+	if m != nil {
+		return m.Prefix
+	}
+	return false
+}
+
+func (m *WatchCreateRequest) GetDelta() bool {
+	if m != nil {
+		return m.Delta
+	}
+	return false
+}
+
+func (m *WatchCreateRequest) GetSnapshotEvery() uint32 {
+	if m != nil {
+		return m.SnapshotEvery
+	}
+	return 0
+}
+
 type WatchCancelRequest struct {
 	WatchId              string   `protobuf:"bytes,1,opt,name=watch_id,json=watchId,proto3" json:"watch_id,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -317,10 +443,84 @@ func (m *WatchCancelRequest) GetWatchId() string {
 	return ""
 }
 
+// 请求服务端立即推送一次progress notify
+type WatchProgressRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WatchProgressRequest) Reset()         { *m = WatchProgressRequest{} }
+func (m *WatchProgressRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchProgressRequest) ProtoMessage()    {}
+func (*WatchProgressRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e705afab0fb6c037, []int{6}
+}
+
+func (m *WatchProgressRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchProgressRequest.Unmarshal(m, b)
+}
+func (m *WatchProgressRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchProgressRequest.Marshal(b, m, deterministic)
+}
+func (m *WatchProgressRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchProgressRequest.Merge(m, src)
+}
+func (m *WatchProgressRequest) XXX_Size() int {
+	return xxx_messageInfo_WatchProgressRequest.Size(m)
+}
+func (m *WatchProgressRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchProgressRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchProgressRequest proto.InternalMessageInfo
+
+// 请求服务端立即推送一次增量模式watch的完整快照
+type WatchSnapshotRequest struct {
+	WatchId              string   `protobuf:"bytes,1,opt,name=watch_id,json=watchId,proto3" json:"watch_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WatchSnapshotRequest) Reset()         { *m = WatchSnapshotRequest{} }
+func (m *WatchSnapshotRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchSnapshotRequest) ProtoMessage()    {}
+func (*WatchSnapshotRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e705afab0fb6c037, []int{7}
+}
+
+func (m *WatchSnapshotRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchSnapshotRequest.Unmarshal(m, b)
+}
+func (m *WatchSnapshotRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchSnapshotRequest.Marshal(b, m, deterministic)
+}
+func (m *WatchSnapshotRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchSnapshotRequest.Merge(m, src)
+}
+func (m *WatchSnapshotRequest) XXX_Size() int {
+	return xxx_messageInfo_WatchSnapshotRequest.Size(m)
+}
+func (m *WatchSnapshotRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchSnapshotRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchSnapshotRequest proto.InternalMessageInfo
+
+func (m *WatchSnapshotRequest) GetWatchId() string {
+	if m != nil {
+		return m.WatchId
+	}
+	return ""
+}
+
 type WatchRequest struct {
 	// Types that are valid to be assigned to RequestUnion:
 	//	*WatchRequest_CreateRequest
 	//	*WatchRequest_CancelRequest
+	//	*WatchRequest_ProgressRequest
+	//	*WatchRequest_SnapshotRequest
 	RequestUnion         isWatchRequest_RequestUnion `protobuf_oneof:"request_union"`
 	XXX_NoUnkeyedLiteral struct{}                    `json:"-"`
 	XXX_unrecognized     []byte                      `json:"-"`
@@ -331,7 +531,7 @@ func (m *WatchRequest) Reset()         { *m = WatchRequest{} }
 func (m *WatchRequest) String() string { return proto.CompactTextString(m) }
 func (*WatchRequest) ProtoMessage()    {}
 func (*WatchRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_e705afab0fb6c037, []int{6}
+	return fileDescriptor_e705afab0fb6c037, []int{8}
 }
 
 func (m *WatchRequest) XXX_Unmarshal(b []byte) error {
@@ -364,10 +564,22 @@ type WatchRequest_CancelRequest struct {
 	CancelRequest *WatchCancelRequest `protobuf:"bytes,2,opt,name=cancel_request,json=cancelRequest,proto3,oneof"`
 }
 
+type WatchRequest_ProgressRequest struct {
+	ProgressRequest *WatchProgressRequest `protobuf:"bytes,3,opt,name=progress_request,json=progressRequest,proto3,oneof"`
+}
+
+type WatchRequest_SnapshotRequest struct {
+	SnapshotRequest *WatchSnapshotRequest `protobuf:"bytes,4,opt,name=snapshot_request,json=snapshotRequest,proto3,oneof"`
+}
+
 func (*WatchRequest_CreateRequest) isWatchRequest_RequestUnion() {}
 
 func (*WatchRequest_CancelRequest) isWatchRequest_RequestUnion() {}
 
+func (*WatchRequest_ProgressRequest) isWatchRequest_RequestUnion() {}
+
+func (*WatchRequest_SnapshotRequest) isWatchRequest_RequestUnion() {}
+
 func (m *WatchRequest) GetRequestUnion() isWatchRequest_RequestUnion {
 	if m != nil {
 		return m.RequestUnion
@@ -389,11 +601,27 @@ func (m *WatchRequest) GetCancelRequest() *WatchCancelRequest {
 	return nil
 }
 
+func (m *WatchRequest) GetProgressRequest() *WatchProgressRequest {
+	if x, ok := m.GetRequestUnion().(*WatchRequest_ProgressRequest); ok {
+		return x.ProgressRequest
+	}
+	return nil
+}
+
+func (m *WatchRequest) GetSnapshotRequest() *WatchSnapshotRequest {
+	if x, ok := m.GetRequestUnion().(*WatchRequest_SnapshotRequest); ok {
+		return x.SnapshotRequest
+	}
+	return nil
+}
+
 // XXX_OneofWrappers is for the internal use of the proto package.
 func (*WatchRequest) XXX_OneofWrappers() []interface{} {
 	return []interface{}{
 		(*WatchRequest_CreateRequest)(nil),
 		(*WatchRequest_CancelRequest)(nil),
+		(*WatchRequest_ProgressRequest)(nil),
+		(*WatchRequest_SnapshotRequest)(nil),
 	}
 }
 
@@ -405,18 +633,33 @@ type WatchResponse struct {
 	// 用于服务端收到客户端取消连接的信号，并处理成功后的返回
 	Canceled bool `protobuf:"varint,4,opt,name=canceled,proto3" json:"canceled,omitempty"`
 	// cancel_reason indicates the reason for canceling the watcher.
-	CancelReason         string       `protobuf:"bytes,5,opt,name=cancel_reason,json=cancelReason,proto3" json:"cancel_reason,omitempty"`
-	Servers              []*AppServer `protobuf:"bytes,6,rep,name=servers,proto3" json:"servers,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
-	XXX_unrecognized     []byte       `json:"-"`
-	XXX_sizecache        int32        `json:"-"`
+	CancelReason string       `protobuf:"bytes,5,opt,name=cancel_reason,json=cancelReason,proto3" json:"cancel_reason,omitempty"`
+	Servers      []*AppServer `protobuf:"bytes,6,rep,name=servers,proto3" json:"servers,omitempty"`
+	// 事件对应的revision, 全局单调递增
+	Revision int64 `protobuf:"varint,7,opt,name=revision,proto3" json:"revision,omitempty"`
+	// start_revision已被压缩时，服务端能够补发的最小revision
+	CompactRevision int64 `protobuf:"varint,8,opt,name=compact_revision,json=compactRevision,proto3" json:"compact_revision,omitempty"`
+	// 响应所属的watch, 同一个stream上的多个watch通过watch_id区分
+	WatchId string `protobuf:"bytes,9,opt,name=watch_id,json=watchId,proto3" json:"watch_id,omitempty"`
+	// 服务端的progress notify, 不属于任何watch, 只携带服务端当前的revision
+	// 客户端据此判断stream是否存活
+	ProgressNotify bool `protobuf:"varint,10,opt,name=progress_notify,json=progressNotify,proto3" json:"progress_notify,omitempty"`
+	// 增量模式下相对上一次推送新增或者labels变化的服务器地址
+	Added []*AppServer `protobuf:"bytes,11,rep,name=added,proto3" json:"added,omitempty"`
+	// 增量模式下相对上一次推送移除的服务器地址
+	Removed []*AppServer `protobuf:"bytes,12,rep,name=removed,proto3" json:"removed,omitempty"`
+	// 增量模式下servers为完整的服务器地址列表
+	Snapshot             bool     `protobuf:"varint,13,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *WatchResponse) Reset()         { *m = WatchResponse{} }
 func (m *WatchResponse) String() string { return proto.CompactTextString(m) }
 func (*WatchResponse) ProtoMessage()    {}
 func (*WatchResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_e705afab0fb6c037, []int{7}
+	return fileDescriptor_e705afab0fb6c037, []int{9}
 }
 
 func (m *WatchResponse) XXX_Unmarshal(b []byte) error {
@@ -479,59 +722,433 @@ func (m *WatchResponse) GetServers() []*AppServer {
 	return nil
 }
 
+func (m *WatchResponse) GetRevision() int64 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
+func (m *WatchResponse) GetCompactRevision() int64 {
+	if m != nil {
+		return m.CompactRevision
+	}
+	return 0
+}
+
+func (m *WatchResponse) GetWatchId() string {
+	if m != nil {
+		return m.WatchId
+	}
+	return ""
+}
+
+func (m *WatchResponse) GetProgressNotify() bool {
+	if m != nil {
+		return m.ProgressNotify
+	}
+	return false
+}
+
+func (m *WatchResponse) GetAdded() []*AppServer {
+	if m != nil {
+		return m.Added
+	}
+	return nil
+}
+
+func (m *WatchResponse) GetRemoved() []*AppServer {
+	if m != nil {
+		return m.Removed
+	}
+	return nil
+}
+
+func (m *WatchResponse) GetSnapshot() bool {
+	if m != nil {
+		return m.Snapshot
+	}
+	return false
+}
+
+type RegisterRequest struct {
+	App    *App       `protobuf:"bytes,1,opt,name=app,proto3" json:"app,omitempty"`
+	Server *AppServer `protobuf:"bytes,2,opt,name=server,proto3" json:"server,omitempty"`
+	// 心跳超时时间，单位秒，超过该时间未收到心跳的服务器地址会被剔除
+	// 为0时使用服务端的默认值
+	Ttl                  int64    `protobuf:"varint,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RegisterRequest) Reset()         { *m = RegisterRequest{} }
+func (m *RegisterRequest) String() string { return proto.CompactTextString(m) }
+func (*RegisterRequest) ProtoMessage()    {}
+func (*RegisterRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e705afab0fb6c037, []int{10}
+}
+
+func (m *RegisterRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RegisterRequest.Unmarshal(m, b)
+}
+func (m *RegisterRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RegisterRequest.Marshal(b, m, deterministic)
+}
+func (m *RegisterRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RegisterRequest.Merge(m, src)
+}
+func (m *RegisterRequest) XXX_Size() int {
+	return xxx_messageInfo_RegisterRequest.Size(m)
+}
+func (m *RegisterRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RegisterRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RegisterRequest proto.InternalMessageInfo
+
+func (m *RegisterRequest) GetApp() *App {
+	if m != nil {
+		return m.App
+	}
+	return nil
+}
+
+func (m *RegisterRequest) GetServer() *AppServer {
+	if m != nil {
+		return m.Server
+	}
+	return nil
+}
+
+func (m *RegisterRequest) GetTtl() int64 {
+	if m != nil {
+		return m.Ttl
+	}
+	return 0
+}
+
+type RegisterResponse struct {
+	// 服务端实际采用的心跳超时时间，单位秒
+	Ttl                  int64    `protobuf:"varint,1,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RegisterResponse) Reset()         { *m = RegisterResponse{} }
+func (m *RegisterResponse) String() string { return proto.CompactTextString(m) }
+func (*RegisterResponse) ProtoMessage()    {}
+func (*RegisterResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e705afab0fb6c037, []int{11}
+}
+
+func (m *RegisterResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RegisterResponse.Unmarshal(m, b)
+}
+func (m *RegisterResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RegisterResponse.Marshal(b, m, deterministic)
+}
+func (m *RegisterResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RegisterResponse.Merge(m, src)
+}
+func (m *RegisterResponse) XXX_Size() int {
+	return xxx_messageInfo_RegisterResponse.Size(m)
+}
+func (m *RegisterResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_RegisterResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RegisterResponse proto.InternalMessageInfo
+
+func (m *RegisterResponse) GetTtl() int64 {
+	if m != nil {
+		return m.Ttl
+	}
+	return 0
+}
+
+type DeregisterRequest struct {
+	App                  *App       `protobuf:"bytes,1,opt,name=app,proto3" json:"app,omitempty"`
+	Server               *AppServer `protobuf:"bytes,2,opt,name=server,proto3" json:"server,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *DeregisterRequest) Reset()         { *m = DeregisterRequest{} }
+func (m *DeregisterRequest) String() string { return proto.CompactTextString(m) }
+func (*DeregisterRequest) ProtoMessage()    {}
+func (*DeregisterRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e705afab0fb6c037, []int{12}
+}
+
+func (m *DeregisterRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeregisterRequest.Unmarshal(m, b)
+}
+func (m *DeregisterRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeregisterRequest.Marshal(b, m, deterministic)
+}
+func (m *DeregisterRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeregisterRequest.Merge(m, src)
+}
+func (m *DeregisterRequest) XXX_Size() int {
+	return xxx_messageInfo_DeregisterRequest.Size(m)
+}
+func (m *DeregisterRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeregisterRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeregisterRequest proto.InternalMessageInfo
+
+func (m *DeregisterRequest) GetApp() *App {
+	if m != nil {
+		return m.App
+	}
+	return nil
+}
+
+func (m *DeregisterRequest) GetServer() *AppServer {
+	if m != nil {
+		return m.Server
+	}
+	return nil
+}
+
+type HeartbeatRequest struct {
+	App                  *App       `protobuf:"bytes,1,opt,name=app,proto3" json:"app,omitempty"`
+	Server               *AppServer `protobuf:"bytes,2,opt,name=server,proto3" json:"server,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *HeartbeatRequest) Reset()         { *m = HeartbeatRequest{} }
+func (m *HeartbeatRequest) String() string { return proto.CompactTextString(m) }
+func (*HeartbeatRequest) ProtoMessage()    {}
+func (*HeartbeatRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e705afab0fb6c037, []int{13}
+}
+
+func (m *HeartbeatRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HeartbeatRequest.Unmarshal(m, b)
+}
+func (m *HeartbeatRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HeartbeatRequest.Marshal(b, m, deterministic)
+}
+func (m *HeartbeatRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HeartbeatRequest.Merge(m, src)
+}
+func (m *HeartbeatRequest) XXX_Size() int {
+	return xxx_messageInfo_HeartbeatRequest.Size(m)
+}
+func (m *HeartbeatRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_HeartbeatRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HeartbeatRequest proto.InternalMessageInfo
+
+func (m *HeartbeatRequest) GetApp() *App {
+	if m != nil {
+		return m.App
+	}
+	return nil
+}
+
+func (m *HeartbeatRequest) GetServer() *AppServer {
+	if m != nil {
+		return m.Server
+	}
+	return nil
+}
+
+type HeartbeatResponse struct {
+	// 服务端实际采用的心跳超时时间，单位秒
+	Ttl                  int64    `protobuf:"varint,1,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *HeartbeatResponse) Reset()         { *m = HeartbeatResponse{} }
+func (m *HeartbeatResponse) String() string { return proto.CompactTextString(m) }
+func (*HeartbeatResponse) ProtoMessage()    {}
+func (*HeartbeatResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e705afab0fb6c037, []int{14}
+}
+
+func (m *HeartbeatResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HeartbeatResponse.Unmarshal(m, b)
+}
+func (m *HeartbeatResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HeartbeatResponse.Marshal(b, m, deterministic)
+}
+func (m *HeartbeatResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HeartbeatResponse.Merge(m, src)
+}
+func (m *HeartbeatResponse) XXX_Size() int {
+	return xxx_messageInfo_HeartbeatResponse.Size(m)
+}
+func (m *HeartbeatResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_HeartbeatResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HeartbeatResponse proto.InternalMessageInfo
+
+func (m *HeartbeatResponse) GetTtl() int64 {
+	if m != nil {
+		return m.Ttl
+	}
+	return 0
+}
+
+type MemberListResponse struct {
+	// 集群中所有服务端供客户端访问的地址，ip:port
+	Endpoints []string `protobuf:"bytes,1,rep,name=endpoints,proto3" json:"endpoints,omitempty"`
+	// 成员列表对应的revision
+	Revision             int64    `protobuf:"varint,2,opt,name=revision,proto3" json:"revision,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MemberListResponse) Reset()         { *m = MemberListResponse{} }
+func (m *MemberListResponse) String() string { return proto.CompactTextString(m) }
+func (*MemberListResponse) ProtoMessage()    {}
+func (*MemberListResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e705afab0fb6c037, []int{15}
+}
+
+func (m *MemberListResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MemberListResponse.Unmarshal(m, b)
+}
+func (m *MemberListResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MemberListResponse.Marshal(b, m, deterministic)
+}
+func (m *MemberListResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MemberListResponse.Merge(m, src)
+}
+func (m *MemberListResponse) XXX_Size() int {
+	return xxx_messageInfo_MemberListResponse.Size(m)
+}
+func (m *MemberListResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_MemberListResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MemberListResponse proto.InternalMessageInfo
+
+func (m *MemberListResponse) GetEndpoints() []string {
+	if m != nil {
+		return m.Endpoints
+	}
+	return nil
+}
+
+func (m *MemberListResponse) GetRevision() int64 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterEnum("watchpb.EventType", EventType_name, EventType_value)
+	proto.RegisterEnum("watchpb.WatchCreateRequest_FilterType", WatchCreateRequest_FilterType_name, WatchCreateRequest_FilterType_value)
 	proto.RegisterType((*Empty)(nil), "watchpb.Empty")
 	proto.RegisterType((*GetAppResponse)(nil), "watchpb.GetAppResponse")
 	proto.RegisterType((*AppServer)(nil), "watchpb.AppServer")
+	proto.RegisterMapType((map[string]string)(nil), "watchpb.AppServer.LabelsEntry")
 	proto.RegisterType((*App)(nil), "watchpb.App")
 	proto.RegisterType((*WatchCreateRequest)(nil), "watchpb.WatchCreateRequest")
+	proto.RegisterMapType((map[string]string)(nil), "watchpb.WatchCreateRequest.SelectorEntry")
 	proto.RegisterType((*WatchCancelRequest)(nil), "watchpb.WatchCancelRequest")
+	proto.RegisterType((*WatchProgressRequest)(nil), "watchpb.WatchProgressRequest")
+	proto.RegisterType((*WatchSnapshotRequest)(nil), "watchpb.WatchSnapshotRequest")
 	proto.RegisterType((*WatchRequest)(nil), "watchpb.WatchRequest")
 	proto.RegisterType((*WatchResponse)(nil), "watchpb.WatchResponse")
+	proto.RegisterType((*RegisterRequest)(nil), "watchpb.RegisterRequest")
+	proto.RegisterType((*RegisterResponse)(nil), "watchpb.RegisterResponse")
+	proto.RegisterType((*DeregisterRequest)(nil), "watchpb.DeregisterRequest")
+	proto.RegisterType((*HeartbeatRequest)(nil), "watchpb.HeartbeatRequest")
+	proto.RegisterType((*HeartbeatResponse)(nil), "watchpb.HeartbeatResponse")
+	proto.RegisterType((*MemberListResponse)(nil), "watchpb.MemberListResponse")
 }
 
 func init() { proto.RegisterFile("watchpb.proto", fileDescriptor_e705afab0fb6c037) }
 
 var fileDescriptor_e705afab0fb6c037 = []byte{
-	// 446 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x53, 0x5d, 0x8b, 0xd3, 0x40,
-	0x14, 0xdd, 0x49, 0xb6, 0x5f, 0x77, 0x9b, 0x5a, 0x2e, 0xa8, 0xb1, 0x82, 0x94, 0xf1, 0x25, 0xa8,
-	0x6c, 0xa5, 0x82, 0x0f, 0xbe, 0xd5, 0x36, 0xa8, 0x20, 0xb8, 0x8c, 0x15, 0xdf, 0x2c, 0xd9, 0xe4,
-	0x82, 0x01, 0x77, 0x32, 0x26, 0xd9, 0xca, 0xbe, 0xf8, 0x87, 0xfc, 0x57, 0xfe, 0x12, 0x99, 0x8f,
-	0x84, 0x74, 0x55, 0xfa, 0x76, 0x3f, 0xce, 0x3d, 0x39, 0xe7, 0xde, 0x0c, 0x04, 0x3f, 0x92, 0x3a,
-	0xfd, 0xaa, 0x2e, 0xcf, 0x55, 0x59, 0xd4, 0x05, 0x0e, 0x5c, 0xca, 0x07, 0xd0, 0x8b, 0xaf, 0x54,
-	0x7d, 0xc3, 0xbf, 0xc0, 0xe4, 0x0d, 0xd5, 0x2b, 0xa5, 0x04, 0x55, 0xaa, 0x90, 0x15, 0xe1, 0x23,
-	0xf0, 0x13, 0xa5, 0x42, 0x36, 0x67, 0xd1, 0xd9, 0x72, 0x7c, 0xde, 0x10, 0x68, 0x88, 0x6e, 0xe0,
-	0x33, 0x18, 0x54, 0x54, 0xee, 0xa9, 0xac, 0x42, 0x6f, 0xee, 0x47, 0x67, 0x4b, 0xec, 0x62, 0x3e,
-	0x9a, 0x96, 0x68, 0x20, 0x7c, 0x01, 0xa3, 0xb6, 0x8a, 0x13, 0xf0, 0x72, 0xcb, 0x3c, 0x12, 0x5e,
-	0xae, 0x10, 0xe1, 0x54, 0x15, 0x65, 0x1d, 0x7a, 0xa6, 0x62, 0x62, 0xfe, 0x14, 0xfc, 0x95, 0x32,
-	0x2d, 0x99, 0x5c, 0x91, 0x03, 0x9b, 0x18, 0xa7, 0xe0, 0x93, 0xdc, 0x3b, 0xb4, 0x0e, 0xf9, 0x07,
-	0xc0, 0xcf, 0xfa, 0xdb, 0xeb, 0x92, 0x92, 0x9a, 0x04, 0x7d, 0xbf, 0xa6, 0xaa, 0xc6, 0x07, 0x30,
-	0x34, 0x8a, 0x76, 0x79, 0xe6, 0xe6, 0xad, 0xef, 0x77, 0x59, 0x63, 0xce, 0xfb, 0x8f, 0x39, 0xbe,
-	0x68, 0x08, 0x13, 0x99, 0xd2, 0xb7, 0xe3, 0x84, 0xfc, 0x17, 0x83, 0xb1, 0x99, 0x68, 0xb0, 0x1b,
-	0x98, 0xa4, 0x46, 0xcd, 0xae, 0xb4, 0x15, 0xb7, 0xc9, 0x87, 0xed, 0xc7, 0xfe, 0x56, 0xfc, 0xf6,
-	0x44, 0x04, 0xe9, 0x81, 0x05, 0xcd, 0x62, 0x24, 0xb4, 0x2c, 0xde, 0x3f, 0x59, 0xba, 0x32, 0x0d,
-	0x4b, 0xb7, 0xf0, 0xfa, 0x0e, 0x04, 0x6e, 0x7c, 0x77, 0x2d, 0xf3, 0x42, 0xf2, 0xdf, 0x0c, 0x02,
-	0xa7, 0xd6, 0x5d, 0x3b, 0x82, 0x1e, 0xed, 0x49, 0x5a, 0x95, 0x93, 0xce, 0x2d, 0x63, 0x5d, 0xdd,
-	0xde, 0x28, 0x12, 0x16, 0x70, 0x6c, 0x75, 0x18, 0xc2, 0xc0, 0x7a, 0xc8, 0x42, 0x7f, 0xce, 0xa2,
-	0xa1, 0x68, 0x52, 0x9c, 0xc1, 0xd0, 0xea, 0xa2, 0x2c, 0x3c, 0x35, 0xad, 0x36, 0xc7, 0xc7, 0x10,
-	0xb4, 0x46, 0x93, 0xaa, 0x90, 0x61, 0xcf, 0xec, 0x77, 0xdc, 0x18, 0xd1, 0xb5, 0xee, 0x2f, 0xd7,
-	0x3f, 0xfa, 0xcb, 0x3d, 0x59, 0xc0, 0xa8, 0x15, 0x8f, 0x00, 0xfd, 0xb5, 0x88, 0x57, 0xdb, 0x78,
-	0x7a, 0xa2, 0xe3, 0x4f, 0x17, 0x1b, 0x1d, 0x33, 0x1d, 0x6f, 0xe2, 0xf7, 0xf1, 0x36, 0x9e, 0x7a,
-	0xcb, 0x9f, 0x30, 0xb4, 0x4b, 0xb9, 0x58, 0xe3, 0x4b, 0x08, 0xec, 0x7b, 0xb0, 0xac, 0x15, 0x1e,
-	0x38, 0x9d, 0xdd, 0x6f, 0xb3, 0x5b, 0xaf, 0xe6, 0x15, 0xf4, 0x0c, 0x07, 0xde, 0x3d, 0xbc, 0x90,
-	0x3b, 0xc5, 0xec, 0xde, 0xed, 0xb2, 0x9d, 0x8b, 0xd8, 0x73, 0x76, 0xd9, 0x37, 0x8f, 0xf3, 0xc5,
-	0x9f, 0x00, 0x00, 0x00, 0xff, 0xff, 0xc0, 0x87, 0x64, 0xe2, 0xad, 0x03, 0x00, 0x00,
+	// 990 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x56, 0x5f, 0x73, 0xdb, 0x44,
+	0x10, 0x8f, 0xa4, 0xd8, 0x96, 0x37, 0x96, 0xe3, 0xde, 0x94, 0xa0, 0x0a, 0xe8, 0x78, 0x04, 0x05,
+	0xb5, 0x30, 0x29, 0x84, 0x99, 0x4c, 0x5b, 0x1e, 0x20, 0x24, 0x2a, 0x81, 0x09, 0x21, 0xa3, 0x84,
+	0xe1, 0x0d, 0x8f, 0x6c, 0x6d, 0x12, 0x0d, 0xb6, 0x24, 0x4e, 0x17, 0x17, 0xf7, 0xeb, 0xf1, 0xc4,
+	0x0b, 0xdf, 0x81, 0xef, 0xc1, 0x03, 0x73, 0x7f, 0xf4, 0xcf, 0x71, 0x1a, 0xfa, 0x90, 0xb7, 0xdb,
+	0xd5, 0xde, 0x6f, 0x6f, 0xf7, 0xb7, 0xf7, 0xd3, 0x81, 0xf5, 0x2a, 0x64, 0x93, 0xcb, 0x6c, 0xbc,
+	0x9d, 0xd1, 0x94, 0xa5, 0xa4, 0xa3, 0x4c, 0xb7, 0x03, 0x2d, 0x7f, 0x96, 0xb1, 0x85, 0xfb, 0x1a,
+	0xfa, 0xdf, 0x21, 0xdb, 0xcb, 0xb2, 0x00, 0xf3, 0x2c, 0x4d, 0x72, 0x24, 0x0f, 0xc1, 0x08, 0xb3,
+	0xcc, 0xd6, 0x86, 0x9a, 0xb7, 0xb1, 0xd3, 0xdb, 0x2e, 0x00, 0x78, 0x08, 0xff, 0x40, 0x3e, 0x83,
+	0x4e, 0x8e, 0x74, 0x8e, 0x34, 0xb7, 0xf5, 0xa1, 0xe1, 0x6d, 0xec, 0x90, 0x7a, 0xcc, 0xa9, 0xf8,
+	0x14, 0x14, 0x21, 0xc4, 0x01, 0x93, 0xe2, 0x3c, 0xce, 0xe3, 0x34, 0xb1, 0x8d, 0xa1, 0xe6, 0x19,
+	0x41, 0x69, 0xbb, 0x7f, 0x6b, 0xd0, 0x2d, 0xb7, 0x90, 0x3e, 0xe8, 0xb1, 0x4c, 0xdb, 0x0d, 0xf4,
+	0x38, 0x23, 0x04, 0xd6, 0xb3, 0x94, 0x32, 0x5b, 0x17, 0x1e, 0xb1, 0x26, 0xbb, 0xd0, 0x9e, 0x86,
+	0x63, 0x9c, 0xe6, 0xb6, 0x21, 0x52, 0x3f, 0xbc, 0x9e, 0x7a, 0xfb, 0x48, 0x04, 0xf8, 0x09, 0xa3,
+	0x8b, 0x40, 0x45, 0x93, 0x2d, 0x68, 0xbf, 0xc2, 0xf8, 0xe2, 0x92, 0xd9, 0xeb, 0x43, 0xcd, 0xb3,
+	0x02, 0x65, 0xf1, 0x1c, 0xaf, 0xd3, 0x04, 0xed, 0x96, 0xcc, 0xc1, 0xd7, 0xce, 0x73, 0xd8, 0xa8,
+	0x41, 0x90, 0x01, 0x18, 0xbf, 0xe1, 0x42, 0x9d, 0x8b, 0x2f, 0xc9, 0x7d, 0x68, 0xcd, 0xc3, 0xe9,
+	0x15, 0xaa, 0x93, 0x49, 0xe3, 0x85, 0xfe, 0x4c, 0x73, 0x3f, 0x05, 0x63, 0x2f, 0x13, 0x27, 0x4f,
+	0xc2, 0x19, 0xaa, 0x3d, 0x62, 0xcd, 0x61, 0x30, 0x99, 0xab, 0x2d, 0x7c, 0xe9, 0xfe, 0x65, 0x00,
+	0xf9, 0x85, 0x9f, 0x7e, 0x9f, 0x62, 0xc8, 0x30, 0xc0, 0xdf, 0xaf, 0x30, 0x67, 0xe4, 0x01, 0x98,
+	0xa2, 0xa6, 0x51, 0x1c, 0x29, 0x00, 0x49, 0xda, 0xf7, 0x51, 0xc1, 0x8c, 0x7e, 0x13, 0x33, 0x8f,
+	0xa0, 0x9f, 0xb3, 0x90, 0xb2, 0xd1, 0x52, 0xc7, 0x2d, 0xe1, 0x0d, 0x94, 0x93, 0x7c, 0x03, 0x9d,
+	0xf3, 0x78, 0xca, 0x38, 0x81, 0xeb, 0x43, 0xc3, 0xeb, 0xef, 0x7c, 0x5c, 0x42, 0x5d, 0x3f, 0xcf,
+	0xf6, 0x4b, 0x11, 0x7a, 0xb6, 0xc8, 0x30, 0x28, 0xb6, 0x11, 0x1f, 0xcc, 0x1c, 0xa7, 0x38, 0x61,
+	0x29, 0xb5, 0x5b, 0x82, 0x88, 0xc7, 0x6f, 0x82, 0x38, 0x55, 0xb1, 0x92, 0x93, 0x72, 0x2b, 0x67,
+	0x25, 0xa3, 0x78, 0x1e, 0xff, 0x61, 0xb7, 0x87, 0x9a, 0x67, 0x06, 0xca, 0xe2, 0x0d, 0x8e, 0x70,
+	0xca, 0x42, 0xbb, 0x23, 0xdc, 0xd2, 0x10, 0xd5, 0x25, 0x61, 0x96, 0x5f, 0xa6, 0x6c, 0x84, 0x73,
+	0xa4, 0x0b, 0xdb, 0x14, 0x5c, 0x5a, 0x85, 0xd7, 0xe7, 0x4e, 0xe7, 0x2b, 0xb0, 0x1a, 0xf9, 0xde,
+	0x8a, 0xc0, 0x5d, 0x80, 0xaa, 0x5e, 0xd2, 0x03, 0xf3, 0xf8, 0xa7, 0xfd, 0xc0, 0xdf, 0x3b, 0xf3,
+	0x07, 0x6b, 0xd2, 0xfa, 0xf9, 0xe4, 0x80, 0x5b, 0x9a, 0xb4, 0x0e, 0xfc, 0x23, 0xff, 0xcc, 0x1f,
+	0xe8, 0xee, 0xd3, 0x82, 0xca, 0x30, 0x99, 0xe0, 0xf4, 0x76, 0x2a, 0xdd, 0x2d, 0xb8, 0x2f, 0x36,
+	0x9c, 0xd0, 0xf4, 0x82, 0x62, 0x9e, 0xab, 0x2d, 0xee, 0x17, 0xca, 0x7f, 0xaa, 0x6a, 0xfa, 0x1f,
+	0x50, 0x7f, 0xea, 0xd0, 0x13, 0x7b, 0x8a, 0xd8, 0x03, 0xe8, 0x4f, 0x44, 0xff, 0x47, 0x54, 0x7a,
+	0xd4, 0x5d, 0x7e, 0xef, 0x0d, 0x1c, 0x1d, 0xae, 0x05, 0xd6, 0xa4, 0xee, 0x10, 0x28, 0xa2, 0x9a,
+	0x12, 0x45, 0x5f, 0x89, 0x52, 0xaf, 0x58, 0xa0, 0x34, 0x5a, 0xf0, 0x03, 0x0c, 0x32, 0x55, 0x62,
+	0x89, 0x63, 0x08, 0x9c, 0x0f, 0x9a, 0x38, 0x4b, 0x8d, 0x38, 0x5c, 0x0b, 0x36, 0xb3, 0xa6, 0x8b,
+	0x63, 0x95, 0x03, 0x50, 0x60, 0xad, 0xaf, 0xc2, 0x5a, 0x6a, 0x1e, 0xc7, 0xca, 0x9b, 0xae, 0x6f,
+	0x37, 0xc1, 0x52, 0x10, 0xa3, 0xab, 0x84, 0x6b, 0xd1, 0x3f, 0x06, 0x58, 0xaa, 0x8b, 0x4a, 0x07,
+	0x3d, 0x68, 0xe1, 0x1c, 0x13, 0xd9, 0xbd, 0x7e, 0x4d, 0xe5, 0x7c, 0xee, 0x15, 0x17, 0x42, 0x06,
+	0xdc, 0x7a, 0x2f, 0x6d, 0xe8, 0xc8, 0xde, 0x46, 0xa2, 0x76, 0x33, 0x28, 0x4c, 0xae, 0x8e, 0xb2,
+	0x5f, 0x18, 0x89, 0x52, 0xcc, 0xa0, 0xb4, 0xc9, 0x87, 0x60, 0x95, 0x04, 0x84, 0x79, 0x9a, 0x28,
+	0x91, 0xea, 0x15, 0x0d, 0xe6, 0xbe, 0xba, 0x18, 0xb7, 0xdf, 0x4e, 0x8c, 0x3b, 0x4d, 0x31, 0x26,
+	0x8f, 0x61, 0x30, 0x49, 0x67, 0x59, 0x38, 0xa9, 0xc9, 0x87, 0x29, 0x62, 0x36, 0x95, 0xbf, 0x14,
+	0x90, 0xfa, 0x30, 0x76, 0x9b, 0x12, 0xf5, 0x09, 0x94, 0xb4, 0x8d, 0x92, 0x94, 0xc5, 0xe7, 0x0b,
+	0x1b, 0x44, 0x5d, 0xfd, 0xc2, 0x7d, 0x2c, 0xbc, 0xbc, 0xbb, 0x61, 0x14, 0x61, 0x64, 0x6f, 0xdc,
+	0x78, 0x6c, 0x19, 0xc0, 0x4b, 0xa4, 0x38, 0x4b, 0xe7, 0x18, 0xd9, 0xbd, 0x9b, 0x4b, 0x54, 0x21,
+	0xbc, 0xc4, 0x82, 0x6b, 0xdb, 0x92, 0x1d, 0x2d, 0x6c, 0x37, 0x85, 0xcd, 0x00, 0x2f, 0xe2, 0x9c,
+	0x21, 0x2d, 0x66, 0xea, 0xb6, 0x9f, 0xdd, 0x13, 0x68, 0xcb, 0xe6, 0x29, 0x76, 0x57, 0xe5, 0x56,
+	0x11, 0x5c, 0x68, 0x18, 0x9b, 0x2a, 0xcd, 0xe5, 0x4b, 0xf7, 0x23, 0x18, 0x54, 0x09, 0xd5, 0x58,
+	0xa9, 0x28, 0xad, 0x8a, 0x1a, 0xc1, 0xbd, 0x03, 0xa4, 0x77, 0x77, 0x30, 0xf7, 0x57, 0x18, 0x1c,
+	0x62, 0x48, 0xd9, 0x18, 0x43, 0x76, 0x17, 0xf8, 0x8f, 0xe0, 0x5e, 0x0d, 0xff, 0xc6, 0x3a, 0x8f,
+	0x81, 0xfc, 0x88, 0xb3, 0x31, 0xd2, 0xa3, 0x38, 0xaf, 0xe2, 0xde, 0x87, 0x2e, 0x26, 0x51, 0x96,
+	0xc6, 0x09, 0xcb, 0x6d, 0x6d, 0x68, 0x78, 0xdd, 0xa0, 0x72, 0x34, 0x26, 0x56, 0x6f, 0x4e, 0xec,
+	0x93, 0xa7, 0xd0, 0x2d, 0xaf, 0x22, 0x01, 0x68, 0x97, 0x4a, 0x0d, 0xd0, 0x2e, 0x75, 0x1a, 0xa0,
+	0x5d, 0xa8, 0xf4, 0xce, 0xbf, 0x3a, 0x98, 0xf2, 0x8e, 0x9f, 0xec, 0x93, 0x5d, 0xb0, 0xe4, 0xc3,
+	0xe7, 0x54, 0x5d, 0x8e, 0x46, 0x13, 0x9c, 0x77, 0x4b, 0x6b, 0xe9, 0x79, 0xf4, 0x02, 0x5a, 0x02,
+	0x83, 0xbc, 0xd3, 0x14, 0x1d, 0xd5, 0x58, 0x67, 0x6b, 0xd9, 0x2d, 0xf7, 0x79, 0xda, 0xe7, 0x1a,
+	0xf9, 0x1a, 0xcc, 0x62, 0x1e, 0x88, 0x5d, 0xc6, 0x2d, 0xcd, 0xa4, 0xf3, 0x60, 0xc5, 0x17, 0x95,
+	0xfc, 0x19, 0x40, 0x35, 0x2a, 0xc4, 0x29, 0x03, 0xaf, 0xcd, 0x8f, 0xd3, 0xaf, 0xe4, 0x8a, 0xbf,
+	0xf3, 0xc8, 0x4b, 0xe8, 0x96, 0x1c, 0x91, 0x2a, 0xc3, 0xf2, 0x5c, 0x38, 0xce, 0xaa, 0x4f, 0xb5,
+	0x12, 0x9e, 0x03, 0x54, 0x24, 0x92, 0xa5, 0x2c, 0x4e, 0xf5, 0x73, 0xb8, 0xce, 0xf4, 0xb8, 0x2d,
+	0xde, 0xa0, 0x5f, 0xfe, 0x37, 0x00, 0x0d, 0x16, 0xd0, 0x07, 0x94, 0x0a, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
 var _ context.Context
-var _ grpc.ClientConn
+var _ grpc.ClientConnInterface
 
 // This is a compile-time assertion to ensure that this generated file
 // is compatible with the grpc package it is being compiled against.
-const _ = grpc.SupportPackageIsVersion4
+const _ = grpc.SupportPackageIsVersion6
 
 // WatchRPCClient is the client API for WatchRPC service.
 //
@@ -541,13 +1158,21 @@ type WatchRPCClient interface {
 	GetAppServers(ctx context.Context, in *App, opts ...grpc.CallOption) (*GetAppResponse, error)
 	// 推送app服务器地址的变化情况
 	Watch(ctx context.Context, opts ...grpc.CallOption) (WatchRPC_WatchClient, error)
+	// 注册app的服务器地址
+	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error)
+	// 注销app的服务器地址
+	Deregister(ctx context.Context, in *DeregisterRequest, opts ...grpc.CallOption) (*Empty, error)
+	// 服务器地址的心跳续约
+	Heartbeat(ctx context.Context, opts ...grpc.CallOption) (WatchRPC_HeartbeatClient, error)
+	// 获取服务端集群的成员列表，客户端据此自动更新连接的服务端地址
+	MemberList(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*MemberListResponse, error)
 }
 
 type watchRPCClient struct {
-	cc *grpc.ClientConn
+	cc grpc.ClientConnInterface
 }
 
-func NewWatchRPCClient(cc *grpc.ClientConn) WatchRPCClient {
+func NewWatchRPCClient(cc grpc.ClientConnInterface) WatchRPCClient {
 	return &watchRPCClient{cc}
 }
 
@@ -591,12 +1216,78 @@ func (x *watchRPCWatchClient) Recv() (*WatchResponse, error) {
 	return m, nil
 }
 
+func (c *watchRPCClient) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error) {
+	out := new(RegisterResponse)
+	err := c.cc.Invoke(ctx, "/watchpb.WatchRPC/Register", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *watchRPCClient) Deregister(ctx context.Context, in *DeregisterRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/watchpb.WatchRPC/Deregister", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *watchRPCClient) Heartbeat(ctx context.Context, opts ...grpc.CallOption) (WatchRPC_HeartbeatClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_WatchRPC_serviceDesc.Streams[1], "/watchpb.WatchRPC/Heartbeat", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &watchRPCHeartbeatClient{stream}
+	return x, nil
+}
+
+type WatchRPC_HeartbeatClient interface {
+	Send(*HeartbeatRequest) error
+	Recv() (*HeartbeatResponse, error)
+	grpc.ClientStream
+}
+
+type watchRPCHeartbeatClient struct {
+	grpc.ClientStream
+}
+
+func (x *watchRPCHeartbeatClient) Send(m *HeartbeatRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *watchRPCHeartbeatClient) Recv() (*HeartbeatResponse, error) {
+	m := new(HeartbeatResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *watchRPCClient) MemberList(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*MemberListResponse, error) {
+	out := new(MemberListResponse)
+	err := c.cc.Invoke(ctx, "/watchpb.WatchRPC/MemberList", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // WatchRPCServer is the server API for WatchRPC service.
 type WatchRPCServer interface {
 	// 获取app的服务器地址
 	GetAppServers(context.Context, *App) (*GetAppResponse, error)
 	// 推送app服务器地址的变化情况
 	Watch(WatchRPC_WatchServer) error
+	// 注册app的服务器地址
+	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
+	// 注销app的服务器地址
+	Deregister(context.Context, *DeregisterRequest) (*Empty, error)
+	// 服务器地址的心跳续约
+	Heartbeat(WatchRPC_HeartbeatServer) error
+	// 获取服务端集群的成员列表，客户端据此自动更新连接的服务端地址
+	MemberList(context.Context, *Empty) (*MemberListResponse, error)
 }
 
 // UnimplementedWatchRPCServer can be embedded to have forward compatible implementations.
@@ -609,6 +1300,18 @@ func (*UnimplementedWatchRPCServer) GetAppServers(ctx context.Context, req *App)
 func (*UnimplementedWatchRPCServer) Watch(srv WatchRPC_WatchServer) error {
 	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
 }
+func (*UnimplementedWatchRPCServer) Register(ctx context.Context, req *RegisterRequest) (*RegisterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (*UnimplementedWatchRPCServer) Deregister(ctx context.Context, req *DeregisterRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Deregister not implemented")
+}
+func (*UnimplementedWatchRPCServer) Heartbeat(srv WatchRPC_HeartbeatServer) error {
+	return status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (*UnimplementedWatchRPCServer) MemberList(ctx context.Context, req *Empty) (*MemberListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MemberList not implemented")
+}
 
 func RegisterWatchRPCServer(s *grpc.Server, srv WatchRPCServer) {
 	s.RegisterService(&_WatchRPC_serviceDesc, srv)
@@ -658,6 +1361,86 @@ func (x *watchRPCWatchServer) Recv() (*WatchRequest, error) {
 	return m, nil
 }
 
+func _WatchRPC_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatchRPCServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/watchpb.WatchRPC/Register",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatchRPCServer).Register(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WatchRPC_Deregister_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeregisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatchRPCServer).Deregister(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/watchpb.WatchRPC/Deregister",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatchRPCServer).Deregister(ctx, req.(*DeregisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WatchRPC_Heartbeat_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(WatchRPCServer).Heartbeat(&watchRPCHeartbeatServer{stream})
+}
+
+type WatchRPC_HeartbeatServer interface {
+	Send(*HeartbeatResponse) error
+	Recv() (*HeartbeatRequest, error)
+	grpc.ServerStream
+}
+
+type watchRPCHeartbeatServer struct {
+	grpc.ServerStream
+}
+
+func (x *watchRPCHeartbeatServer) Send(m *HeartbeatResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *watchRPCHeartbeatServer) Recv() (*HeartbeatRequest, error) {
+	m := new(HeartbeatRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _WatchRPC_MemberList_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatchRPCServer).MemberList(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/watchpb.WatchRPC/MemberList",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatchRPCServer).MemberList(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _WatchRPC_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "watchpb.WatchRPC",
 	HandlerType: (*WatchRPCServer)(nil),
@@ -666,6 +1449,18 @@ var _WatchRPC_serviceDesc = grpc.ServiceDesc{
 			MethodName: "GetAppServers",
 			Handler:    _WatchRPC_GetAppServers_Handler,
 		},
+		{
+			MethodName: "Register",
+			Handler:    _WatchRPC_Register_Handler,
+		},
+		{
+			MethodName: "Deregister",
+			Handler:    _WatchRPC_Deregister_Handler,
+		},
+		{
+			MethodName: "MemberList",
+			Handler:    _WatchRPC_MemberList_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -674,6 +1469,12 @@ var _WatchRPC_serviceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "Heartbeat",
+			Handler:       _WatchRPC_Heartbeat_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "watchpb.proto",
 }
@@ -0,0 +1,139 @@
+package watchserver
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrTokenMissing = errors.New("watchserver: auth token missing")
+	ErrTokenInvalid = errors.New("watchserver: auth token invalid")
+)
+
+// 不需要认证的方法前缀，健康检查需要在认证之前可用
+var authExemptPrefixes = []string{"/grpc.health.v1.Health/"}
+
+// Authenticator 校验客户端通过"authorization: Bearer <token>" metadata携带的token，返回token对应的身份
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (identity string, err error)
+}
+
+// TokenAuthenticator 基于静态token表的Authenticator，key为token，value为身份
+type TokenAuthenticator map[string]string
+
+func (ta TokenAuthenticator) Authenticate(ctx context.Context, token string) (string, error) {
+	identity, ok := ta[token]
+	if !ok {
+		return "", ErrTokenInvalid
+	}
+	return identity, nil
+}
+
+// Authorizer 判断身份是否有权限获取、watch app的服务器地址
+type Authorizer interface {
+	Authorize(identity string, app *pb.App) bool
+}
+
+// ACLRule 一条授权规则，字段为空或者"*"时匹配所有，Name以"*"结尾时按照前缀匹配app名称
+type ACLRule struct {
+	Identity string
+	Name     string
+	Env      string
+}
+
+func (r ACLRule) match(identity string, app *pb.App) bool {
+	return matchPattern(r.Identity, identity) && matchPattern(r.Name, app.Name) && matchPattern(r.Env, app.Env)
+}
+
+func matchPattern(pattern, s string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(s, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == s
+}
+
+// ACL 由ACLRule组成的Authorizer，满足任意一条规则即有权限
+type ACL []ACLRule
+
+func (acl ACL) Authorize(identity string, app *pb.App) bool {
+	for _, rule := range acl {
+		if rule.match(identity, app) {
+			return true
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+// IdentityFromContext 返回Authenticator认证通过的身份
+func IdentityFromContext(ctx context.Context) (string, bool) {
+	identity, ok := ctx.Value(identityKey{}).(string)
+	return identity, ok
+}
+
+// authenticate 校验ctx中携带的token，返回携带身份的ctx
+func authenticate(ctx context.Context, auth Authenticator, method string) (context.Context, error) {
+	for _, prefix := range authExemptPrefixes {
+		if strings.HasPrefix(method, prefix) {
+			return ctx, nil
+		}
+	}
+
+	token := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vs := md.Get("authorization"); len(vs) > 0 {
+			token = strings.TrimPrefix(vs[0], "Bearer ")
+		}
+	}
+	if token == "" {
+		return nil, status.Error(codes.Unauthenticated, ErrTokenMissing.Error())
+	}
+
+	identity, err := auth.Authenticate(ctx, token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return context.WithValue(ctx, identityKey{}, identity), nil
+}
+
+func authUnaryInterceptor(auth Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, auth, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func authStreamInterceptor(auth Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), auth, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authServerStream 将携带身份的ctx传递给stream handler
+type authServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss *authServerStream) Context() context.Context {
+	return ss.ctx
+}
@@ -0,0 +1,237 @@
+package watchserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestACLAuthorize(t *testing.T) {
+	acl := ACL{
+		{Identity: "admin"},
+		{Identity: "alice", Name: "svc-*", Env: "qa"},
+		{Identity: "*", Name: "public", Env: "*"},
+	}
+
+	tests := []struct {
+		identity string
+		app      *pb.App
+
+		expected bool
+	}{
+		{"admin", &pb.App{Name: "svc", Env: "prod"}, true},
+		{"alice", &pb.App{Name: "svc-a", Env: "qa"}, true},
+		{"alice", &pb.App{Name: "svc-", Env: "qa"}, true},
+		{"alice", &pb.App{Name: "svc", Env: "qa"}, false},
+		{"alice", &pb.App{Name: "svc-a", Env: "prod"}, false},
+		{"bob", &pb.App{Name: "svc-a", Env: "qa"}, false},
+		{"bob", &pb.App{Name: "public", Env: "prod"}, true},
+		{"", &pb.App{Name: "public", Env: "qa"}, true},
+	}
+
+	for i, tt := range tests {
+		if ok := acl.Authorize(tt.identity, tt.app); ok != tt.expected {
+			t.Errorf("#%d: expected %s to be authorized for %v %v, got %v", i, tt.identity, tt.app, tt.expected, ok)
+		}
+	}
+
+	if (ACL{}).Authorize("admin", &pb.App{Name: "svc", Env: "qa"}) {
+		t.Error("expected an empty ACL to deny everything")
+	}
+}
+
+// newAuthServer serves a watch server authenticating the tokens of TokenAuthenticator
+// and authorizing acl, it returns a connection to the server and its registry.
+func newAuthServer(t *testing.T, acl ACL) (*grpc.ClientConn, Registry) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := TokenAuthenticator{"alice-token": "alice", "admin-token": "admin"}
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(authUnaryInterceptor(auth)),
+		grpc.ChainStreamInterceptor(authStreamInterceptor(auth)),
+	)
+	registry := NewMemoryRegistry()
+	pb.RegisterWatchRPCServer(server, NewWatchRpcServer(&GrpcServerConfig{Authorizer: acl}, zap.NewNop(), registry))
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(server, hs)
+	go server.Serve(l)
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+		registry.Close()
+	})
+	return conn, registry
+}
+
+func withToken(ctx context.Context, token string) context.Context {
+	if token == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+// watchFirst creates the watch of req on a new stream and returns its first response.
+func watchFirst(ctx context.Context, remote pb.WatchRPCClient, req *pb.WatchCreateRequest) (*pb.WatchResponse, error) {
+	stream, err := remote.Watch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(&pb.WatchRequest{RequestUnion: &pb.WatchRequest_CreateRequest{CreateRequest: req}}); err != nil {
+		return nil, err
+	}
+	return stream.Recv()
+}
+
+func TestAuthenticate(t *testing.T) {
+	conn, _ := newAuthServer(t, ACL{{Identity: "*"}})
+	remote := pb.NewWatchRPCClient(conn)
+	app := &pb.App{Name: "svc", Env: "qa"}
+
+	tests := []struct {
+		token string
+
+		code codes.Code
+	}{
+		{"", codes.Unauthenticated},
+		{"unknown-token", codes.Unauthenticated},
+		{"alice-token", codes.OK},
+	}
+
+	for i, tt := range tests {
+		ctx, cancel := context.WithTimeout(withToken(context.Background(), tt.token), 5*time.Second)
+
+		if _, err := remote.GetAppServers(ctx, app); status.Code(err) != tt.code {
+			t.Errorf("#%d: expected GetAppServers to return %v, got %v", i, tt.code, err)
+		}
+		if _, err := watchFirst(ctx, remote, &pb.WatchCreateRequest{WatchId: "w", App: app}); status.Code(err) != tt.code {
+			t.Errorf("#%d: expected Watch to return %v, got %v", i, tt.code, err)
+		}
+		if _, err := remote.MemberList(ctx, &pb.Empty{}); status.Code(err) != tt.code {
+			t.Errorf("#%d: expected MemberList to return %v, got %v", i, tt.code, err)
+		}
+
+		// the health check needs no token
+		if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+			t.Errorf("#%d: expected the health check to be exempt, got %v", i, err)
+		}
+		cancel()
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	conn, registry := newAuthServer(t, ACL{{Identity: "admin"}, {Identity: "alice", Name: "svc-a", Env: "qa"}})
+	remote := pb.NewWatchRPCClient(conn)
+
+	a, b := &pb.App{Name: "svc-a", Env: "qa"}, &pb.App{Name: "svc-b", Env: "qa"}
+	for _, app := range []*pb.App{a, b} {
+		if err := registry.Register(app, server("10.0.0.1"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		token string
+		app   *pb.App
+
+		allowed bool
+	}{
+		{"alice-token", a, true},
+		{"alice-token", b, false},
+		{"admin-token", b, true},
+	}
+
+	for i, tt := range tests {
+		ctx, cancel := context.WithTimeout(withToken(context.Background(), tt.token), 5*time.Second)
+
+		code := codes.PermissionDenied
+		if tt.allowed {
+			code = codes.OK
+		}
+		if _, err := remote.GetAppServers(ctx, tt.app); status.Code(err) != code {
+			t.Errorf("#%d: expected GetAppServers to return %v, got %v", i, code, err)
+		}
+		if _, err := remote.Register(ctx, &pb.RegisterRequest{App: tt.app, Server: server("10.0.0.2")}); status.Code(err) != code {
+			t.Errorf("#%d: expected Register to return %v, got %v", i, code, err)
+		}
+		if _, err := remote.Deregister(ctx, &pb.DeregisterRequest{App: tt.app, Server: server("10.0.0.2")}); status.Code(err) != code {
+			t.Errorf("#%d: expected Deregister to return %v, got %v", i, code, err)
+		}
+
+		hb, err := remote.Heartbeat(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := hb.Send(&pb.HeartbeatRequest{App: tt.app, Server: server("10.0.0.1")}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := hb.Recv(); status.Code(err) != code {
+			t.Errorf("#%d: expected Heartbeat to return %v, got %v", i, code, err)
+		}
+
+		resp, err := watchFirst(ctx, remote, &pb.WatchCreateRequest{WatchId: "w", App: tt.app})
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if denied := resp.Canceled && resp.CancelReason == pb.CancelReasonPermissionDenied; denied == tt.allowed {
+			t.Errorf("#%d: expected the watch to be denied %v, got %v", i, !tt.allowed, resp)
+		}
+		cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(withToken(context.Background(), "admin-token"), 5*time.Second)
+	defer cancel()
+
+	// MemberApp is only written by the servers themselves
+	if _, err := remote.Register(ctx, &pb.RegisterRequest{App: MemberApp, Server: server("10.0.0.2")}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected registering a member to return %v, got %v", codes.PermissionDenied, err)
+	}
+
+	// a prefix watch only sees the permitted apps
+	ctx, cancel = context.WithTimeout(withToken(context.Background(), "alice-token"), 5*time.Second)
+	defer cancel()
+
+	stream, err := remote.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&pb.WatchRequest{RequestUnion: &pb.WatchRequest_CreateRequest{
+		CreateRequest: &pb.WatchCreateRequest{WatchId: "w", App: &pb.App{Name: "svc-"}, Prefix: true},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := stream.Recv(); err != nil || !resp.Created {
+		t.Fatalf("expected the prefix watch to be created, got %v (%v)", resp, err)
+	}
+	if resp, err := stream.Recv(); err != nil || resp.GetApp().GetName() != a.Name {
+		t.Fatalf("expected a CREATE event of %v, got %v (%v)", a, resp, err)
+	}
+
+	// the events of svc-b are not sent either
+	registry.Register(b, server("10.0.0.3"), 0)
+	registry.Register(a, server("10.0.0.3"), 0)
+	if resp, err := stream.Recv(); err != nil || resp.GetApp().GetName() != a.Name || resp.Event != pb.EventType_UPDATE {
+		t.Errorf("expected an UPDATE event of %v, got %v (%v)", a, resp, err)
+	}
+}
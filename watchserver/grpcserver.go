@@ -1,15 +1,21 @@
 package watchserver
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/xkeyideal/grpcwatch/transport"
 	pb "github.com/xkeyideal/grpcwatch/watchpb"
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
 )
 
@@ -19,6 +25,12 @@ const (
 	maxSendBytes      = math.MaxInt32
 )
 
+// HealthServiceName WatchRPC服务在grpc.health.v1健康检查中的服务名称
+const HealthServiceName = "watchpb.WatchRPC"
+
+// 服务端停止时等待进行中的请求结束的最长时间，超过后强制关闭所有连接（watch stream不会主动结束）
+var shutdownGrace = 5 * time.Second
+
 type GrpcServerConfig struct {
 	Port                  uint
 	MaxConnectionIdle     uint32
@@ -32,6 +44,49 @@ type GrpcServerConfig struct {
 	MaxRecvMsgSize        int
 	MaxSendMsgSize        int
 	MaxConcurrentStreams  uint32
+
+	// RegisterTTL 注册请求未携带ttl时采用的心跳超时时间，单位秒，为0时永不过期
+	RegisterTTL uint32
+
+	// ProgressNotifyInterval watch stream定时推送progress notify的时间间隔，单位秒，为0时不推送
+	ProgressNotifyInterval uint32
+
+	// Registry 服务注册中心，为nil时使用内存实现
+	Registry Registry
+
+	// AdvertiseAddr 客户端访问本服务端的地址，ip:port，设置后服务端将自己注册为集群成员(MemberApp)，
+	// 客户端通过MemberList获取共享同一个Registry的所有服务端，为空时MemberList只返回空列表
+	AdvertiseAddr string
+
+	// TLS 为nil时不加密，证书文件变化后新的连接自动使用新证书，
+	// 设置ClientCertAuth时要求客户端提供TrustedCAFile签发的证书(mTLS)
+	TLS *transport.TLSInfo
+
+	// Authenticator 为nil时不认证，否则除健康检查外的请求都需要携带token，认证失败返回Unauthenticated
+	Authenticator Authenticator
+
+	// Authorizer 为nil时不鉴权，否则GetAppServers与Watch只能访问有权限的app，
+	// 无权限时GetAppServers返回PermissionDenied，watch被取消并且cancel reason为permission denied，
+	// 前缀模式的watch只推送有权限的app
+	Authorizer Authorizer
+
+	// WriteAuthorizer Register/Deregister/Heartbeat的鉴权，只能注册、注销、续约有权限的app，无权限时返回PermissionDenied；
+	// 为nil时使用Authorizer。无论是否鉴权，MemberApp都只能由服务端自身写入
+	WriteAuthorizer Authorizer
+
+	// Context 结束时停止服务端，NewGrpcServer返回nil；为nil时服务端一直运行
+	Context context.Context
+
+	// ShutdownDrain 停止时先将健康检查置为NOT_SERVING并注销集群成员，等待该时间（单位秒）让客户端的balancer摘除本服务端，
+	// 之后再停止接收新的请求
+	ShutdownDrain uint32
+}
+
+func (cfg *GrpcServerConfig) writeAuthorizer() Authorizer {
+	if cfg.WriteAuthorizer != nil {
+		return cfg.WriteAuthorizer
+	}
+	return cfg.Authorizer
 }
 
 func NewGrpcServer(cfg *GrpcServerConfig, lg *zap.Logger) error {
@@ -59,6 +114,21 @@ func NewGrpcServer(cfg *GrpcServerConfig, lg *zap.Logger) error {
 	gopts = append(gopts, grpc.MaxSendMsgSize(cfg.MaxSendMsgSize))
 	gopts = append(gopts, grpc.MaxConcurrentStreams(cfg.MaxConcurrentStreams))
 
+	if cfg.Authenticator != nil {
+		gopts = append(gopts,
+			grpc.ChainUnaryInterceptor(authUnaryInterceptor(cfg.Authenticator)),
+			grpc.ChainStreamInterceptor(authStreamInterceptor(cfg.Authenticator)),
+		)
+	}
+
+	if cfg.TLS != nil {
+		tlsCfg, err := cfg.TLS.ServerConfig()
+		if err != nil {
+			return err
+		}
+		gopts = append(gopts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+
 	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", cfg.Port))
 	if err != nil {
 		return err
@@ -66,9 +136,67 @@ func NewGrpcServer(cfg *GrpcServerConfig, lg *zap.Logger) error {
 
 	server := grpc.NewServer(gopts...)
 
-	s := NewWatchRpcServer(lg, newWatcherStore())
+	registry := cfg.Registry
+	if registry == nil {
+		registry = NewMemoryRegistry()
+	}
+
+	s := NewWatchRpcServer(cfg, lg, registry)
 
 	pb.RegisterWatchRPCServer(server, s)
 
+	// grpc.health.v1健康检查，客户端据此只向可用的服务端发送请求
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(server, hs)
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus(HealthServiceName, healthpb.HealthCheckResponse_SERVING)
+
+	stopc := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() { stopOnce.Do(func() { close(stopc) }) }
+	defer stop()
+
+	if cfg.AdvertiseAddr != "" {
+		go keepMember(registry, cfg.AdvertiseAddr, lg, stopc)
+	}
+
+	if cfg.Context != nil {
+		donec := make(chan struct{})
+		defer close(donec)
+		go func() {
+			select {
+			case <-cfg.Context.Done():
+			case <-donec:
+				return
+			}
+			shutdown(server, hs, time.Duration(cfg.ShutdownDrain)*time.Second, stop, lg)
+		}()
+	}
+
 	return server.Serve(listener)
 }
+
+// shutdown 将健康检查置为NOT_SERVING并注销集群成员，客户端摘除本服务端后优雅停止，
+// 超过shutdownGrace仍有请求未结束时强制停止
+func shutdown(server *grpc.Server, hs *health.Server, drain time.Duration, stopMember func(), lg *zap.Logger) {
+	lg.Info("watch server shutting down", zap.Duration("drain", drain))
+
+	hs.Shutdown()
+	stopMember()
+	time.Sleep(drain)
+
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(shutdownGrace)
+	defer timer.Stop()
+
+	select {
+	case <-stopped:
+	case <-timer.C:
+		server.Stop()
+	}
+}
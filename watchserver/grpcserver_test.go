@@ -0,0 +1,159 @@
+package watchserver
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func freePort(t *testing.T) uint {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return uint(l.Addr().(*net.TCPAddr).Port)
+}
+
+func recvHealth(t *testing.T, stream healthpb.Health_WatchClient) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Status
+}
+
+// waitMembers waits until MemberList of remote returns the members.
+func waitMembers(t *testing.T, remote pb.WatchRPCClient, members ...string) {
+	t.Helper()
+
+	var endpoints []string
+	for i := 0; i < 200; i++ {
+		resp, err := remote.MemberList(context.Background(), &pb.Empty{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoints = resp.Endpoints
+		if len(endpoints) == len(members) && (len(members) == 0 || reflect.DeepEqual(endpoints, members)) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected members %v, got %v", members, endpoints)
+}
+
+func TestGrpcServerShutdown(t *testing.T) {
+	defer func(d time.Duration) { shutdownGrace = d }(shutdownGrace)
+	shutdownGrace = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	port := freePort(t)
+	addr := net.JoinHostPort("127.0.0.1", strconv.FormatUint(uint64(port), 10))
+	registry := NewMemoryRegistry()
+	defer registry.Close()
+
+	drain := time.Second
+	errc := make(chan error, 1)
+	go func() {
+		errc <- NewGrpcServer(&GrpcServerConfig{
+			Port:                 port,
+			WriteBufferSize:      32 << 10,
+			ReadBufferSize:       32 << 10,
+			MaxRecvMsgSize:       1 << 20,
+			MaxSendMsgSize:       1 << 20,
+			MaxConcurrentStreams: 100,
+			Registry:             registry,
+			AdvertiseAddr:        addr,
+			Context:              ctx,
+			ShutdownDrain:        uint32(drain / time.Second),
+		}, zap.NewNop())
+	}()
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	wctx, wcancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer wcancel()
+
+	health, err := healthpb.NewHealthClient(conn).Watch(wctx, &healthpb.HealthCheckRequest{Service: HealthServiceName}, grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := recvHealth(t, health); s != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected %v, got %v", healthpb.HealthCheckResponse_SERVING, s)
+	}
+
+	remote := pb.NewWatchRPCClient(conn)
+	waitMembers(t, remote, addr)
+
+	// a watch stream never ends by itself, it is closed after shutdownGrace
+	watch, err := remote.Watch(wctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := watch.Send(&pb.WatchRequest{RequestUnion: &pb.WatchRequest_CreateRequest{
+		CreateRequest: &pb.WatchCreateRequest{WatchId: "w", App: MemberApp},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := watch.Recv(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	cancel()
+
+	if s := recvHealth(t, health); s != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("expected %v, got %v", healthpb.HealthCheckResponse_NOT_SERVING, s)
+	}
+
+	// the server keeps serving while draining, but it is no longer a member
+	waitMembers(t, remote)
+
+	// the watcher store has handled the last event of the registry once the watch receives it,
+	// the tests after this one may change its settings
+	for {
+		resp, err := watch.Recv()
+		if err != nil {
+			t.Fatalf("expected the DELETE event of the member, got %v", err)
+		}
+		if resp.Event == pb.EventType_DELETE {
+			break
+		}
+	}
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("expected the server to stop without an error, got %v", err)
+		}
+	case <-time.After(drain + 5*time.Second):
+		t.Fatal("timed out waiting for the server to stop")
+	}
+	if elapsed := time.Since(start); elapsed < drain {
+		t.Errorf("expected the server to drain for %v, stopped after %v", drain, elapsed)
+	}
+
+	if _, err := watch.Recv(); err == nil {
+		t.Error("expected the watch stream to be closed")
+	}
+}
@@ -0,0 +1,59 @@
+package watchserver
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"go.uber.org/zap"
+)
+
+// MemberApp 服务端集群成员在注册中心中对应的app，共享同一个Registry的服务端互为集群成员，
+// 客户端也可以watch该app感知集群的变化
+var MemberApp = &pb.App{Name: "grpcwatch-member", Env: "grpcwatch"}
+
+// ErrMemberAppReadOnly 客户端不能通过Register/Deregister/Heartbeat写入MemberApp
+var ErrMemberAppReadOnly = errors.New("watchserver: member app is only written by the watch servers")
+
+func isMemberApp(app *pb.App) bool {
+	return app != nil && app.Name == MemberApp.Name && app.Env == MemberApp.Env
+}
+
+// 集群成员在注册中心中的心跳超时时间，服务端异常退出后超过该时间会被剔除
+var memberTTL = 10 * time.Second
+
+// keepMember 将服务端自身的地址注册为集群成员，并按照memberTTL/3的间隔续约，直到stopc关闭后注销
+func keepMember(registry Registry, advertiseAddr string, lg *zap.Logger, stopc <-chan struct{}) {
+	host, port, err := net.SplitHostPort(advertiseAddr)
+	if err != nil {
+		lg.Error("invalid advertise address", zap.String("addr", advertiseAddr), zap.Error(err))
+		return
+	}
+	member := &pb.AppServer{Ip: host, Port: port}
+
+	ticker := time.NewTicker(memberTTL / 3)
+	defer ticker.Stop()
+
+	registered := false
+	for {
+		if registered {
+			_, err = registry.KeepAlive(MemberApp, member)
+		}
+		if !registered || err == ErrAppServerNotFound {
+			err = registry.Register(MemberApp, member, memberTTL)
+		}
+		registered = err == nil
+		if err != nil {
+			lg.Warn("keep member", zap.String("addr", advertiseAddr), zap.Error(err))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stopc:
+			registry.Deregister(MemberApp, member)
+			return
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package watchserver
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"github.com/golang/protobuf/proto"
+)
+
+var (
+	ErrInvalidApp        = errors.New("watchserver: app name and env must not be empty")
+	ErrInvalidAppServer  = errors.New("watchserver: app server ip and port must not be empty")
+	ErrAppServerNotFound = errors.New("watchserver: app server not found")
+	ErrRegistryClosed    = errors.New("watchserver: registry closed")
+)
+
+// 检查服务器地址心跳是否超时的时间间隔
+var expireCheckInterval = 500 * time.Millisecond
+
+// RegistryEvent 描述某个app服务器地址集合的一次变化
+type RegistryEvent struct {
+	Type pb.EventType
+
+	App *pb.App
+
+	// 变化之后该app完整的服务器地址列表
+	Servers []*pb.AppServer
+
+	// 本次变化对应的revision，注册中心内全局单调递增
+	Revision int64
+}
+
+// Registry 服务注册中心的存储接口，watcherStore通过Events感知app服务器地址的变化，
+// 可以替换为etcd、数据库等实现
+type Registry interface {
+	// Register 注册app的一个服务器地址，ttl为心跳超时时间，
+	// 超过ttl未调用KeepAlive的服务器地址会被剔除，ttl为0时永不过期
+	Register(app *pb.App, server *pb.AppServer, ttl time.Duration) error
+
+	// Deregister 注销app的一个服务器地址
+	Deregister(app *pb.App, server *pb.AppServer) error
+
+	// KeepAlive 续约app的一个服务器地址，返回其ttl，
+	// 服务器地址不存在（未注册或已过期）时返回ErrAppServerNotFound
+	KeepAlive(app *pb.App, server *pb.AppServer) (time.Duration, error)
+
+	// List 返回app当前所有的服务器地址，以及注册中心当前的revision
+	List(app *pb.App) ([]*pb.AppServer, int64, error)
+
+	// Apps 返回当前存在服务器地址的所有app
+	Apps() []*pb.App
+
+	// Revision 返回注册中心当前的revision
+	Revision() int64
+
+	// Events 返回app服务器地址集合发生变化的事件，只有集合真正发生变化时才会产生事件
+	Events() <-chan *RegistryEvent
+
+	// Close 关闭注册中心，并关闭Events返回的channel
+	Close() error
+}
+
+type appKey struct {
+	name string
+	env  string
+}
+
+func newAppKey(app *pb.App) appKey {
+	return appKey{name: app.Name, env: app.Env}
+}
+
+func serverKey(server *pb.AppServer) string {
+	return net.JoinHostPort(server.Ip, server.Port)
+}
+
+type registryEntry struct {
+	server *pb.AppServer
+
+	ttl time.Duration
+
+	// 心跳超时的时间点，ttl为0时为零值
+	expireAt time.Time
+}
+
+func (e *registryEntry) refresh(now time.Time) {
+	if e.ttl > 0 {
+		e.expireAt = now.Add(e.ttl)
+	}
+}
+
+func (e *registryEntry) expired(now time.Time) bool {
+	return e.ttl > 0 && now.After(e.expireAt)
+}
+
+// memoryRegistry 基于内存的注册中心实现
+type memoryRegistry struct {
+	mu sync.Mutex
+
+	apps map[appKey]map[string]*registryEntry
+
+	// 每次服务器地址集合发生变化时递增，初始值为revisionEpoch
+	rev int64
+
+	eventc chan *RegistryEvent
+	closed bool
+
+	// pending 尚未发送到eventc的事件，由eventLoop按顺序发送，持有mu时不会阻塞在eventc上
+	pending  []*RegistryEvent
+	pendingc chan struct{}
+
+	stopc chan struct{}
+}
+
+// revisionEpoch 返回新建注册中心的初始revision，取进程启动时的纳秒时间戳。
+// 内存中的数据在服务端重启后丢失，如果revision从0开始，客户端重连时携带的旧start_revision可能落在新的历史中，
+// 错过重启后注册的服务器地址；以时间戳为起点时旧的start_revision总是小于等于新的起始revision，会被当作compacted处理
+func revisionEpoch() int64 {
+	return time.Now().UnixNano()
+}
+
+// NewMemoryRegistry 创建基于内存的注册中心
+func NewMemoryRegistry() Registry {
+	mr := &memoryRegistry{
+		rev:      revisionEpoch(),
+		apps:     make(map[appKey]map[string]*registryEntry),
+		eventc:   make(chan *RegistryEvent, 1024),
+		pendingc: make(chan struct{}, 1),
+		stopc:    make(chan struct{}),
+	}
+
+	go mr.expireLoop(expireCheckInterval)
+	go mr.eventLoop()
+
+	return mr
+}
+
+func (mr *memoryRegistry) Register(app *pb.App, server *pb.AppServer, ttl time.Duration) error {
+	if err := validate(app, server); err != nil {
+		return err
+	}
+
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if mr.closed {
+		return ErrRegistryClosed
+	}
+
+	key := newAppKey(app)
+	servers, ok := mr.apps[key]
+	if !ok {
+		servers = make(map[string]*registryEntry)
+		mr.apps[key] = servers
+	}
+
+	now := time.Now()
+	skey := serverKey(server)
+	if old, ok := servers[skey]; ok && proto.Equal(old.server, server) {
+		// 服务器地址没有变化，只更新ttl
+		old.ttl = ttl
+		old.refresh(now)
+		return nil
+	}
+
+	eventType := pb.EventType_UPDATE
+	if len(servers) == 0 {
+		eventType = pb.EventType_CREATE
+	}
+
+	entry := &registryEntry{
+		server: proto.Clone(server).(*pb.AppServer),
+		ttl:    ttl,
+	}
+	entry.refresh(now)
+
+	servers[skey] = entry
+	mr.notify(eventType, key, servers)
+
+	return nil
+}
+
+func (mr *memoryRegistry) KeepAlive(app *pb.App, server *pb.AppServer) (time.Duration, error) {
+	if err := validate(app, server); err != nil {
+		return 0, err
+	}
+
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if mr.closed {
+		return 0, ErrRegistryClosed
+	}
+
+	entry, ok := mr.apps[newAppKey(app)][serverKey(server)]
+	if !ok {
+		return 0, ErrAppServerNotFound
+	}
+
+	entry.refresh(time.Now())
+	return entry.ttl, nil
+}
+
+func (mr *memoryRegistry) Deregister(app *pb.App, server *pb.AppServer) error {
+	if err := validate(app, server); err != nil {
+		return err
+	}
+
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if mr.closed {
+		return ErrRegistryClosed
+	}
+
+	key := newAppKey(app)
+	servers, ok := mr.apps[key]
+	if !ok {
+		return nil
+	}
+
+	skey := serverKey(server)
+	if _, ok := servers[skey]; !ok {
+		return nil
+	}
+
+	mr.remove(key, servers, skey)
+
+	return nil
+}
+
+// expireLoop 定时剔除心跳超时的服务器地址
+func (mr *memoryRegistry) expireLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			mr.mu.Lock()
+			for key, servers := range mr.apps {
+				for skey, entry := range servers {
+					if entry.expired(now) {
+						mr.remove(key, servers, skey)
+					}
+				}
+			}
+			mr.mu.Unlock()
+		case <-mr.stopc:
+			return
+		}
+	}
+}
+
+// remove 必须在持有mu的情况下调用
+func (mr *memoryRegistry) remove(key appKey, servers map[string]*registryEntry, skey string) {
+	delete(servers, skey)
+
+	eventType := pb.EventType_UPDATE
+	if len(servers) == 0 {
+		eventType = pb.EventType_DELETE
+		delete(mr.apps, key)
+	}
+
+	mr.notify(eventType, key, servers)
+}
+
+func (mr *memoryRegistry) List(app *pb.App) ([]*pb.AppServer, int64, error) {
+	if app == nil || app.Name == "" || app.Env == "" {
+		return nil, 0, ErrInvalidApp
+	}
+
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	return copyServers(mr.apps[newAppKey(app)]), mr.rev, nil
+}
+
+func (mr *memoryRegistry) Apps() []*pb.App {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	apps := make([]*pb.App, 0, len(mr.apps))
+	for key := range mr.apps {
+		apps = append(apps, &pb.App{Name: key.name, Env: key.env})
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		if apps[i].Env != apps[j].Env {
+			return apps[i].Env < apps[j].Env
+		}
+		return apps[i].Name < apps[j].Name
+	})
+	return apps
+}
+
+func (mr *memoryRegistry) Revision() int64 {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	return mr.rev
+}
+
+func (mr *memoryRegistry) Events() <-chan *RegistryEvent {
+	return mr.eventc
+}
+
+func (mr *memoryRegistry) Close() error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if mr.closed {
+		return nil
+	}
+	mr.closed = true
+	close(mr.stopc)
+
+	return nil
+}
+
+// eventLoop 按照变更的顺序将pending中的事件发送到eventc，注册中心关闭后关闭eventc
+func (mr *memoryRegistry) eventLoop() {
+	defer close(mr.eventc)
+
+	for {
+		select {
+		case <-mr.pendingc:
+		case <-mr.stopc:
+			return
+		}
+
+		mr.mu.Lock()
+		events := mr.pending
+		mr.pending = nil
+		mr.mu.Unlock()
+
+		for _, ev := range events {
+			select {
+			case mr.eventc <- ev:
+			case <-mr.stopc:
+				return
+			}
+		}
+	}
+}
+
+// notify 必须在持有mu的情况下调用，保证事件的顺序与变更的顺序一致，不会阻塞
+func (mr *memoryRegistry) notify(eventType pb.EventType, key appKey, servers map[string]*registryEntry) {
+	mr.rev++
+	mr.pending = append(mr.pending, &RegistryEvent{
+		Revision: mr.rev,
+		Type:     eventType,
+		App: &pb.App{
+			Name: key.name,
+			Env:  key.env,
+		},
+		Servers: copyServers(servers),
+	})
+
+	select {
+	case mr.pendingc <- struct{}{}:
+	default:
+	}
+}
+
+// copyServers 按照ip:port排序返回服务器地址的拷贝，避免调用方修改注册中心内部的数据
+func copyServers(servers map[string]*registryEntry) []*pb.AppServer {
+	keys := make([]string, 0, len(servers))
+	for key := range servers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := make([]*pb.AppServer, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, proto.Clone(servers[key].server).(*pb.AppServer))
+	}
+	return list
+}
+
+func validate(app *pb.App, server *pb.AppServer) error {
+	if app == nil || app.Name == "" || app.Env == "" {
+		return ErrInvalidApp
+	}
+	if server == nil || server.Ip == "" || server.Port == "" {
+		return ErrInvalidAppServer
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package watchserver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+)
+
+func recvEvent(t *testing.T, r Registry) *RegistryEvent {
+	t.Helper()
+
+	select {
+	case ev := <-r.Events():
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a registry event")
+	}
+	return nil
+}
+
+func expectNoEvent(t *testing.T, r Registry) {
+	t.Helper()
+
+	select {
+	case ev := <-r.Events():
+		t.Fatalf("expected no event, got %v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryRegistryRegister(t *testing.T) {
+	r := NewMemoryRegistry()
+	defer r.Close()
+
+	app := &pb.App{Name: "svc", Env: "qa"}
+
+	tests := []struct {
+		op      func() error
+		event   bool
+		typ     pb.EventType
+		servers []string
+	}{
+		{func() error { return r.Register(app, server("10.0.0.1"), 0) }, true, pb.EventType_CREATE, []string{"10.0.0.1"}},
+		{func() error { return r.Register(app, server("10.0.0.2"), 0) }, true, pb.EventType_UPDATE, []string{"10.0.0.1", "10.0.0.2"}},
+		// registering the same server again only refreshes its ttl
+		{func() error { return r.Register(app, server("10.0.0.2"), time.Minute) }, false, 0, nil},
+		// a server with new labels replaces the old one
+		{func() error { return r.Register(app, server("10.0.0.2", "zone", "a"), 0) }, true, pb.EventType_UPDATE, []string{"10.0.0.1", "10.0.0.2"}},
+		{func() error { return r.Deregister(app, server("10.0.0.1")) }, true, pb.EventType_UPDATE, []string{"10.0.0.2"}},
+		// deregistering an unknown server changes nothing
+		{func() error { return r.Deregister(app, server("10.0.0.3")) }, false, 0, nil},
+		{func() error { return r.Deregister(app, server("10.0.0.2")) }, true, pb.EventType_DELETE, []string{}},
+	}
+
+	rev := r.Revision()
+	for i, tt := range tests {
+		if err := tt.op(); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if !tt.event {
+			expectNoEvent(t, r)
+			continue
+		}
+
+		ev := recvEvent(t, r)
+		if ev.Type != tt.typ || !reflect.DeepEqual(ips(ev.Servers), tt.servers) {
+			t.Errorf("#%d: expected %v %v, got %v %v", i, tt.typ, tt.servers, ev.Type, ips(ev.Servers))
+		}
+		if ev.Revision != rev+1 {
+			t.Errorf("#%d: expected revision %d, got %d", i, rev+1, ev.Revision)
+		}
+		rev = ev.Revision
+
+		servers, listRev, err := r.List(app)
+		if err != nil || listRev != rev || !reflect.DeepEqual(ips(servers), tt.servers) {
+			t.Errorf("#%d: expected to list %v at %d, got %v at %d (%v)", i, tt.servers, rev, ips(servers), listRev, err)
+		}
+	}
+
+	if apps := r.Apps(); len(apps) != 0 {
+		t.Errorf("expected no apps, got %v", apps)
+	}
+}
+
+func TestMemoryRegistryInvalid(t *testing.T) {
+	r := NewMemoryRegistry()
+
+	tests := []struct {
+		app    *pb.App
+		server *pb.AppServer
+		err    error
+	}{
+		{nil, server("10.0.0.1"), ErrInvalidApp},
+		{&pb.App{Name: "svc"}, server("10.0.0.1"), ErrInvalidApp},
+		{&pb.App{Name: "svc", Env: "qa"}, nil, ErrInvalidAppServer},
+		{&pb.App{Name: "svc", Env: "qa"}, &pb.AppServer{Ip: "10.0.0.1"}, ErrInvalidAppServer},
+	}
+
+	for i, tt := range tests {
+		if err := r.Register(tt.app, tt.server, 0); err != tt.err {
+			t.Errorf("#%d: expected %v, got %v", i, tt.err, err)
+		}
+		if err := r.Deregister(tt.app, tt.server); err != tt.err {
+			t.Errorf("#%d: expected %v, got %v", i, tt.err, err)
+		}
+		if _, err := r.KeepAlive(tt.app, tt.server); err != tt.err {
+			t.Errorf("#%d: expected %v, got %v", i, tt.err, err)
+		}
+	}
+
+	app := &pb.App{Name: "svc", Env: "qa"}
+	if _, err := r.KeepAlive(app, server("10.0.0.1")); err != ErrAppServerNotFound {
+		t.Errorf("expected %v, got %v", ErrAppServerNotFound, err)
+	}
+
+	r.Close()
+	if err := r.Register(app, server("10.0.0.1"), 0); err != ErrRegistryClosed {
+		t.Errorf("expected %v, got %v", ErrRegistryClosed, err)
+	}
+	if _, ok := <-r.Events(); ok {
+		t.Error("expected the events to be closed")
+	}
+}
+
+func TestMemoryRegistryExpire(t *testing.T) {
+	defer func(d time.Duration) { expireCheckInterval = d }(expireCheckInterval)
+	expireCheckInterval = 10 * time.Millisecond
+
+	r := NewMemoryRegistry()
+	defer r.Close()
+
+	app := &pb.App{Name: "svc", Env: "qa"}
+	ttl := 100 * time.Millisecond
+	if err := r.Register(app, server("10.0.0.1"), ttl); err != nil {
+		t.Fatal(err)
+	}
+	// ttl 0 never expires
+	if err := r.Register(app, server("10.0.0.2"), 0); err != nil {
+		t.Fatal(err)
+	}
+	recvEvent(t, r)
+	recvEvent(t, r)
+
+	// the heartbeats keep the server alive beyond its ttl
+	for i := 0; i < 6; i++ {
+		time.Sleep(ttl / 4)
+		got, err := r.KeepAlive(app, server("10.0.0.1"))
+		if err != nil || got != ttl {
+			t.Fatalf("#%d: expected ttl %v, got %v (%v)", i, ttl, got, err)
+		}
+	}
+	expectNoEvent(t, r)
+
+	ev := recvEvent(t, r)
+	if ev.Type != pb.EventType_UPDATE || !reflect.DeepEqual(ips(ev.Servers), []string{"10.0.0.2"}) {
+		t.Errorf("expected 10.0.0.1 to expire, got %v %v", ev.Type, ips(ev.Servers))
+	}
+	if _, err := r.KeepAlive(app, server("10.0.0.1")); err != ErrAppServerNotFound {
+		t.Errorf("expected %v after expiring, got %v", ErrAppServerNotFound, err)
+	}
+
+	time.Sleep(2 * ttl)
+	if servers, _, _ := r.List(app); !reflect.DeepEqual(ips(servers), []string{"10.0.0.2"}) {
+		t.Errorf("expected 10.0.0.2 never to expire, got %v", ips(servers))
+	}
+}
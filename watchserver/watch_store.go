@@ -1,107 +1,637 @@
 package watchserver
 
 import (
+	"sort"
+	"strings"
 	"sync"
-	"time"
 
 	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
 )
 
+// 每个app最多保留的历史事件个数，用于断线重连后补发错过的事件
+var maxHistoryEvents = 128
+
 type watcher struct {
-	name string
-	env  string
+	watchID string
+
+	// 前缀模式下name为app名称的前缀，name与env为空时匹配所有
+	name   string
+	env    string
+	prefix bool
+
+	// 已经推送给该watcher的最大revision, 小于等于该revision的事件不再推送
+	rev int64
+
+	// 不推送的事件类型
+	filters map[pb.EventType]struct{}
+
+	// 只推送labels包含selector所有键值对的服务器地址
+	selector map[string]string
+
+	// 每个app最近一次推送给该watcher的服务器地址列表（经过selector筛选）
+	servers map[appKey][]*pb.AppServer
+
+	// 增量模式，只推送相对上一次推送的added与removed
+	delta bool
+
+	// 增量模式下每个app每推送snapshotEvery个增量事件推送一次完整快照，为0时只在请求时推送
+	snapshotEvery uint32
+
+	// 每个app自上一次快照之后推送的增量事件个数
+	deltas map[appKey]uint32
+
+	// 事件被filters过滤后客户端无法根据增量重建完整列表，这些app下一次推送完整快照
+	resync map[appKey]struct{}
+
+	// authorize 判断是否有权限watch app，为nil时不鉴权
+	authorize func(app *pb.App) bool
 
-	ch chan<- *pb.WatchResponse
+	// sendq 所属grpc stream的发送队列
+	sendq *sendQueue
 }
 
-func newWatcher(app *pb.App, ch chan<- *pb.WatchResponse) *watcher {
-	return &watcher{
-		name: app.Name,
-		env:  app.Env,
-		ch:   ch,
+func newWatcher(req *pb.WatchCreateRequest, sendq *sendQueue) *watcher {
+	w := &watcher{
+		watchID:  req.WatchId,
+		name:     req.GetApp().GetName(),
+		env:      req.GetApp().GetEnv(),
+		prefix:   req.Prefix,
+		filters:  make(map[pb.EventType]struct{}),
+		selector: req.Selector,
+		servers:  make(map[appKey][]*pb.AppServer),
+		sendq:    sendq,
+
+		delta:         req.Delta,
+		snapshotEvery: req.SnapshotEvery,
+		deltas:        make(map[appKey]uint32),
+		resync:        make(map[appKey]struct{}),
+	}
+
+	for _, ft := range req.Filters {
+		switch ft {
+		case pb.WatchCreateRequest_NOCREATE:
+			w.filters[pb.EventType_CREATE] = struct{}{}
+		case pb.WatchCreateRequest_NOUPDATE:
+			w.filters[pb.EventType_UPDATE] = struct{}{}
+		case pb.WatchCreateRequest_NODELETE:
+			w.filters[pb.EventType_DELETE] = struct{}{}
+		}
 	}
+
+	return w
+}
+
+func (w *watcher) key() appKey {
+	return appKey{name: w.name, env: w.env}
+}
+
+func (w *watcher) app() *pb.App {
+	return &pb.App{Name: w.name, Env: w.env}
+}
+
+// match 判断app是否是该watcher关注的
+func (w *watcher) match(key appKey) bool {
+	if !w.prefix {
+		return w.name == key.name && w.env == key.env
+	}
+	return strings.HasPrefix(key.name, w.name) && (w.env == "" || w.env == key.env) && w.allowed(key)
+}
+
+// allowed 判断是否有权限watch app
+func (w *watcher) allowed(key appKey) bool {
+	return w.authorize == nil || w.authorize(&pb.App{Name: key.name, Env: key.env})
+}
+
+// send 不会阻塞，可以在持有watcherStore的锁时调用
+func (w *watcher) send(resp *pb.WatchResponse) {
+	resp.WatchId = w.watchID
+	w.sendq.push(resp)
+}
+
+// selectServers 返回labels包含selector所有键值对的服务器地址
+func (w *watcher) selectServers(servers []*pb.AppServer) []*pb.AppServer {
+	if len(w.selector) == 0 {
+		return servers
+	}
+
+	selected := make([]*pb.AppServer, 0, len(servers))
+	for _, server := range servers {
+		matched := true
+		for k, v := range w.selector {
+			if lv, ok := server.Labels[k]; !ok || lv != v {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			selected = append(selected, server)
+		}
+	}
+	return selected
+}
+
+// filter 根据selector与事件类型过滤事件，返回需要推送的响应，调用方负责设置app与revision
+// 设置selector时，筛选后的服务器地址集合没有变化则不推送，事件类型按照筛选后的集合重新计算
+func (w *watcher) filter(key appKey, eventType pb.EventType, servers []*pb.AppServer) (*pb.WatchResponse, bool) {
+	selected := w.selectServers(servers)
+	prev := w.servers[key]
+
+	if len(w.selector) > 0 {
+		if serversEqual(prev, selected) {
+			return nil, false
+		}
+
+		switch {
+		case len(selected) == 0:
+			eventType = pb.EventType_DELETE
+		case len(prev) == 0:
+			eventType = pb.EventType_CREATE
+		default:
+			eventType = pb.EventType_UPDATE
+		}
+	}
+
+	if len(selected) == 0 {
+		delete(w.servers, key)
+	} else {
+		w.servers[key] = selected
+	}
+
+	if _, ok := w.filters[eventType]; ok {
+		if w.delta {
+			w.resync[key] = struct{}{}
+		}
+		return nil, false
+	}
+	return w.response(key, eventType, prev, selected), true
+}
+
+// response 非增量模式下携带完整的服务器地址列表，增量模式下携带相对prev的added与removed，必要时推送完整快照
+func (w *watcher) response(key appKey, eventType pb.EventType, prev, servers []*pb.AppServer) *pb.WatchResponse {
+	resp := &pb.WatchResponse{Event: eventType}
+	if !w.delta {
+		resp.Servers = servers
+		return resp
+	}
+
+	_, resync := w.resync[key]
+	if resync || (w.snapshotEvery > 0 && w.deltas[key] >= w.snapshotEvery) {
+		delete(w.resync, key)
+		delete(w.deltas, key)
+		resp.Servers = servers
+		resp.Snapshot = true
+		return resp
+	}
+
+	resp.Added, resp.Removed = diffServers(prev, servers)
+	if len(servers) == 0 {
+		delete(w.deltas, key)
+	} else {
+		w.deltas[key]++
+	}
+	return resp
+}
+
+// eventHistory 保存某个app最近的事件
+type eventHistory struct {
+	events []*RegistryEvent
+
+	// 小于等于compactRev的事件已经被丢弃，无法补发
+	compactRev int64
+
+	// base 最后一个被丢弃的事件之后app的服务器地址列表，即events[0]之前的列表
+	base []*pb.AppServer
+}
+
+// serversAt 返回revision为rev时app的服务器地址列表，rev不能小于compactRev
+func (h *eventHistory) serversAt(rev int64) []*pb.AppServer {
+	servers := h.base
+	for _, ev := range h.events {
+		if ev.Revision > rev {
+			break
+		}
+		servers = ev.Servers
+	}
+	return servers
+}
+
+func (h *eventHistory) append(ev *RegistryEvent) {
+	if len(h.events) >= maxHistoryEvents {
+		// watcherStore启动之前的事件同样会被记录，compactRev不能小于baseRev
+		if h.events[0].Revision > h.compactRev {
+			h.compactRev = h.events[0].Revision
+		}
+		h.base = h.events[0].Servers
+		h.events = h.events[1:]
+	}
+	h.events = append(h.events, ev)
+}
+
+type watcherSet map[*watcher]struct{}
+
+// watcherIndex 按照app索引watcher, 注册中心的变更只需要查找关注该app的watcher
+type watcherIndex map[appKey]watcherSet
+
+func (wi watcherIndex) add(key appKey, w *watcher) {
+	set, ok := wi[key]
+	if !ok {
+		set = make(watcherSet)
+		wi[key] = set
+	}
+	set[w] = struct{}{}
+}
+
+// remove 返回watcher是否存在
+func (wi watcherIndex) remove(key appKey, w *watcher) bool {
+	set, ok := wi[key]
+	if !ok {
+		return false
+	}
+	if _, ok := set[w]; !ok {
+		return false
+	}
+	delete(set, w)
+	if len(set) == 0 {
+		delete(wi, key)
+	}
+	return true
 }
 
 type watcherStore struct {
 	mu sync.RWMutex
 
-	watchers map[string]*watcher
+	registry Registry
+
+	// exact 精确匹配的watcher, 按照app索引
+	exact watcherIndex
+
+	// prefixes 前缀匹配的watcher, 按照{name前缀, env}索引, env为空表示匹配所有env
+	prefixes watcherIndex
+
+	histories map[appKey]*eventHistory
+
+	// watcherStore启动时注册中心的revision, 之前的事件无法补发
+	baseRev int64
+
+	// 已经处理的最大revision, 小于等于rev的事件均已推送给watcher
+	rev int64
+
+	lg *zap.Logger
 }
 
-func newWatcherStore() *watcherStore {
+func newWatcherStore(registry Registry, lg *zap.Logger) *watcherStore {
 	ws := &watcherStore{
-		watchers: make(map[string]*watcher),
+		registry:  registry,
+		exact:     make(watcherIndex),
+		prefixes:  make(watcherIndex),
+		histories: make(map[appKey]*eventHistory),
+		lg:        lg,
 	}
+	ws.baseRev = registry.Revision()
+	ws.rev = ws.baseRev
 
-	go ws.mockWatch()
+	go ws.syncLoop()
 
 	return ws
 }
 
-func (ws *watcherStore) mockWatch() {
-	ticker := time.NewTicker(2 * time.Second)
-
-	for range ticker.C {
-		ws.mu.RLock()
-		for _, w := range ws.watchers {
-			w.ch <- &pb.WatchResponse{
-				Event: pb.EventType_UPDATE,
-				App: &pb.App{
-					Name: w.name,
-					Env:  w.env,
-				},
-				Servers: []*pb.AppServer{
-					&pb.AppServer{
-						Ip:   "127.0.0.1",
-						Port: "9090",
-					},
-				},
+// syncLoop 消费注册中心的变更事件，记录历史并推送给关注该app的watcher
+func (ws *watcherStore) syncLoop() {
+	for ev := range ws.registry.Events() {
+		key := newAppKey(ev.App)
+
+		ws.mu.Lock()
+		ws.history(key).append(ev)
+		if ev.Revision > ws.rev {
+			ws.rev = ev.Revision
+		}
+
+		for w := range ws.exact[key] {
+			ws.sendEvent(w, ev)
+		}
+
+		// 依次查找app名称的每个前缀，复杂度与app名称的长度相关，与watcher的数量无关
+		for i := 0; i <= len(key.name); i++ {
+			for w := range ws.prefixes[appKey{name: key.name[:i], env: key.env}] {
+				ws.sendEvent(w, ev)
+			}
+			for w := range ws.prefixes[appKey{name: key.name[:i]}] {
+				ws.sendEvent(w, ev)
 			}
 		}
-		ws.mu.RUnlock()
+		ws.mu.Unlock()
 	}
 }
 
-func (ws *watcherStore) createWatch(watchID string, watcher *watcher) {
-	watcher.ch <- &pb.WatchResponse{
-		Created: true,
-		Event:   pb.EventType_UPDATE,
-		App: &pb.App{
-			Name: watcher.name,
-			Env:  watcher.env,
-		},
-		Servers: []*pb.AppServer{
-			&pb.AppServer{
-				Ip:   "127.0.0.1",
-				Port: "9090",
-			},
-		},
+// history 必须在持有mu的情况下调用
+func (ws *watcherStore) history(key appKey) *eventHistory {
+	h, ok := ws.histories[key]
+	if !ok {
+		h = &eventHistory{compactRev: ws.baseRev}
+		ws.histories[key] = h
 	}
+	return h
+}
+
+// sendEvent 必须在持有mu的情况下调用
+func (ws *watcherStore) sendEvent(w *watcher, ev *RegistryEvent) {
+	if ev.Revision <= w.rev {
+		return
+	}
+	w.rev = ev.Revision
+
+	if !w.allowed(newAppKey(ev.App)) {
+		return
+	}
+
+	resp, ok := w.filter(newAppKey(ev.App), ev.Type, ev.Servers)
+	if !ok {
+		return
+	}
+
+	resp.App = ev.App
+	resp.Revision = ev.Revision
+	w.send(resp)
+}
+
+// createWatch startRev大于0时，补发从startRev开始的历史事件，否则推送当前的服务器地址列表
+// watch创建失败时向watcher推送canceled响应并返回false
+func (ws *watcherStore) createWatch(watcher *watcher, startRev int64) bool {
+	// 持有写锁，保证watcher先收到当前的服务器地址或历史事件，再收到后续的变更事件
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if watcher.prefix {
+		return ws.createPrefixWatch(watcher, startRev)
+	}
+
+	app := watcher.app()
+	servers, rev, err := ws.registry.List(app)
+	if err != nil {
+		ws.lg.Warn("createwatch", zap.String("watchID", watcher.watchID), zap.Any("app", app), zap.Error(err))
+		watcher.send(&pb.WatchResponse{
+			Canceled:     true,
+			CancelReason: err.Error(),
+			App:          app,
+		})
+		return false
+	}
+
+	key := watcher.key()
+	if startRev <= 0 {
+		watcher.rev = rev
+		selected := watcher.selectServers(servers)
+		if len(selected) > 0 {
+			watcher.servers[key] = selected
+		}
+		watcher.send(&pb.WatchResponse{
+			Created:  true,
+			Event:    pb.EventType_UPDATE,
+			App:      app,
+			Servers:  selected,
+			Revision: rev,
+			Snapshot: watcher.delta,
+		})
+		ws.exact.add(key, watcher)
+		return true
+	}
+
+	// 注册中心的revision与服务器地址可能领先于尚未处理的事件，补发只依据已经处理的revision与历史事件，
+	// 否则watcher记录的服务器地址会包含之后才推送的事件，增量与selector会漏掉这些变化
+	// startRev大于当前revision+1说明服务端的数据已经重置（例如服务端重启），同样无法补发
+	h := ws.history(key)
+	if startRev <= h.compactRev || startRev > ws.rev+1 {
+		ws.compacted(watcher, startRev, ws.rev, h.compactRev)
+		return false
+	}
+
+	// Created的revision表示客户端已经拥有的数据版本，之后补发的事件均大于该revision
+	watcher.rev = startRev - 1
+	if selected := watcher.selectServers(h.serversAt(watcher.rev)); len(selected) > 0 {
+		watcher.servers[key] = selected
+	}
+	watcher.send(&pb.WatchResponse{
+		Created:  true,
+		Event:    pb.EventType_UPDATE,
+		App:      app,
+		Revision: watcher.rev,
+	})
+
+	for _, ev := range h.events {
+		ws.sendEvent(watcher, ev)
+	}
+	ws.exact.add(key, watcher)
+	return true
+}
+
+// createPrefixWatch 必须在持有mu的情况下调用
+// created响应不携带服务器地址，之后为每个匹配的app推送一个CREATE事件或者补发历史事件
+func (ws *watcherStore) createPrefixWatch(watcher *watcher, startRev int64) bool {
+	if startRev <= 0 {
+		rev := ws.registry.Revision()
+		watcher.rev = rev
+		watcher.send(&pb.WatchResponse{
+			Created:  true,
+			Event:    pb.EventType_UPDATE,
+			App:      watcher.app(),
+			Revision: rev,
+		})
+
+		for _, app := range ws.registry.Apps() {
+			key := newAppKey(app)
+			if !watcher.match(key) {
+				continue
+			}
+
+			servers, _, err := ws.registry.List(app)
+			if err != nil {
+				continue
+			}
+
+			if resp, ok := watcher.filter(key, pb.EventType_CREATE, servers); ok && len(watcher.servers[key]) > 0 {
+				resp.App = app
+				resp.Revision = rev
+				watcher.send(resp)
+			}
+		}
+
+		ws.prefixes.add(watcher.key(), watcher)
+		return true
+	}
+
+	// 所有匹配的app的历史事件都需要覆盖startRev，与createWatch相同，补发只依据已经处理的revision与历史事件
+	compactRev := ws.baseRev
+	var events []*RegistryEvent
+	for key, h := range ws.histories {
+		if !watcher.match(key) {
+			continue
+		}
+		if h.compactRev > compactRev {
+			compactRev = h.compactRev
+		}
+
+		// 已被压缩，之后取消watch
+		if startRev <= h.compactRev {
+			continue
+		}
+		if selected := watcher.selectServers(h.serversAt(startRev - 1)); len(selected) > 0 {
+			watcher.servers[key] = selected
+		}
+		for _, ev := range h.events {
+			if ev.Revision >= startRev {
+				events = append(events, ev)
+			}
+		}
+	}
+
+	if startRev <= compactRev || startRev > ws.rev+1 {
+		ws.compacted(watcher, startRev, ws.rev, compactRev)
+		return false
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Revision < events[j].Revision
+	})
+
+	watcher.rev = startRev - 1
+	watcher.send(&pb.WatchResponse{
+		Created:  true,
+		Event:    pb.EventType_UPDATE,
+		App:      watcher.app(),
+		Revision: watcher.rev,
+	})
+
+	for _, ev := range events {
+		ws.sendEvent(watcher, ev)
+	}
+	ws.prefixes.add(watcher.key(), watcher)
+	return true
+}
+
+// compacted startRev对应的事件已被压缩，推送canceled响应
+func (ws *watcherStore) compacted(watcher *watcher, startRev, rev, compactRev int64) {
+	ws.lg.Warn("createwatch compacted", zap.String("watchID", watcher.watchID), zap.Any("app", watcher.app()),
+		zap.Int64("startRevision", startRev), zap.Int64("compactRevision", compactRev))
+	watcher.send(&pb.WatchResponse{
+		Canceled:        true,
+		CancelReason:    pb.CancelReasonCompacted,
+		App:             watcher.app(),
+		Revision:        rev,
+		CompactRevision: compactRev + 1,
+	})
+}
+
+// progress 推送progress notify, 持有读锁保证小于等于该revision的事件均已先于progress notify放入sendq
+func (ws *watcherStore) progress(sendq *sendQueue) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	sendq.push(&pb.WatchResponse{ProgressNotify: true, Revision: ws.rev})
+}
+
+// snapshot 推送增量模式watch关注的每个app的完整快照，非增量模式的事件本身就是完整的列表
+func (ws *watcherStore) snapshot(w *watcher) {
 	ws.mu.Lock()
-	ws.watchers[watchID] = watcher
-	ws.mu.Unlock()
+	defer ws.mu.Unlock()
+
+	if !w.delta {
+		return
+	}
+	if _, ok := ws.index(w)[w.key()][w]; !ok {
+		return
+	}
+
+	keys := make(map[appKey]struct{})
+	if !w.prefix {
+		keys[w.key()] = struct{}{}
+	}
+	for key := range w.servers {
+		keys[key] = struct{}{}
+	}
+	for key := range w.resync {
+		keys[key] = struct{}{}
+	}
+
+	apps := make([]appKey, 0, len(keys))
+	for key := range keys {
+		apps = append(apps, key)
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		if apps[i].env != apps[j].env {
+			return apps[i].env < apps[j].env
+		}
+		return apps[i].name < apps[j].name
+	})
+
+	for _, key := range apps {
+		delete(w.resync, key)
+		delete(w.deltas, key)
+		w.send(&pb.WatchResponse{
+			Event:    pb.EventType_UPDATE,
+			App:      &pb.App{Name: key.name, Env: key.env},
+			Servers:  w.servers[key],
+			Revision: w.rev,
+			Snapshot: true,
+		})
+	}
+}
 
+// index 返回watcher所在的索引
+func (ws *watcherStore) index(w *watcher) watcherIndex {
+	if w.prefix {
+		return ws.prefixes
+	}
+	return ws.exact
 }
 
-func (ws *watcherStore) cancelWatch(watchID string) {
+func (ws *watcherStore) cancelWatch(w *watcher) {
 	ws.mu.Lock()
-	if w, ok := ws.watchers[watchID]; ok {
-		w.ch <- &pb.WatchResponse{
+	defer ws.mu.Unlock()
+
+	if ws.index(w).remove(w.key(), w) {
+		w.send(&pb.WatchResponse{
 			Canceled:     true,
-			CancelReason: "client stop",
-			Event:        pb.EventType_UPDATE,
-			App: &pb.App{
-				Name: w.name,
-				Env:  w.env,
-			},
-			Servers: []*pb.AppServer{
-				&pb.AppServer{
-					Ip:   "127.0.0.1",
-					Port: "9090",
-				},
-			},
-		}
-	}
-	delete(ws.watchers, watchID)
-	ws.mu.Unlock()
+			CancelReason: pb.CancelReasonClientStop,
+			App:          w.app(),
+			Revision:     w.rev,
+		})
+	}
+}
+
+func serversEqual(a, b []*pb.AppServer) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !proto.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// diffServers 返回cur相对prev新增（包括labels变化）与移除的服务器地址
+func diffServers(prev, cur []*pb.AppServer) (added, removed []*pb.AppServer) {
+	old := make(map[string]*pb.AppServer, len(prev))
+	for _, server := range prev {
+		old[serverKey(server)] = server
+	}
+
+	for _, server := range cur {
+		key := serverKey(server)
+		if o, ok := old[key]; !ok || !proto.Equal(o, server) {
+			added = append(added, server)
+		}
+		delete(old, key)
+	}
+
+	for _, server := range prev {
+		if _, ok := old[serverKey(server)]; ok {
+			removed = append(removed, server)
+		}
+	}
+	return added, removed
 }
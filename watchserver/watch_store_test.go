@@ -0,0 +1,392 @@
+package watchserver
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"go.uber.org/zap"
+)
+
+func server(ip string, labels ...string) *pb.AppServer {
+	s := &pb.AppServer{Ip: ip, Port: "80"}
+	if len(labels) > 0 {
+		s.Labels = map[string]string{}
+		for i := 0; i+1 < len(labels); i += 2 {
+			s.Labels[labels[i]] = labels[i+1]
+		}
+	}
+	return s
+}
+
+func ips(servers []*pb.AppServer) []string {
+	ss := []string{}
+	for _, s := range servers {
+		ss = append(ss, s.Ip)
+	}
+	return ss
+}
+
+// waitSynced waits until ws has handled all the events of the registry.
+func waitSynced(t *testing.T, ws *watcherStore) {
+	t.Helper()
+	waitRev(t, ws, ws.registry.Revision())
+}
+
+// waitRev waits until ws has handled the events up to rev.
+func waitRev(t *testing.T, ws *watcherStore, rev int64) {
+	t.Helper()
+
+	for i := 0; i < 200; i++ {
+		ws.mu.RLock()
+		cur := ws.rev
+		ws.mu.RUnlock()
+		if cur >= rev {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for revision %d", rev)
+}
+
+func TestDiffServers(t *testing.T) {
+	tests := []struct {
+		prev, cur      []*pb.AppServer
+		added, removed []string
+	}{
+		{nil, nil, []string{}, []string{}},
+		{nil, []*pb.AppServer{server("10.0.0.1"), server("10.0.0.2")}, []string{"10.0.0.1", "10.0.0.2"}, []string{}},
+		{[]*pb.AppServer{server("10.0.0.1"), server("10.0.0.2")}, nil, []string{}, []string{"10.0.0.1", "10.0.0.2"}},
+		{[]*pb.AppServer{server("10.0.0.1")}, []*pb.AppServer{server("10.0.0.1")}, []string{}, []string{}},
+		{[]*pb.AppServer{server("10.0.0.1"), server("10.0.0.2")}, []*pb.AppServer{server("10.0.0.2"), server("10.0.0.3")}, []string{"10.0.0.3"}, []string{"10.0.0.1"}},
+		// a server whose labels changed is added again, not removed
+		{[]*pb.AppServer{server("10.0.0.1", "zone", "a")}, []*pb.AppServer{server("10.0.0.1", "zone", "b")}, []string{"10.0.0.1"}, []string{}},
+		{[]*pb.AppServer{server("10.0.0.1", "zone", "a")}, []*pb.AppServer{server("10.0.0.1")}, []string{"10.0.0.1"}, []string{}},
+	}
+
+	for i, tt := range tests {
+		added, removed := diffServers(tt.prev, tt.cur)
+		if got := ips(added); !reflect.DeepEqual(got, tt.added) {
+			t.Errorf("#%d: expected added %v, got %v", i, tt.added, got)
+		}
+		if got := ips(removed); !reflect.DeepEqual(got, tt.removed) {
+			t.Errorf("#%d: expected removed %v, got %v", i, tt.removed, got)
+		}
+	}
+}
+
+func TestEventHistoryServersAt(t *testing.T) {
+	defer func(n int) { maxHistoryEvents = n }(maxHistoryEvents)
+	maxHistoryEvents = 3
+
+	h := &eventHistory{compactRev: 5}
+	if servers := h.serversAt(5); servers != nil {
+		t.Errorf("expected no servers without any event, got %v", ips(servers))
+	}
+
+	for _, ev := range []*RegistryEvent{
+		{Revision: 10, Servers: []*pb.AppServer{server("10.0.0.1")}},
+		{Revision: 12, Servers: []*pb.AppServer{server("10.0.0.1"), server("10.0.0.2")}},
+		{Revision: 15, Servers: []*pb.AppServer{server("10.0.0.2")}},
+		// the event of revision 10 is compacted
+		{Revision: 18, Servers: []*pb.AppServer{server("10.0.0.2"), server("10.0.0.3")}},
+	} {
+		h.append(ev)
+	}
+
+	tests := []struct {
+		rev      int64
+		expected []string
+	}{
+		// the servers of the compacted event are kept
+		{10, []string{"10.0.0.1"}},
+		{11, []string{"10.0.0.1"}},
+		{12, []string{"10.0.0.1", "10.0.0.2"}},
+		{14, []string{"10.0.0.1", "10.0.0.2"}},
+		{15, []string{"10.0.0.2"}},
+		{17, []string{"10.0.0.2"}},
+		{18, []string{"10.0.0.2", "10.0.0.3"}},
+		{20, []string{"10.0.0.2", "10.0.0.3"}},
+	}
+
+	for i, tt := range tests {
+		if got := ips(h.serversAt(tt.rev)); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("#%d: expected servers %v, got %v", i, tt.expected, got)
+		}
+	}
+}
+
+func TestEventHistoryCompaction(t *testing.T) {
+	defer func(n int) { maxHistoryEvents = n }(maxHistoryEvents)
+	maxHistoryEvents = 2
+
+	h := &eventHistory{compactRev: 5}
+	tests := []struct {
+		rev        int64
+		compactRev int64
+		events     int
+	}{
+		// events before the watcher store started never lower the compact revision
+		{2, 5, 1},
+		{3, 5, 2},
+		{4, 5, 2},
+		{6, 5, 2},
+		{7, 5, 2},
+		{8, 6, 2},
+		{10, 7, 2},
+	}
+
+	for i, tt := range tests {
+		h.append(&RegistryEvent{Revision: tt.rev})
+		if h.compactRev != tt.compactRev {
+			t.Errorf("#%d: expected compact revision %d, got %d", i, tt.compactRev, h.compactRev)
+		}
+		if len(h.events) != tt.events {
+			t.Errorf("#%d: expected %d events, got %d", i, tt.events, len(h.events))
+		}
+	}
+}
+
+func TestWatcherStoreCreateWatch(t *testing.T) {
+	defer func(n int) { maxHistoryEvents = n }(maxHistoryEvents)
+	maxHistoryEvents = 3
+
+	registry := NewMemoryRegistry()
+	defer registry.Close()
+	ws := newWatcherStore(registry, zap.NewNop())
+	base := ws.baseRev
+
+	app := &pb.App{Name: "svc", Env: "qa"}
+	registry.Register(app, server("10.0.0.1"), time.Minute)                               // base+1
+	registry.Register(app, server("10.0.0.2"), time.Minute)                               // base+2
+	registry.Register(&pb.App{Name: "other", Env: "qa"}, server("10.0.1.1"), time.Minute) // base+3
+	registry.Deregister(app, server("10.0.0.1"))                                          // base+4
+	registry.Register(app, server("10.0.0.3"), time.Minute)                               // base+5, base+1 compacted
+	registry.Register(app, server("10.0.0.4"), time.Minute)                               // base+6, base+2 compacted
+	waitSynced(t, ws)
+
+	tests := []struct {
+		startRev int64
+		prefix   bool
+
+		canceled bool
+		// revisions of the events resent after the created response
+		revs []int64
+		// servers of the created response without a start revision
+		servers []string
+	}{
+		{0, false, false, nil, []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"}},
+		// events before the watcher store started
+		{base, false, true, nil, nil},
+		{base - 100, false, true, nil, nil},
+		// compacted
+		{base + 1, false, true, nil, nil},
+		{base + 2, false, true, nil, nil},
+		{base + 3, false, false, []int64{base + 4, base + 5, base + 6}, nil},
+		{base + 5, false, false, []int64{base + 5, base + 6}, nil},
+		{base + 7, false, false, []int64{}, nil},
+		// the server was reset
+		{base + 8, false, true, nil, nil},
+		{1, false, true, nil, nil},
+		// a prefix watch is compacted as soon as one of the apps is
+		{base + 2, true, true, nil, nil},
+		{base + 3, true, false, []int64{base + 4, base + 5, base + 6}, nil},
+		{base + 7, true, false, []int64{}, nil},
+		{base + 8, true, true, nil, nil},
+	}
+
+	for i, tt := range tests {
+		req := &pb.WatchCreateRequest{WatchId: "w", App: app, Prefix: tt.prefix}
+		if tt.prefix {
+			req.App = &pb.App{Name: "svc"}
+		}
+		w := newWatcher(req, newSendQueue())
+		created := ws.createWatch(w, tt.startRev)
+
+		resps := w.sendq.take()
+		if len(resps) == 0 {
+			t.Fatalf("#%d: expected a response", i)
+		}
+		if created == tt.canceled || resps[0].Canceled != tt.canceled {
+			t.Errorf("#%d: expected canceled %v, got %v", i, tt.canceled, resps[0])
+			continue
+		}
+		if tt.canceled {
+			if resps[0].CancelReason != pb.CancelReasonCompacted {
+				t.Errorf("#%d: expected cancel reason %s, got %s", i, pb.CancelReasonCompacted, resps[0].CancelReason)
+			}
+			continue
+		}
+		ws.cancelWatch(w)
+
+		if !resps[0].Created {
+			t.Errorf("#%d: expected created, got %v", i, resps[0])
+		}
+		if tt.startRev <= 0 {
+			if got := ips(resps[0].Servers); !reflect.DeepEqual(got, tt.servers) {
+				t.Errorf("#%d: expected servers %v, got %v", i, tt.servers, got)
+			}
+			continue
+		}
+
+		if resps[0].Revision != tt.startRev-1 {
+			t.Errorf("#%d: expected created revision %d, got %d", i, tt.startRev-1, resps[0].Revision)
+		}
+		revs := []int64{}
+		for _, resp := range resps[1:] {
+			revs = append(revs, resp.Revision)
+		}
+		if !reflect.DeepEqual(revs, tt.revs) {
+			t.Errorf("#%d: expected events %v, got %v", i, tt.revs, revs)
+		}
+	}
+}
+
+func TestWatcherStorePrefixIndex(t *testing.T) {
+	registry := NewMemoryRegistry()
+	defer registry.Close()
+	ws := newWatcherStore(registry, zap.NewNop())
+
+	tests := []struct {
+		app    *pb.App
+		prefix bool
+
+		// apps of the events received
+		expected []string
+	}{
+		{&pb.App{Name: "svc"}, true, []string{"svc-a/prod", "svc-a/qa", "svc-b/prod"}},
+		{&pb.App{Name: "svc", Env: "qa"}, true, []string{"svc-a/qa"}},
+		{&pb.App{Name: "svc-b"}, true, []string{"svc-b/prod"}},
+		{&pb.App{Name: "svc-a", Env: "dev"}, true, []string{}},
+		// an empty prefix matches all the apps
+		{&pb.App{}, true, []string{"other/qa", "svc-a/prod", "svc-a/qa", "svc-b/prod"}},
+		{&pb.App{Env: "prod"}, true, []string{"svc-a/prod", "svc-b/prod"}},
+		{&pb.App{Name: "svc-a", Env: "qa"}, false, []string{"svc-a/qa"}},
+		{&pb.App{Name: "svc", Env: "qa"}, false, []string{}},
+	}
+
+	watchers := make([]*watcher, len(tests))
+	for i, tt := range tests {
+		watchers[i] = newWatcher(&pb.WatchCreateRequest{WatchId: "w", App: tt.app, Prefix: tt.prefix}, newSendQueue())
+		if !ws.createWatch(watchers[i], 0) {
+			t.Fatalf("#%d: expected the watch to be created", i)
+		}
+		watchers[i].sendq.take()
+	}
+
+	for _, app := range []*pb.App{
+		{Name: "svc-a", Env: "qa"},
+		{Name: "svc-a", Env: "prod"},
+		{Name: "svc-b", Env: "prod"},
+		{Name: "other", Env: "qa"},
+	} {
+		registry.Register(app, server("10.0.0.1"), time.Minute)
+	}
+	waitSynced(t, ws)
+
+	for i, tt := range tests {
+		apps := []string{}
+		for _, resp := range watchers[i].sendq.take() {
+			apps = append(apps, resp.App.Name+"/"+resp.App.Env)
+		}
+		sort.Strings(apps)
+		if !reflect.DeepEqual(apps, tt.expected) {
+			t.Errorf("#%d: expected events of %v, got %v", i, tt.expected, apps)
+		}
+	}
+
+	// canceled watchers are removed from the index
+	for i, w := range watchers {
+		ws.cancelWatch(w)
+		if resps := w.sendq.take(); len(resps) != 1 || !resps[0].Canceled {
+			t.Errorf("#%d: expected a canceled response, got %v", i, resps)
+		}
+		if ws.index(w).remove(w.key(), w) {
+			t.Errorf("#%d: expected the watcher to be removed from the index", i)
+		}
+	}
+	if len(ws.exact) != 0 || len(ws.prefixes) != 0 {
+		t.Errorf("expected empty indexes, got %d exact and %d prefixes", len(ws.exact), len(ws.prefixes))
+	}
+
+	registry.Register(&pb.App{Name: "svc-a", Env: "qa"}, server("10.0.0.2"), time.Minute)
+	waitSynced(t, ws)
+	for i, w := range watchers {
+		if resps := w.sendq.take(); len(resps) != 0 {
+			t.Errorf("#%d: expected no event after cancel, got %v", i, resps)
+		}
+	}
+}
+
+// laggingRegistry holds the events of the registry back until they are released.
+type laggingRegistry struct {
+	Registry
+
+	eventc chan *RegistryEvent
+}
+
+func (r *laggingRegistry) Events() <-chan *RegistryEvent { return r.eventc }
+
+func (r *laggingRegistry) release() { r.eventc <- <-r.Registry.Events() }
+
+func TestWatcherStoreResumeLagging(t *testing.T) {
+	registry := &laggingRegistry{Registry: NewMemoryRegistry(), eventc: make(chan *RegistryEvent)}
+	defer registry.Close()
+	ws := newWatcherStore(registry, zap.NewNop())
+	base := ws.baseRev
+
+	app := &pb.App{Name: "svc", Env: "qa"}
+	registry.Register(app, server("10.0.0.1", "zone", "a"), time.Minute)
+	registry.release()
+	waitRev(t, ws, base+1)
+
+	// the registry is ahead of the events handled by ws
+	registry.Register(app, server("10.0.0.2", "zone", "a"), time.Minute)
+
+	tests := []struct {
+		req *pb.WatchCreateRequest
+
+		// the servers known to the watcher and the added servers after the lagging event is handled
+		servers, added []string
+	}{
+		{&pb.WatchCreateRequest{App: app, Delta: true}, []string{"10.0.0.1", "10.0.0.2"}, []string{"10.0.0.2"}},
+		{&pb.WatchCreateRequest{App: &pb.App{Name: "svc"}, Prefix: true, Delta: true}, []string{"10.0.0.1", "10.0.0.2"}, []string{"10.0.0.2"}},
+		{&pb.WatchCreateRequest{App: app, Selector: map[string]string{"zone": "a"}}, []string{"10.0.0.1", "10.0.0.2"}, []string{}},
+	}
+
+	// the clients have the servers of revision base+1
+	watchers := make([]*watcher, len(tests))
+	for i, tt := range tests {
+		tt.req.WatchId = "w"
+		watchers[i] = newWatcher(tt.req, newSendQueue())
+		if !ws.createWatch(watchers[i], base+2) {
+			t.Fatalf("#%d: expected the watch to be created", i)
+		}
+		if resps := watchers[i].sendq.take(); len(resps) != 1 || !resps[0].Created || resps[0].Revision != base+1 {
+			t.Fatalf("#%d: expected only the created response of revision %d, got %v", i, base+1, resps)
+		}
+	}
+
+	registry.release()
+	waitRev(t, ws, base+2)
+
+	for i, tt := range tests {
+		resps := watchers[i].sendq.take()
+		if len(resps) != 1 {
+			t.Fatalf("#%d: expected the lagging event, got %v", i, resps)
+		}
+		servers := resps[0].Servers
+		if tt.req.Delta {
+			servers = watchers[i].servers[newAppKey(app)]
+		}
+		if got := ips(servers); !reflect.DeepEqual(got, tt.servers) {
+			t.Errorf("#%d: expected servers %v, got %v", i, tt.servers, got)
+		}
+		if got := ips(resps[0].Added); !reflect.DeepEqual(got, tt.added) {
+			t.Errorf("#%d: expected added %v, got %v", i, tt.added, got)
+		}
+	}
+}
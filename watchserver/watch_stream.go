@@ -1,9 +1,11 @@
 package watchserver
 
 import (
+	"errors"
 	"io"
 	"strings"
 	"sync"
+	"time"
 
 	pb "github.com/xkeyideal/grpcwatch/watchpb"
 
@@ -13,14 +15,28 @@ import (
 )
 
 type serverWatchStream struct {
-	watchID string
+	// mu protects watchers and closed
+	mu sync.Mutex
+
+	// 同一个stream上复用的所有watch, 通过watch_id区分
+	watchers map[string]*watcher
+
+	// closed cancelAll之后置为true, recvLoop不再创建新的watch
+	closed bool
 
 	grpcStream pb.WatchRPC_WatchServer
 
-	watchStream chan *pb.WatchResponse
+	// sendq 待发送给客户端的响应, watcherStore持有锁时向其中放入响应, 不会阻塞
+	sendq *sendQueue
 
 	watcherStore *watcherStore
 
+	// 定时推送progress notify的时间间隔, 为0时不推送
+	progressInterval time.Duration
+
+	// authorize 判断该stream的客户端是否有权限watch app，为nil时不鉴权
+	authorize func(app *pb.App) bool
+
 	wg sync.WaitGroup
 
 	lg *zap.Logger
@@ -31,19 +47,21 @@ type serverWatchStream struct {
 func (sws *serverWatchStream) sendLoop() {
 	for {
 		select {
-		case wresp := <-sws.watchStream:
-			serr := sws.grpcStream.Send(wresp)
-			if serr != nil {
-				if isClientCtxErr(sws.grpcStream.Context().Err(), serr) {
-					if sws.lg != nil {
-						sws.lg.Debug("failed to send watch response to gRPC stream", zap.Error(serr))
-					}
-				} else {
-					if sws.lg != nil {
-						sws.lg.Warn("failed to send watch response to gRPC stream", zap.Error(serr))
+		case <-sws.sendq.notifyc:
+			for _, wresp := range sws.sendq.take() {
+				serr := sws.grpcStream.Send(wresp)
+				if serr != nil {
+					if isClientCtxErr(sws.grpcStream.Context().Err(), serr) {
+						if sws.lg != nil {
+							sws.lg.Debug("failed to send watch response to gRPC stream", zap.Error(serr))
+						}
+					} else {
+						if sws.lg != nil {
+							sws.lg.Warn("failed to send watch response to gRPC stream", zap.Error(serr))
+						}
 					}
+					return
 				}
-				return
 			}
 		case <-sws.closec:
 			return
@@ -51,6 +69,21 @@ func (sws *serverWatchStream) sendLoop() {
 	}
 }
 
+// progressLoop 定时推送progress notify, 客户端据此判断stream是否存活
+func (sws *serverWatchStream) progressLoop() {
+	ticker := time.NewTicker(sws.progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sws.watcherStore.progress(sws.sendq)
+		case <-sws.closec:
+			return
+		}
+	}
+}
+
 func (sws *serverWatchStream) recvLoop() error {
 	for {
 		req, err := sws.grpcStream.Recv()
@@ -64,29 +97,102 @@ func (sws *serverWatchStream) recvLoop() error {
 		switch uv := req.RequestUnion.(type) {
 		case *pb.WatchRequest_CreateRequest:
 			if uv.CreateRequest == nil {
-				goto exit
+				continue
 			}
 
 			sws.lg.Info("WatchRequest_CreateRequest", zap.String("watchID", uv.CreateRequest.WatchId), zap.Any("req", uv.CreateRequest.App))
 
-			w := newWatcher(uv.CreateRequest.App, sws.watchStream)
-			sws.watcherStore.createWatch(uv.CreateRequest.WatchId, w)
-			sws.watchID = uv.CreateRequest.WatchId
+			sws.createWatch(uv.CreateRequest)
 		case *pb.WatchRequest_CancelRequest:
+			if uv.CancelRequest == nil {
+				continue
+			}
+
 			sws.lg.Info("WatchRequest_CancelRequest", zap.String("watchID", uv.CancelRequest.WatchId))
 
-			if uv.CancelRequest != nil {
-				id := uv.CancelRequest.WatchId
-				sws.watcherStore.cancelWatch(id)
+			sws.cancelWatch(uv.CancelRequest.WatchId)
+		case *pb.WatchRequest_ProgressRequest:
+			sws.watcherStore.progress(sws.sendq)
+		case *pb.WatchRequest_SnapshotRequest:
+			if uv.SnapshotRequest == nil {
+				continue
 			}
-			goto exit
+
+			sws.snapshot(uv.SnapshotRequest.WatchId)
 		default:
 			continue
 		}
 	}
+}
+
+func (sws *serverWatchStream) createWatch(req *pb.WatchCreateRequest) {
+	w := newWatcher(req, sws.sendq)
+	w.authorize = sws.authorize
+
+	// 前缀模式的watch在推送每个app时鉴权
+	if !req.Prefix && sws.authorize != nil && !sws.authorize(w.app()) {
+		w.send(&pb.WatchResponse{
+			Canceled:     true,
+			CancelReason: pb.CancelReasonPermissionDenied,
+			App:          req.App,
+		})
+		return
+	}
+
+	sws.mu.Lock()
+	defer sws.mu.Unlock()
+
+	// stream已经关闭, 创建的watch不会再被cancelAll取消
+	if sws.closed {
+		return
+	}
+
+	if _, ok := sws.watchers[req.WatchId]; ok {
+		w.send(&pb.WatchResponse{
+			Canceled:     true,
+			CancelReason: pb.CancelReasonDuplicateWatchID,
+			App:          req.App,
+		})
+		return
+	}
+
+	if sws.watcherStore.createWatch(w, req.StartRevision) {
+		sws.watchers[req.WatchId] = w
+	}
+}
+
+func (sws *serverWatchStream) cancelWatch(watchID string) {
+	sws.mu.Lock()
+	w, ok := sws.watchers[watchID]
+	delete(sws.watchers, watchID)
+	sws.mu.Unlock()
+
+	if ok {
+		sws.watcherStore.cancelWatch(w)
+	}
+}
 
-exit:
-	return nil
+func (sws *serverWatchStream) snapshot(watchID string) {
+	sws.mu.Lock()
+	w, ok := sws.watchers[watchID]
+	sws.mu.Unlock()
+
+	if ok {
+		sws.watcherStore.snapshot(w)
+	}
+}
+
+// cancelAll stream关闭后，取消该stream上所有的watch
+func (sws *serverWatchStream) cancelAll() {
+	sws.mu.Lock()
+	watchers := sws.watchers
+	sws.watchers = make(map[string]*watcher)
+	sws.closed = true
+	sws.mu.Unlock()
+
+	for _, w := range watchers {
+		sws.watcherStore.cancelWatch(w)
+	}
 }
 
 func (sws *serverWatchStream) close() {
@@ -94,6 +200,63 @@ func (sws *serverWatchStream) close() {
 	sws.wg.Wait()
 }
 
+// 每个watch stream最多缓存的待发送响应个数, 超过后认为客户端过慢, 断开该stream,
+// 客户端重连后从已收到的revision之后补发事件
+var maxPendingResponses = 4096
+
+var ErrWatchStreamTooSlow = errors.New("watchserver: watch stream too slow")
+
+// sendQueue 缓存待发送给客户端的响应, push不会阻塞, 保证watcherStore与注册中心不会被过慢的客户端拖住
+type sendQueue struct {
+	mu    sync.Mutex
+	resps []*pb.WatchResponse
+
+	// notifyc 有新的响应时通知sendLoop
+	notifyc chan struct{}
+
+	// overflowc 缓存的响应超过maxPendingResponses后关闭
+	overflowc chan struct{}
+	overflow  bool
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{
+		notifyc:   make(chan struct{}, 1),
+		overflowc: make(chan struct{}),
+	}
+}
+
+func (q *sendQueue) push(resp *pb.WatchResponse) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.overflow {
+		return
+	}
+	if len(q.resps) >= maxPendingResponses {
+		q.overflow = true
+		q.resps = nil
+		close(q.overflowc)
+		return
+	}
+
+	q.resps = append(q.resps, resp)
+	select {
+	case q.notifyc <- struct{}{}:
+	default:
+	}
+}
+
+// take 按照放入的顺序取出所有缓存的响应
+func (q *sendQueue) take() []*pb.WatchResponse {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	resps := q.resps
+	q.resps = nil
+	return resps
+}
+
 func isClientCtxErr(ctxErr error, err error) bool {
 	if ctxErr != nil {
 		return true
@@ -2,10 +2,14 @@ package watchserver
 
 import (
 	"context"
+	"io"
+	"time"
 
 	pb "github.com/xkeyideal/grpcwatch/watchpb"
 
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type WatchRpcServer struct {
@@ -13,28 +17,76 @@ type WatchRpcServer struct {
 
 	lg *zap.Logger
 
+	// 注册请求未携带ttl时采用的心跳超时时间
+	defaultTTL time.Duration
+
+	// watch stream推送progress notify的时间间隔
+	progressInterval time.Duration
+
+	registry Registry
+
+	// authorizer 为nil时不鉴权
+	authorizer Authorizer
+
+	// writeAuthorizer Register/Deregister/Heartbeat的鉴权，为nil时不鉴权
+	writeAuthorizer Authorizer
+
 	watcherStore *watcherStore
 }
 
-func NewWatchRpcServer(lg *zap.Logger, watcherStore *watcherStore) *WatchRpcServer {
+func NewWatchRpcServer(cfg *GrpcServerConfig, lg *zap.Logger, registry Registry) *WatchRpcServer {
 	return &WatchRpcServer{
-		lg:           lg,
-		watcherStore: watcherStore,
+		lg:               lg,
+		defaultTTL:       time.Duration(cfg.RegisterTTL) * time.Second,
+		progressInterval: time.Duration(cfg.ProgressNotifyInterval) * time.Second,
+		registry:         registry,
+		authorizer:       cfg.Authorizer,
+		writeAuthorizer:  cfg.writeAuthorizer(),
+		watcherStore:     newWatcherStore(registry, lg),
 	}
 }
 
+// authorizer 返回ctx中的身份对应的鉴权函数，不鉴权时返回nil
+func (s *WatchRpcServer) authorize(ctx context.Context) func(app *pb.App) bool {
+	if s.authorizer == nil {
+		return nil
+	}
+	identity, _ := IdentityFromContext(ctx)
+	return func(app *pb.App) bool {
+		return s.authorizer.Authorize(identity, app)
+	}
+}
+
+// authorizeWrite 判断ctx中的身份是否有权限注册、注销、续约app的服务器地址，
+// MemberApp只能由服务端自身直接写入注册中心，不允许通过RPC写入
+func (s *WatchRpcServer) authorizeWrite(ctx context.Context, app *pb.App) error {
+	if isMemberApp(app) {
+		return status.Error(codes.PermissionDenied, ErrMemberAppReadOnly.Error())
+	}
+	if s.writeAuthorizer == nil {
+		return nil
+	}
+	identity, _ := IdentityFromContext(ctx)
+	if !s.writeAuthorizer.Authorize(identity, app) {
+		return status.Error(codes.PermissionDenied, pb.CancelReasonPermissionDenied)
+	}
+	return nil
+}
+
 func (s *WatchRpcServer) GetAppServers(ctx context.Context, app *pb.App) (*pb.GetAppResponse, error) {
+	if authorize := s.authorize(ctx); authorize != nil && !authorize(app) {
+		return nil, status.Error(codes.PermissionDenied, pb.CancelReasonPermissionDenied)
+	}
+
+	servers, rev, err := s.registry.List(app)
+	if err != nil {
+		return nil, togRPCError(err)
+	}
+
 	return &pb.GetAppResponse{
-		App: &pb.App{
-			Name: "name",
-			Env:  "qa",
-		},
-		Servers: []*pb.AppServer{
-			&pb.AppServer{
-				Ip:   "127.0.0.1",
-				Port: "8080",
-			},
-		},
+		App:      app,
+		Servers:  servers,
+		Revision: rev,
 	}, nil
 }
 
@@ -43,11 +95,14 @@ func (s *WatchRpcServer) Watch(stream pb.WatchRPC_WatchServer) error {
 	var err error
 
 	sws := &serverWatchStream{
-		grpcStream:   stream,
-		watcherStore: s.watcherStore,
-		watchStream:  make(chan *pb.WatchResponse, 16),
-		lg:           s.lg,
-		closec:       make(chan struct{}),
+		watchers:         make(map[string]*watcher),
+		grpcStream:       stream,
+		watcherStore:     s.watcherStore,
+		progressInterval: s.progressInterval,
+		authorize:        s.authorize(stream.Context()),
+		sendq:            newSendQueue(),
+		lg:               s.lg,
+		closec:           make(chan struct{}),
 	}
 
 	sws.wg.Add(1)
@@ -56,28 +111,136 @@ func (s *WatchRpcServer) Watch(stream pb.WatchRPC_WatchServer) error {
 		sws.wg.Done()
 	}()
 
+	if sws.progressInterval > 0 {
+		sws.wg.Add(1)
+		go func() {
+			sws.progressLoop()
+			sws.wg.Done()
+		}()
+	}
+
 	errc := make(chan error, 1)
 
 	go func() {
-		if rerr := sws.recvLoop(); rerr != nil {
+		rerr := sws.recvLoop()
+		if rerr != nil {
 			// 如果客户端主动断开连接，这里会记录日志
 			// grpc 错误码为： code = Canceled, desc = context canceled
 			if isClientCtxErr(stream.Context().Err(), rerr) {
 				sws.lg.Error("isClientCtxErr", zap.String("err", rerr.Error()))
 			}
-
-			errc <- rerr
 		}
+
+		errc <- rerr
 	}()
 
 	select {
 	case err = <-errc:
-		close(sws.watchStream)
 	case <-stream.Context().Done():
 		err = stream.Context().Err()
+		// stream结束后Recv立即返回, 等待recvLoop退出, 避免其正在创建的watch在cancelAll之后加入watcherStore
+		<-errc
+	case <-sws.sendq.overflowc:
+		// 客户端过慢, 断开stream, 客户端重连后从已收到的revision之后补发事件
+		sws.lg.Warn("watch stream too slow", zap.Int("maxPendingResponses", maxPendingResponses))
+		err = status.Error(codes.Unavailable, ErrWatchStreamTooSlow.Error())
 	}
 
-	s.watcherStore.cancelWatch(sws.watchID)
+	// 先关闭sendLoop, 之后放入sendq的响应不再发送
+	// recvLoop仍在运行时(例如客户端过慢), cancelAll之后recvLoop不会再创建watch
 	sws.close()
+	sws.cancelAll()
 	return err
 }
+
+func (s *WatchRpcServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
+	if err := s.authorizeWrite(ctx, req.App); err != nil {
+		return nil, err
+	}
+
+	ttl := time.Duration(req.Ttl) * time.Second
+	if ttl <= 0 {
+		ttl = s.defaultTTL
+	}
+
+	if err := s.registry.Register(req.App, req.Server, ttl); err != nil {
+		return nil, togRPCError(err)
+	}
+
+	s.lg.Info("register", zap.Any("app", req.App), zap.Any("server", req.Server), zap.Duration("ttl", ttl))
+
+	return &pb.RegisterResponse{
+		Ttl: int64(ttl / time.Second),
+	}, nil
+}
+
+func (s *WatchRpcServer) Deregister(ctx context.Context, req *pb.DeregisterRequest) (*pb.Empty, error) {
+	if err := s.authorizeWrite(ctx, req.App); err != nil {
+		return nil, err
+	}
+
+	if err := s.registry.Deregister(req.App, req.Server); err != nil {
+		return nil, togRPCError(err)
+	}
+
+	s.lg.Info("deregister", zap.Any("app", req.App), zap.Any("server", req.Server))
+
+	return &pb.Empty{}, nil
+}
+
+func (s *WatchRpcServer) Heartbeat(stream pb.WatchRPC_HeartbeatServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.authorizeWrite(stream.Context(), req.App); err != nil {
+			return err
+		}
+
+		// 服务器地址已过期或未注册时返回NotFound，客户端需要重新注册
+		ttl, err := s.registry.KeepAlive(req.App, req.Server)
+		if err != nil {
+			return togRPCError(err)
+		}
+
+		if err := stream.Send(&pb.HeartbeatResponse{Ttl: int64(ttl / time.Second)}); err != nil {
+			return err
+		}
+	}
+}
+
+// MemberList 返回注册到MemberApp的所有服务端地址
+func (s *WatchRpcServer) MemberList(ctx context.Context, _ *pb.Empty) (*pb.MemberListResponse, error) {
+	members, rev, err := s.registry.List(MemberApp)
+	if err != nil {
+		return nil, togRPCError(err)
+	}
+
+	endpoints := make([]string, 0, len(members))
+	for _, member := range members {
+		endpoints = append(endpoints, serverKey(member))
+	}
+
+	return &pb.MemberListResponse{
+		Endpoints: endpoints,
+		Revision:  rev,
+	}, nil
+}
+
+// togRPCError 将注册中心的错误转换为gRPC错误码
+func togRPCError(err error) error {
+	switch err {
+	case ErrInvalidApp, ErrInvalidAppServer:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case ErrAppServerNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case ErrRegistryClosed:
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
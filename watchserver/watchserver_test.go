@@ -0,0 +1,103 @@
+package watchserver
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	pb "github.com/xkeyideal/grpcwatch/watchpb"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// heartbeatStream sends reqs to the Heartbeat handler and records its responses.
+type heartbeatStream struct {
+	grpc.ServerStream
+
+	ctx   context.Context
+	reqs  []*pb.HeartbeatRequest
+	resps []*pb.HeartbeatResponse
+}
+
+func (s *heartbeatStream) Context() context.Context { return s.ctx }
+
+func (s *heartbeatStream) Recv() (*pb.HeartbeatRequest, error) {
+	if len(s.reqs) == 0 {
+		return nil, io.EOF
+	}
+	req := s.reqs[0]
+	s.reqs = s.reqs[1:]
+	return req, nil
+}
+
+func (s *heartbeatStream) Send(resp *pb.HeartbeatResponse) error {
+	s.resps = append(s.resps, resp)
+	return nil
+}
+
+func newTestRpcServer(t *testing.T, cfg *GrpcServerConfig) *WatchRpcServer {
+	t.Helper()
+
+	registry := NewMemoryRegistry()
+	t.Cleanup(func() { registry.Close() })
+	return NewWatchRpcServer(cfg, zap.NewNop(), registry)
+}
+
+func TestWatchRpcServerRegister(t *testing.T) {
+	s := newTestRpcServer(t, &GrpcServerConfig{RegisterTTL: 30})
+	ctx := context.Background()
+	app := &pb.App{Name: "svc", Env: "qa"}
+
+	tests := []struct {
+		ttl int64
+
+		expected int64
+	}{
+		// the server's RegisterTTL without a ttl
+		{0, 30},
+		{10, 10},
+	}
+
+	for i, tt := range tests {
+		resp, err := s.Register(ctx, &pb.RegisterRequest{App: app, Server: server("10.0.0.1"), Ttl: tt.ttl})
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if resp.Ttl != tt.expected {
+			t.Errorf("#%d: expected ttl %d, got %d", i, tt.expected, resp.Ttl)
+		}
+
+		hs := &heartbeatStream{ctx: ctx, reqs: []*pb.HeartbeatRequest{{App: app, Server: server("10.0.0.1")}}}
+		if err := s.Heartbeat(hs); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if len(hs.resps) != 1 || hs.resps[0].Ttl != tt.expected {
+			t.Errorf("#%d: expected a heartbeat with ttl %d, got %v", i, tt.expected, hs.resps)
+		}
+	}
+
+	resp, err := s.GetAppServers(ctx, app)
+	if err != nil || len(resp.Servers) != 1 {
+		t.Fatalf("expected the registered server, got %v (%v)", resp, err)
+	}
+
+	if _, err := s.Deregister(ctx, &pb.DeregisterRequest{App: app, Server: server("10.0.0.1")}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := s.GetAppServers(ctx, app); err != nil || len(resp.Servers) != 0 {
+		t.Errorf("expected no servers after deregistering, got %v (%v)", resp, err)
+	}
+
+	// a deregistered server has to register again
+	hs := &heartbeatStream{ctx: ctx, reqs: []*pb.HeartbeatRequest{{App: app, Server: server("10.0.0.1")}}}
+	if err := s.Heartbeat(hs); status.Code(err) != codes.NotFound {
+		t.Errorf("expected %v, got %v", codes.NotFound, err)
+	}
+
+	if _, err := s.Register(ctx, &pb.RegisterRequest{App: &pb.App{Name: "svc"}, Server: server("10.0.0.1")}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected %v, got %v", codes.InvalidArgument, err)
+	}
+}